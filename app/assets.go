@@ -0,0 +1,106 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+var (
+	// Templates and static files embedded in the binary, rooted at the
+	// templates directory. Set by main
+	EmbeddedTemplates fs.FS
+
+	// Chart scripts embedded in the binary. Set by main
+	EmbeddedScripts fs.FS
+)
+
+// File system overlaying layers of files. Files are opened from the first
+// layer that has them so files on disk can override individual embedded
+// files. Directory listings are merged
+type overlayFS []fs.FS
+
+// Files in dir, if set, overriding the files in base, if set
+func newOverlayFS(dir string, base fs.FS) overlayFS {
+	var o overlayFS
+	if dir != "" {
+		o = append(o, os.DirFS(dir))
+	}
+	if base != nil {
+		o = append(o, base)
+	}
+
+	return o
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	entries := []fs.DirEntry{}
+	found := false
+	for _, layer := range o {
+		list, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		found = true
+		for _, e := range list {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	return entries, nil
+}
+
+// Templates and static files. Files in the configured template directory
+// override the embedded files
+func (a *AppContext) Assets() fs.FS {
+	return newOverlayFS(a.Tmpldir, EmbeddedTemplates)
+}
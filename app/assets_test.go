@@ -0,0 +1,124 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/viper"
+)
+
+func TestOverlayFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "about.html"), []byte("site"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := fstest.MapFS{
+		"about.html":        {Data: []byte("embedded")},
+		"index.html":        {Data: []byte("index")},
+		"static/styles.css": {Data: []byte("css")},
+	}
+
+	o := newOverlayFS(dir, base)
+	data, err := fs.ReadFile(o, "about.html")
+	if err != nil || string(data) != "site" {
+		t.Errorf("File on disk should override embedded file: %s %v", data, err)
+	}
+
+	data, err = fs.ReadFile(o, "static/styles.css")
+	if err != nil || string(data) != "css" {
+		t.Errorf("Embedded file should be used when not on disk: %s %v", data, err)
+	}
+
+	names, err := fs.Glob(o, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "about.html,index.html" {
+		t.Errorf("Directory listing should be merged: %v", names)
+	}
+
+	_, err = o.Open("missing.html")
+	if !os.IsNotExist(err) {
+		t.Errorf("Missing file should not exist: %v", err)
+	}
+
+	o = newOverlayFS(filepath.Join(dir, "missing"), base)
+	data, err = fs.ReadFile(o, "about.html")
+	if err != nil || string(data) != "embedded" {
+		t.Errorf("Missing template directory should fall back to embedded files: %s %v", data, err)
+	}
+}
+
+func TestDevMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	EmbeddedTemplates = os.DirFS(filepath.Join("..", "templates"))
+	defer func() { EmbeddedTemplates = nil }()
+
+	viper.Set("templates", dir)
+	viper.Set("dsn", ":memory:")
+	defer viper.Set("templates", nil)
+	defer viper.Set("dsn", nil)
+
+	a, err := NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	about := filepath.Join(dir, "about.html")
+	render := func(text string) string {
+		err := ioutil.WriteFile(about, []byte(`{{define "content"}}`+text+`{{end}}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		a.RenderTemplate(rr, "about.html", nil)
+		return rr.Body.String()
+	}
+
+	if !strings.Contains(render("first"), "About DENSS") {
+		t.Errorf("Templates should not be reloaded without dev_mode")
+	}
+
+	viper.Set("dev_mode", true)
+	defer viper.Set("dev_mode", false)
+
+	if body := render("second"); !strings.Contains(body, "second") || !strings.Contains(body, "</html>") {
+		t.Errorf("Template should be reloaded with the layout in dev_mode: %s", body)
+	}
+}
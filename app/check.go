@@ -0,0 +1,254 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Check the config file was found and parses
+func checkConfigFile() error {
+	return viper.ReadInConfig()
+}
+
+// Check log_level and log_format
+func checkLogging() error {
+	_, err := NewFormatter()
+	if err != nil {
+		return err
+	}
+
+	_, err = logrus.ParseLevel(viper.GetString("log_level"))
+	if err != nil {
+		return fmt.Errorf("Invalid log_level %q: %s", viper.GetString("log_level"), err)
+	}
+
+	return nil
+}
+
+// Check the database driver and, for MySQL, the dsn. Times are scanned into
+// time.Time so the MySQL dsn must set parseTime=true
+func checkDatabaseConfig() error {
+	switch viper.GetString("driver") {
+	case "sqlite3":
+		return nil
+	case "mysql":
+		cfg, err := mysql.ParseDSN(viper.GetString("dsn"))
+		if err != nil {
+			return fmt.Errorf("Invalid MySQL dsn: %s", err)
+		}
+		if !cfg.ParseTime {
+			return fmt.Errorf("MySQL dsn must set parseTime=true")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Invalid driver %q: must be mysql or sqlite3", viper.GetString("driver"))
+}
+
+// Check base_url is an absolute http(s) URL. It's used in emails and webhooks
+func checkBaseURL() error {
+	u, err := url.Parse(viper.GetString("base_url"))
+	if err != nil {
+		return fmt.Errorf("Invalid base_url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("base_url must be an absolute http or https URL")
+	}
+
+	return nil
+}
+
+// Check the embedded templates are available and the override directory, if
+// set, exists
+func checkTemplateDir() error {
+	if EmbeddedTemplates == nil {
+		return errors.New("Embedded templates are missing from this build")
+	}
+
+	dir := viper.GetString("templates")
+	if dir == "" {
+		return nil
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return nil
+}
+
+// Check the sender address and SMTP settings used for notifications
+func checkNotifications() error {
+	_, err := mail.ParseAddress(viper.GetString("email_from"))
+	if err != nil {
+		return fmt.Errorf("Invalid email_from %q: %s", viper.GetString("email_from"), err)
+	}
+
+	switch strings.ToLower(viper.GetString("smtp_tls")) {
+	case "", "none", "starttls", "tls":
+	default:
+		return fmt.Errorf("Invalid smtp_tls mode %s. Should be none, starttls or tls", viper.GetString("smtp_tls"))
+	}
+
+	_, err = smtpAuth()
+	return err
+}
+
+func checkWebhooks() error {
+	_, err := configWebhooks()
+	return err
+}
+
+// Checks of the loaded config that don't need a database connection
+func ConfigChecks() []Check {
+	checks := []Check{
+		{Name: "config file", Run: checkConfigFile},
+		{Name: "logging", Run: checkLogging},
+		{Name: "database config", Run: checkDatabaseConfig},
+		{Name: "base_url", Run: checkBaseURL},
+		{Name: "template directory", Run: checkTemplateDir},
+		{Name: "webhooks", Run: checkWebhooks},
+	}
+	if viper.GetBool("enable_notifications") {
+		checks = append(checks, Check{Name: "notifications", Run: checkNotifications})
+	}
+
+	return checks
+}
+
+// Check the database schema is current
+func (a *AppContext) checkSchema() error {
+	return model.CheckSchema(a.DB)
+}
+
+// Sample job used to render the templates
+func sampleJob() *model.Job {
+	now := time.Now()
+	job := &model.Job{
+		ID:        1,
+		Name:      "check",
+		Token:     "check",
+		Email:     "check@example.com",
+		Status:    model.StatusName(model.StatusComplete),
+		StatusID:  model.StatusComplete,
+		FileType:  "dat",
+		Submitted: &now,
+		Started:   &now,
+		Completed: &now,
+	}
+
+	return job
+}
+
+// Render every page and email template with sample data
+func (a *AppContext) checkRenderTemplates() error {
+	job := sampleJob()
+	now := time.Now()
+	data := map[string]interface{}{
+		"job":         job,
+		"jobs":        []*model.Job{job},
+		"comparison":  &model.Comparison{Token: "check", JobAName: "a", JobBName: "b", Status: job.Status, Submitted: &now, Completed: &now},
+		"errors":      model.FieldErrors{},
+		"values":      url.Values{},
+		"outboxStats": map[string]int{},
+	}
+
+	names := make([]string, 0, len(a.templates))
+	for name := range a.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var buf bytes.Buffer
+		err := a.templates[name].ExecuteTemplate(&buf, "layout", data)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range model.Events {
+		_, _, _, err := a.renderEmail(newEmailData(job, e.Status, "Log excerpt"))
+		if err != nil {
+			return err
+		}
+	}
+
+	digest := &DigestData{
+		Recipient: job.Email,
+		Items: []*DigestItem{{
+			DigestEntry: &model.DigestEntry{JobID: job.ID, JobName: job.Name, JobToken: job.Token, Recipient: job.Email, Event: model.EventCompleted, Created: &now},
+			Event:       model.FindEvent(model.EventCompleted),
+		}},
+	}
+	_, _, _, err := a.renderMessage("digest", "Digest", digest)
+
+	return err
+}
+
+// Checks of the database and templates and, if notifications are enabled,
+// the SMTP server
+func (a *AppContext) PreflightChecks() []Check {
+	checks := []Check{
+		{Name: "database", Run: a.checkDB},
+		{Name: "schema", Run: a.checkSchema},
+		{Name: "templates", Run: a.checkRenderTemplates},
+	}
+	if viper.GetBool("enable_notifications") {
+		checks = append(checks, Check{Name: "smtp", Run: CheckSMTP})
+	}
+
+	return checks
+}
+
+// Print a line for each check result followed by a summary. Returns the
+// number of failed checks
+func PrintReport(w io.Writer, results []*CheckResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.OK {
+			fmt.Fprintf(w, "PASS  %s\n", r.Name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL  %s: %s\n", r.Name, r.Error)
+	}
+
+	fmt.Fprintf(w, "\n%d of %d checks passed\n", len(results)-failed, len(results))
+
+	return failed
+}
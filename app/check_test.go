@@ -0,0 +1,100 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckDatabaseConfig(t *testing.T) {
+	defer viper.Set("driver", nil)
+	defer viper.Set("dsn", nil)
+
+	viper.Set("driver", "mysql")
+	viper.Set("dsn", "user:pass@tcp(127.0.0.1:3306)/denssweb")
+	err := checkDatabaseConfig()
+	if err == nil || !strings.Contains(err.Error(), "parseTime") {
+		t.Errorf("MySQL dsn without parseTime should fail: %v", err)
+	}
+
+	viper.Set("dsn", "user:pass@tcp(127.0.0.1:3306)/denssweb?parseTime=true")
+	if err := checkDatabaseConfig(); err != nil {
+		t.Errorf("Valid MySQL dsn should pass: %s", err)
+	}
+
+	viper.Set("driver", "postgres")
+	if checkDatabaseConfig() == nil {
+		t.Errorf("Unsupported driver should fail")
+	}
+}
+
+func TestCheckBaseURL(t *testing.T) {
+	defer viper.Set("base_url", nil)
+
+	for u, ok := range map[string]bool{
+		"https://denss.example.edu": true,
+		"http://localhost:8080":     true,
+		"denss.example.edu":         false,
+		"ftp://denss.example.edu":   false,
+	} {
+		viper.Set("base_url", u)
+		if err := checkBaseURL(); (err == nil) != ok {
+			t.Errorf("%s: expected ok=%v got %v", u, ok, err)
+		}
+	}
+}
+
+func TestPreflightChecks(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	defer viper.Set("templates", nil)
+	defer viper.Set("dsn", nil)
+
+	a, err := NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, ok := RunChecks(a.PreflightChecks())
+	if !ok {
+		var buf bytes.Buffer
+		PrintReport(&buf, results)
+		t.Errorf("Preflight checks should pass:\n%s", buf.String())
+	}
+}
+
+func TestPrintReport(t *testing.T) {
+	var buf bytes.Buffer
+	failed := PrintReport(&buf, []*CheckResult{
+		{Name: "database", OK: true},
+		{Name: "smtp", Error: "connection refused"},
+	})
+
+	if failed != 1 {
+		t.Errorf("Expected 1 failed check got %d", failed)
+	}
+	expected := "PASS  database\nFAIL  smtp: connection refused\n\n1 of 2 checks passed\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+}
@@ -19,8 +19,10 @@ package app
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -36,7 +38,25 @@ import (
 func init() {
 	viper.SetDefault("smtp_port", 25)
 	viper.SetDefault("smtp_host", "127.0.0.1")
+	viper.SetDefault("smtp_tls", "none")
+	viper.SetDefault("smtp_auth", "plain")
+	viper.SetDefault("smtp_timeout", 30)
+	viper.SetDefault("outbox_interval", 15)
+	viper.SetDefault("outbox_retry_delay", 60)
+	viper.SetDefault("outbox_max_delay", 21600)
+	viper.SetDefault("outbox_max_attempts", 10)
+	viper.SetDefault("digest_interval", 3600)
+	viper.SetDefault("job_webhooks", false)
+	viper.SetDefault("job_webhooks_allow_private", false)
+	viper.SetDefault("webhook_interval", 15)
+	viper.SetDefault("webhook_timeout", 10)
+	viper.SetDefault("webhook_retry_delay", 60)
+	viper.SetDefault("webhook_max_delay", 21600)
+	viper.SetDefault("webhook_max_attempts", 10)
+	viper.SetDefault("job_retention_days", 0)
+	viper.SetDefault("expiry_notice_days", 3)
 	viper.SetDefault("enable_notifications", false)
+	viper.SetDefault("map_threshold_sigma", 1.0)
 	viper.SetDefault("driver", "sqlite3")
 	dbPath := "/tmp/denssweb.db"
 	wd, err := os.Getwd()
@@ -44,16 +64,8 @@ func init() {
 		dbPath = filepath.Join(wd, "denssweb.db")
 	}
 	viper.SetDefault("dsn", fmt.Sprintf("%s?_busy_timeout=5000&cache=shared", dbPath))
-
-	tmpldir := filepath.Join(wd, "dist", "templates")
-	if _, err := os.Stat(tmpldir); err == nil {
-		viper.SetDefault("templates", tmpldir)
-	} else {
-		tmpldir = filepath.Join(wd, "templates")
-		if _, err := os.Stat(tmpldir); err == nil {
-			viper.SetDefault("templates", tmpldir)
-		}
-	}
+	viper.SetDefault("templates", "")
+	viper.SetDefault("dev_mode", false)
 }
 
 type AppContext struct {
@@ -74,38 +86,48 @@ func NewAppContext() (*AppContext, error) {
 		return nil, err
 	}
 
-	tmpldir := viper.GetString("templates")
-	if len(tmpldir) == 0 {
-		log.Warn("Template directory not set. Server will not work")
-		tmpldir = "templates"
-	} else {
-		log.WithFields(log.Fields{
-			"path": tmpldir,
-		}).Info("Using template directory")
-	}
+	return newAppContext(db)
+}
 
-	tmpls, err := filepath.Glob(tmpldir + "/*.html")
+// App context for checking an installation. The database is opened without
+// creating or upgrading the schema so the check doesn't change it
+func NewCheckContext() (*AppContext, error) {
+	db, err := model.OpenDB(viper.GetString("driver"), viper.GetString("dsn"))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	funcMap := template.FuncMap{
-		"Split": split,
+	return newAppContext(db)
+}
+
+func newAppContext(db *sqlx.DB) (*AppContext, error) {
+	_, err := configWebhooks()
+	if err != nil {
+		return nil, err
 	}
 
-	templates := make(map[string]*template.Template)
-	for _, t := range tmpls {
-		base := filepath.Base(t)
-		if base != "layout.html" {
-			templates[base] = template.Must(template.New("layout").Funcs(funcMap).ParseFiles(t,
-				tmpldir+"/layout.html"))
-		}
+	tmpldir := viper.GetString("templates")
+	if len(tmpldir) == 0 {
+		log.Info("Using embedded templates")
+	} else if _, err := os.Stat(tmpldir); err != nil {
+		log.WithFields(log.Fields{
+			"path":  tmpldir,
+			"error": err,
+		}).Warn("Template directory not found. Using embedded templates")
+	} else {
+		log.WithFields(log.Fields{
+			"path": tmpldir,
+		}).Info("Using template directory. Files found here override the embedded templates")
 	}
 
 	app := &AppContext{}
 	app.Tmpldir = tmpldir
 	app.DB = db
-	app.templates = templates
+
+	app.templates, err = app.loadTemplates()
+	if err != nil {
+		return nil, err
+	}
 
 	app.Decoder = schema.NewDecoder()
 	app.Decoder.IgnoreUnknownKeys(true)
@@ -120,9 +142,59 @@ func (app *AppContext) RenderNotFound(w http.ResponseWriter) {
 	app.RenderTemplate(w, "404.html", nil)
 }
 
-// Render template t using template parameters in data.
+// Parse the page template name with the layout
+func (app *AppContext) parseTemplate(name string) (*template.Template, error) {
+	funcMap := template.FuncMap{
+		"Split": split,
+	}
+
+	return template.New("layout").Funcs(funcMap).ParseFS(app.Assets(), name, "layout.html")
+}
+
+// Parse all page templates
+func (app *AppContext) loadTemplates() (map[string]*template.Template, error) {
+	names, err := fs.Glob(app.Assets(), "*.html")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("No templates found. Set templates to the template directory")
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, name := range names {
+		if name == "layout.html" {
+			continue
+		}
+
+		t, err := app.parseTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+
+	return templates, nil
+}
+
+// Render template t using template parameters in data. In dev_mode the
+// template is parsed again on every request
 func (app *AppContext) RenderTemplate(w http.ResponseWriter, name string, data interface{}) {
-	t := app.templates[name]
+	t, ok := app.templates[name]
+	if viper.GetBool("dev_mode") {
+		var err error
+		t, err = app.parseTemplate(name)
+		ok = err == nil
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("failed to parse template")
+		}
+	}
+	if !ok {
+		http.Error(w, "Fatal error rendering template", http.StatusInternalServerError)
+		return
+	}
 
 	var buf bytes.Buffer
 	err := t.ExecuteTemplate(&buf, "layout", data)
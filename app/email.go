@@ -19,17 +19,62 @@ package app
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
 	"mime/quotedprintable"
-	"net/smtp"
 	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/model"
 )
 
+// A job parameter shown in emails
+type emailParam struct {
+	Label string
+	Value string
+}
+
+// Data passed to the email templates
+type EmailData struct {
+	Job    *model.Job
+	Status string
+	URL    string
+
+	// Parameters used for the job
+	Params []*emailParam
+
+	// Estimated FSC resolution (Å). Zero if not known
+	Resolution float64
+
+	// Inline chart images included with the HTML email. Reference them
+	// with cid:fsc and cid:summary
+	FSCChart     bool
+	SummaryChart bool
+
+	// Last lines of the job log for failed jobs
+	LogExcerpt string
+
+	// Time the job results are deleted. Nil unless job_retention_days is set
+	Expires *time.Time
+
+	// Link to stop notifications for the job. Empty if secret_key is not set
+	UnsubscribeURL string
+}
+
 func quotedBody(body []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	w := quotedprintable.NewWriter(&buf)
@@ -46,60 +91,220 @@ func quotedBody(body []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (a *AppContext) SendEmail(toEmail, status, jobURL string, jid int64) error {
-	if !viper.GetBool("enable_notifications") {
-		log.Info("Attempting to send email but notifications are turned off")
-		return nil
+// File system and path of an email template. Templates in email_templates
+// override the templates shipped in the email directory of the templates
+func (a *AppContext) emailTemplate(name string) (fs.FS, string, bool) {
+	if dir := viper.GetString("email_templates"); dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return os.DirFS(dir), name, true
+		}
 	}
 
-	if len(viper.GetString("email_from")) == 0 {
-		return errors.New("Invalid from address. Please configure a from address before sending email")
+	assets := a.Assets()
+	path := "email/" + name
+	if _, err := fs.Stat(assets, path); err == nil {
+		return assets, path, true
+	}
+
+	return nil, "", false
+}
+
+func newEmailData(job *model.Job, status, logExcerpt string) *EmailData {
+	data := &EmailData{
+		Job:            job,
+		Status:         status,
+		URL:            job.URL(),
+		FSCChart:       len(job.FSCChart) > 0,
+		SummaryChart:   len(job.SummaryChart) > 0,
+		LogExcerpt:     logExcerpt,
+		Expires:        JobExpires(job),
+		UnsubscribeURL: unsubscribeURL(job.Token, job.Email),
+	}
+
+	for _, p := range model.Params {
+		if p.IsUsed(job) {
+			data.Params = append(data.Params, &emailParam{Label: p.Label, Value: p.Display(job)})
+		}
+	}
+
+	if len(job.FSCData) > 0 {
+		var fsc chart.FSC
+		if err := json.Unmarshal(job.FSCData, &fsc); err == nil {
+			data.Resolution = fsc.Resolution()
+		}
 	}
 
-	log.WithFields(log.Fields{
-		"email": toEmail,
-	}).Info("Sending email")
+	return data
+}
 
-	text := fmt.Sprintf(`
-DENSSWeb Job %d
+// Render the subject, plain text and HTML body of the email for the job
+// status. The HTML body is nil if there's no HTML template for the status
+func (a *AppContext) renderEmail(data *EmailData) (string, []byte, []byte, error) {
+	subject := fmt.Sprintf("[DENSSWeb] Job %d - %s", data.Job.ID, data.Status)
+	return a.renderMessage(strings.ToLower(data.Status), subject, data)
+}
 
-Status: %s
+// Render the name.txt and optional name.html email templates. The subject
+// defaults to the given subject unless the text template defines one
+func (a *AppContext) renderMessage(name, subject string, data interface{}) (string, []byte, []byte, error) {
+	fsys, path, ok := a.emailTemplate(name + ".txt")
+	if !ok {
+		return "", nil, nil, fmt.Errorf("Missing email template %s.txt", name)
+	}
 
-To view your job please visit the following URL:
+	tmpl, err := template.ParseFS(fsys, path)
+	if err != nil {
+		return "", nil, nil, err
+	}
 
-    %s
+	var text bytes.Buffer
+	err = tmpl.Execute(&text, data)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if tmpl.Lookup("subject") != nil {
+		var buf bytes.Buffer
+		err = tmpl.ExecuteTemplate(&buf, "subject", data)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		subject = strings.TrimSpace(buf.String())
+	}
 
-Cheers!
-	`, jid, status, jobURL)
+	fsys, path, ok = a.emailTemplate(name + ".html")
+	if !ok {
+		return subject, text.Bytes(), nil, nil
+	}
 
-	qtext, err := quotedBody([]byte(text))
+	htmlTmpl, err := htmltemplate.ParseFS(fsys, path)
 	if err != nil {
-		return err
+		return "", nil, nil, err
 	}
 
+	var html bytes.Buffer
+	err = htmlTmpl.Execute(&html, data)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return subject, text.Bytes(), html.Bytes(), nil
+}
+
+// Message headers of a notification email. Emails with an unsubscribe link
+// support one-click unsubscribe (RFC 8058)
+func newHeader(to, subject, unsubscribe string) textproto.MIMEHeader {
 	header := make(textproto.MIMEHeader)
-	header.Set("Mime-Version", "1.0")
 	header.Set("Date", time.Now().Format(time.RFC1123Z))
-	header.Set("To", toEmail)
-	header.Set("Subject", fmt.Sprintf("[DENSSWeb] Job %d - %s", jid, status))
+	header.Set("To", to)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
 	header.Set("From", viper.GetString("email_from"))
-	header.Set("Content-Type", "text/plain; charset=UTF-8")
+	if unsubscribe != "" {
+		header.Set("List-Unsubscribe", fmt.Sprintf("<%s>", unsubscribe))
+		header.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	return header
+}
+
+// Write a quoted-printable part
+func writeQuoted(mw *multipart.Writer, contentType string, body []byte) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", contentType)
 	header.Set("Content-Transfer-Encoding", "quoted-printable")
 
-	c, err := smtp.Dial(fmt.Sprintf("%s:%d", viper.GetString("smtp_host"), viper.GetInt("smtp_port")))
+	w, err := mw.CreatePart(header)
 	if err != nil {
 		return err
 	}
-	defer c.Close()
 
-	c.Mail(viper.GetString("email_from"))
-	c.Rcpt(toEmail)
+	qbody, err := quotedBody(body)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(qbody)
+	return err
+}
 
-	wc, err := c.Data()
+// Write a base64 encoded inline PNG referenced with cid:name
+func writeImage(mw *multipart.Writer, name string, img []byte) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", "image/png")
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-ID", fmt.Sprintf("<%s>", name))
+	header.Set("Content-Disposition", fmt.Sprintf("inline; filename=%s.png", name))
+
+	w, err := mw.CreatePart(header)
 	if err != nil {
 		return err
 	}
-	defer wc.Close()
+
+	enc := base64.StdEncoding.EncodeToString(img)
+	for len(enc) > 76 {
+		if _, err = io.WriteString(w, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = io.WriteString(w, enc+"\r\n")
+	return err
+}
+
+// Build the MIME message. With an HTML body the message is
+// multipart/alternative with the plain text and a multipart/related part
+// holding the HTML and inline images
+func buildMessage(header textproto.MIMEHeader, text, html []byte, images map[string][]byte) ([]byte, error) {
+	var body bytes.Buffer
+
+	header.Set("Mime-Version", "1.0")
+	if html == nil {
+		header.Set("Content-Type", "text/plain; charset=UTF-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		qtext, err := quotedBody(text)
+		if err != nil {
+			return nil, err
+		}
+		body.Write(qtext)
+	} else {
+		alt := multipart.NewWriter(&body)
+		header.Set("Content-Type", "multipart/alternative; boundary="+alt.Boundary())
+
+		err := writeQuoted(alt, "text/plain; charset=UTF-8", text)
+		if err != nil {
+			return nil, err
+		}
+
+		var relBody bytes.Buffer
+		rel := multipart.NewWriter(&relBody)
+		err = writeQuoted(rel, "text/html; charset=UTF-8", html)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"fsc", "summary"} {
+			if img := images[name]; len(img) > 0 {
+				if err := writeImage(rel, name, img); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := rel.Close(); err != nil {
+			return nil, err
+		}
+
+		relHeader := make(textproto.MIMEHeader)
+		relHeader.Set("Content-Type", "multipart/related; boundary="+rel.Boundary())
+		w, err := alt.CreatePart(relHeader)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := relBody.WriteTo(w); err != nil {
+			return nil, err
+		}
+		if err := alt.Close(); err != nil {
+			return nil, err
+		}
+	}
 
 	var buf bytes.Buffer
 	for k, vv := range header {
@@ -108,13 +313,71 @@ Cheers!
 		}
 	}
 	fmt.Fprintf(&buf, "\r\n")
+	body.WriteTo(&buf)
 
-	if _, err = buf.WriteTo(wc); err != nil {
+	return buf.Bytes(), nil
+}
+
+// Queue a notification email for the job event in the outbox. Events the
+// submitter did not choose are skipped and events of jobs submitted in digest
+// mode are held back for the next digest. The email is rendered from the
+// templates named after the event status in the email template dir.
+// logExcerpt is included in emails for failed jobs
+func (a *AppContext) QueueEmail(job *model.Job, event, logExcerpt string) error {
+	if !viper.GetBool("enable_notifications") {
+		log.Info("Attempting to send email but notifications are turned off")
+		return nil
+	}
+
+	e := model.FindEvent(event)
+	if e == nil {
+		return fmt.Errorf("Invalid notification event: %s", event)
+	}
+
+	if !job.Notifies(event) {
+		return nil
+	}
+
+	if len(viper.GetString("email_from")) == 0 {
+		return errors.New("Invalid from address. Please configure a from address before sending email")
+	}
+
+	if job.Digest {
+		log.WithFields(JobFields(job)).WithFields(log.Fields{
+			"email": job.Email,
+			"event": event,
+		}).Info("Holding back email for digest")
+
+		return model.QueueDigest(a.DB, &model.DigestEntry{
+			JobID:     job.ID,
+			Recipient: job.Email,
+			Event:     event,
+		})
+	}
+
+	log.WithFields(JobFields(job)).WithFields(log.Fields{
+		"email": job.Email,
+		"event": event,
+	}).Info("Queueing email")
+
+	data := newEmailData(job, e.Status, logExcerpt)
+	subject, text, html, err := a.renderEmail(data)
+	if err != nil {
 		return err
 	}
-	if _, err = wc.Write(qtext); err != nil {
+
+	msg, err := buildMessage(newHeader(job.Email, subject, data.UnsubscribeURL), text, html, map[string][]byte{
+		"fsc":     job.FSCChart,
+		"summary": job.SummaryChart,
+	})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return model.QueueEmail(a.DB, &model.OutboxMessage{
+		JobID:     job.ID,
+		Recipient: job.Email,
+		Subject:   subject,
+		Message:   msg,
+	})
 }
@@ -0,0 +1,163 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+func testJob() *model.Job {
+	fsc, _ := json.Marshal(map[string]interface{}{
+		"frequency":   []float64{0.01, 0.02, 0.03},
+		"correlation": []float64{1.0, 0.6, 0.2},
+	})
+
+	job := &model.Job{ID: 7, Name: "lysozyme", Email: "user@example.edu", Token: "abc", Task: "Run DENSS", LogMessage: "DENSS failed"}
+	job.Dmax = 50
+	job.Mode = "fast"
+	job.Enantiomer = true
+	now := time.Now()
+	job.Started = &now
+	job.FSCData = fsc
+	job.FSCChart = []byte("fsc png")
+	job.SummaryChart = []byte("summary png")
+	return job
+}
+
+func TestRenderEmail(t *testing.T) {
+	a := &AppContext{Tmpldir: filepath.Join("..", "templates")}
+
+	subject, text, html, err := a.renderEmail(newEmailData(testJob(), "COMPLETED", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject != "[DENSSWeb] Job 7 lysozyme - Completed" {
+		t.Errorf("Incorrect subject: %s", subject)
+	}
+	for _, s := range []string{"Estimated maximum dimension: 50\n", "Mode: fast", "/job/abc"} {
+		if !bytes.Contains(text, []byte(s)) {
+			t.Errorf("Text email missing %q:\n%s", s, text)
+		}
+	}
+	for _, s := range []string{"cid:fsc", "cid:summary", "lysozyme"} {
+		if !bytes.Contains(html, []byte(s)) {
+			t.Errorf("HTML email missing %q", s)
+		}
+	}
+
+	_, text, _, err = a.renderEmail(newEmailData(testJob(), "FAILED", "line 1\nline 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Failed step: Run DENSS", "line 2"} {
+		if !bytes.Contains(text, []byte(s)) {
+			t.Errorf("Failure email missing %q:\n%s", s, text)
+		}
+	}
+}
+
+func TestEmailOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "submitted.txt"), []byte("Site job {{ .Job.ID }}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("email_templates", dir)
+	defer viper.Set("email_templates", "")
+
+	a := &AppContext{Tmpldir: filepath.Join("..", "templates")}
+	subject, text, html, err := a.renderEmail(newEmailData(testJob(), "SUBMITTED", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(text) != "Site job 7" || subject != "[DENSSWeb] Job 7 - SUBMITTED" {
+		t.Errorf("Site template not used: %s %s", subject, text)
+	}
+	if !bytes.Contains(html, []byte("View your job")) {
+		t.Errorf("HTML template should fall back to the default")
+	}
+}
+
+func TestBuildMessage(t *testing.T) {
+	header := make(textproto.MIMEHeader)
+	header.Set("Subject", "test")
+
+	msg, err := buildMessage(header, []byte("plain"), []byte("<p>html</p>"), map[string][]byte{"fsc": []byte("png")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Message should be multipart/alternative: got %s", mediaType)
+	}
+
+	var types []string
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		types = append(types, mediaType)
+		if mediaType == "multipart/related" {
+			rr := multipart.NewReader(p, params["boundary"])
+			for {
+				rp, err := rr.NextPart()
+				if err != nil {
+					break
+				}
+				types = append(types, rp.Header.Get("Content-Type"))
+			}
+		}
+	}
+
+	expected := "text/plain,multipart/related,text/html; charset=UTF-8,image/png"
+	if strings.Join(types, ",") != expected {
+		t.Errorf("Incorrect message parts: got %s should be %s", strings.Join(types, ","), expected)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+)
+
+var (
+	// Templates the server can't work without
+	requiredTemplates = []string{"index.html", "job.html", "404.html", "error.html"}
+)
+
+// A named health check
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Outcome of a health check
+type CheckResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Health status and the results of each check
+type HealthStatus struct {
+	Status string         `json:"status"`
+	Checks []*CheckResult `json:"checks"`
+}
+
+// Run all checks in order. Returns true if all checks passed
+func RunChecks(checks []Check) ([]*CheckResult, bool) {
+	results := make([]*CheckResult, 0, len(checks))
+	ok := true
+	for _, c := range checks {
+		res := &CheckResult{Name: c.Name, OK: true}
+		if err := c.Run(); err != nil {
+			res.OK = false
+			res.Error = err.Error()
+			ok = false
+		}
+		results = append(results, res)
+	}
+
+	return results, ok
+}
+
+// Handler running the checks on each request. Responds 200 if all checks
+// pass and 503 otherwise with the results as JSON
+func HealthHandler(checks func() []Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, ok := RunChecks(checks())
+
+		status := &HealthStatus{Status: "ok", Checks: results}
+		code := http.StatusOK
+		if !ok {
+			status.Status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// Check the database connection
+func (a *AppContext) checkDB() error {
+	return a.DB.Ping()
+}
+
+// Check the required templates were loaded and the static files are
+// available
+func (a *AppContext) checkTemplates() error {
+	for _, name := range requiredTemplates {
+		if _, ok := a.templates[name]; !ok {
+			return fmt.Errorf("Template %s not found", name)
+		}
+	}
+
+	fi, err := fs.Stat(a.Assets(), "static")
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("Static files path is not a directory")
+	}
+
+	return nil
+}
+
+// Checks required for the server to handle requests
+func (a *AppContext) ReadyChecks() []Check {
+	return []Check{
+		{Name: "database", Run: a.checkDB},
+		{Name: "templates", Run: a.checkTemplates},
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestHealthHandler(t *testing.T) {
+	checks := []Check{
+		{Name: "good", Run: func() error { return nil }},
+	}
+
+	rr := httptest.NewRecorder()
+	HealthHandler(func() []Check { return checks }).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 got %d", rr.Code)
+	}
+
+	checks = append(checks, Check{Name: "bad", Run: func() error { return errors.New("broken") }})
+	rr = httptest.NewRecorder()
+	HealthHandler(func() []Check { return checks }).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 got %d", rr.Code)
+	}
+
+	status := &HealthStatus{}
+	err := json.Unmarshal(rr.Body.Bytes(), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "fail" || len(status.Checks) != 2 || !status.Checks[0].OK || status.Checks[1].Error != "broken" {
+		t.Errorf("Unexpected health status: %+v", status)
+	}
+}
+
+func TestReadyChecks(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	defer viper.Set("templates", nil)
+	defer viper.Set("dsn", nil)
+
+	a, err := NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, ok := RunChecks(a.ReadyChecks())
+	if !ok {
+		t.Fatalf("Checks should pass: %+v", results[1])
+	}
+
+	delete(a.templates, "job.html")
+	results, ok = RunChecks(a.ReadyChecks())
+	if ok || results[1].OK {
+		t.Errorf("Missing template should fail")
+	}
+
+	a.DB.Close()
+	results, _ = RunChecks(a.ReadyChecks())
+	if results[0].OK {
+		t.Errorf("Closed database should fail")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+func init() {
+	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "text")
+
+	worker := "denssweb"
+	if host, err := os.Hostname(); err == nil {
+		worker = host
+	}
+	viper.SetDefault("worker_name", fmt.Sprintf("%s-%d", worker, os.Getpid()))
+}
+
+// Log formatter for the configured log_format. Both the process log and the
+// per-job log files use it so they share one schema
+func NewFormatter() (logrus.Formatter, error) {
+	switch strings.ToLower(viper.GetString("log_format")) {
+	case "text", "":
+		return &logrus.TextFormatter{FullTimestamp: true}, nil
+	case "json":
+		return &logrus.JSONFormatter{}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid log_format %q: must be text or json", viper.GetString("log_format"))
+}
+
+// Configure the format and level of the process log. debug overrides
+// log_level
+func ConfigureLogging(debug bool) error {
+	formatter, err := NewFormatter()
+	if err != nil {
+		return err
+	}
+
+	level := logrus.DebugLevel
+	if !debug {
+		level, err = logrus.ParseLevel(viper.GetString("log_level"))
+		if err != nil {
+			return fmt.Errorf("Invalid log_level %q: %s", viper.GetString("log_level"), err)
+		}
+	}
+
+	logrus.SetFormatter(formatter)
+	logrus.SetLevel(level)
+
+	return nil
+}
+
+// Name identifying this worker in the logs of the jobs it runs
+func WorkerName() string {
+	return viper.GetString("worker_name")
+}
+
+// Fields identifying a job in log entries
+func JobFields(job *model.Job) logrus.Fields {
+	return logrus.Fields{
+		"job_id": job.ID,
+		"token":  job.Token,
+	}
+}
+
+// Logger for the log file of a job run by this worker. Entries have the same
+// format and job and worker fields as the process log. Messages below info
+// are only included when the process log is at debug level
+func JobLogger(out io.Writer, job *model.Job) *logrus.Entry {
+	log := logrus.New()
+	log.Out = out
+	log.Formatter = logrus.StandardLogger().Formatter
+	log.Level = logrus.InfoLevel
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		log.Level = logrus.GetLevel()
+	}
+
+	return log.WithField("worker", WorkerName()).WithFields(JobFields(job))
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defer viper.Set("log_level", nil)
+	defer viper.Set("log_format", nil)
+	defer logrus.SetLevel(logrus.GetLevel())
+	defer logrus.SetFormatter(logrus.StandardLogger().Formatter)
+
+	err := ConfigureLogging(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logrus.GetLevel() != logrus.InfoLevel {
+		t.Errorf("Default level should be info got %s", logrus.GetLevel())
+	}
+
+	viper.Set("log_level", "warning")
+	err = ConfigureLogging(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Errorf("Debug should override log_level got %s", logrus.GetLevel())
+	}
+
+	viper.Set("log_format", "json")
+	err = ConfigureLogging(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logrus.GetLevel() != logrus.WarnLevel {
+		t.Errorf("Expected warning level got %s", logrus.GetLevel())
+	}
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("Expected JSON formatter")
+	}
+
+	viper.Set("log_format", "xml")
+	if ConfigureLogging(false) == nil {
+		t.Errorf("Invalid log_format should fail")
+	}
+
+	viper.Set("log_format", "text")
+	viper.Set("log_level", "loud")
+	if ConfigureLogging(false) == nil {
+		t.Errorf("Invalid log_level should fail")
+	}
+}
+
+func TestJobLogger(t *testing.T) {
+	defer viper.Set("log_format", nil)
+	defer viper.Set("worker_name", nil)
+	defer logrus.SetLevel(logrus.GetLevel())
+	defer logrus.SetFormatter(logrus.StandardLogger().Formatter)
+
+	viper.Set("log_format", "json")
+	viper.Set("log_level", "error")
+	viper.Set("worker_name", "worker-1")
+	defer viper.Set("log_level", nil)
+	err := ConfigureLogging(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &model.Job{ID: 42, Token: "abc"}
+	var buf bytes.Buffer
+	JobLogger(&buf, job).Info("Running denss")
+
+	entry := make(map[string]interface{})
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("Job log should use the process log format: %s", err)
+	}
+
+	if entry["job_id"] != float64(42) || entry["token"] != "abc" || entry["worker"] != "worker-1" {
+		t.Errorf("Missing job fields: %v", entry)
+	}
+	if entry["msg"] != "Running denss" {
+		t.Errorf("Info messages should always be written to the job log: %v", entry)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/denssweb/model"
+)
+
+var (
+	// Registry of all metrics exposed on /metrics
+	Metrics = prometheus.NewRegistry()
+
+	// Updated from the database on each scrape of the server
+	jobsByStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "denssweb_jobs",
+		Help: "Number of jobs by status.",
+	}, []string{"status"})
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "denssweb_queue_depth",
+		Help: "Number of jobs waiting to run.",
+	})
+	queueOldestAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "denssweb_queue_oldest_pending_seconds",
+		Help: "Seconds since the oldest pending job was submitted.",
+	})
+
+	// Observed by the client
+	jobWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "denssweb_job_wait_seconds",
+		Help:    "Time jobs spent in the queue before starting.",
+		Buckets: []float64{1, 5, 15, 30, 60, 300, 600, 1800, 3600, 7200, 21600},
+	}, []string{"mode", "method"})
+	jobRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "denssweb_job_run_seconds",
+		Help:    "Time taken to run jobs.",
+		Buckets: []float64{60, 120, 300, 600, 900, 1800, 3600, 7200, 14400, 28800},
+	}, []string{"mode", "method"})
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "denssweb_command_duration_seconds",
+		Help:    "Time taken by external commands per processing step.",
+		Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600},
+	}, []string{"step"})
+	commandExits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "denssweb_command_exits_total",
+		Help: "External command exits per processing step and exit code.",
+	}, []string{"step", "code"})
+
+	// Observed by the server
+	emailsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "denssweb_emails_sent_total",
+		Help: "Number of emails delivered to the SMTP server.",
+	})
+	emailFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "denssweb_email_send_failures_total",
+		Help: "Number of failed attempts to deliver an email.",
+	})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "denssweb_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests per route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	Metrics.MustRegister(jobsByStatus, queueDepth, queueOldestAge,
+		jobWaitDuration, jobRunDuration, commandDuration, commandExits,
+		emailsSent, emailFailures, httpDuration)
+}
+
+// Handler serving all metrics in the registry
+func RegistryHandler() http.Handler {
+	return promhttp.HandlerFor(Metrics, promhttp.HandlerOpts{})
+}
+
+// Record how long a job waited in the queue once it starts running
+func ObserveJobStarted(job *model.Job) {
+	if job.Submitted == nil || job.Started == nil {
+		return
+	}
+	jobWaitDuration.WithLabelValues(job.Mode, job.Method).Observe(job.Started.Sub(*job.Submitted).Seconds())
+}
+
+// Record how long a job ran once it completes
+func ObserveJobCompleted(job *model.Job) {
+	if job.Started == nil || job.Completed == nil {
+		return
+	}
+	jobRunDuration.WithLabelValues(job.Mode, job.Method).Observe(job.Completed.Sub(*job.Started).Seconds())
+}
+
+// Exit code label of a finished command. Commands killed by a signal (or
+// timeout) are labeled "signal" and commands that failed to start "error"
+func exitCode(err error) string {
+	if err == nil {
+		return "0"
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "error"
+	}
+	if exitErr.ExitCode() < 0 {
+		return "signal"
+	}
+
+	return strconv.Itoa(exitErr.ExitCode())
+}
+
+// Record the duration and exit code of an external command run for the
+// given processing step
+func ObserveCommand(step string, d time.Duration, err error) {
+	commandDuration.WithLabelValues(step).Observe(d.Seconds())
+	commandExits.WithLabelValues(step, exitCode(err)).Inc()
+}
+
+// Record the latency of an HTTP request
+func ObserveRequest(route, method string, code int, d time.Duration) {
+	httpDuration.WithLabelValues(route, method, strconv.Itoa(code)).Observe(d.Seconds())
+}
+
+// Update the job and queue gauges from the database
+func (a *AppContext) updateQueueMetrics() error {
+	counts, err := model.FetchStatusCounts(a.DB)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range counts {
+		jobsByStatus.WithLabelValues(c.Status).Set(float64(c.Count))
+		if c.Status == model.StatusName(model.StatusPending) {
+			queueDepth.Set(float64(c.Count))
+		}
+	}
+
+	oldest, err := model.FetchOldestPending(a.DB)
+	if err == sql.ErrNoRows {
+		queueOldestAge.Set(0)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	queueOldestAge.Set(time.Since(oldest).Seconds())
+
+	return nil
+}
+
+// Handler serving all metrics with the job and queue gauges updated from the
+// database
+func (a *AppContext) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := a.updateQueueMetrics()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to update queue metrics")
+		}
+
+		RegistryHandler().ServeHTTP(w, r)
+	})
+}
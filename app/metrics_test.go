@@ -0,0 +1,113 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ubccr/denssweb/model"
+)
+
+func TestExitCode(t *testing.T) {
+	if c := exitCode(nil); c != "0" {
+		t.Errorf("Expected 0 got %s", c)
+	}
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if c := exitCode(err); c != "3" {
+		t.Errorf("Expected 3 got %s", c)
+	}
+
+	err = exec.Command("/nonexistent/denss.py").Run()
+	if c := exitCode(err); c != "error" {
+		t.Errorf("Expected error got %s", c)
+	}
+
+	err = exec.Command("sh", "-c", "kill -9 $$").Run()
+	if c := exitCode(err); c != "signal" {
+		t.Errorf("Expected signal got %s", c)
+	}
+}
+
+func TestObserveJob(t *testing.T) {
+	submitted := time.Now().Add(-time.Hour)
+	started := submitted.Add(10 * time.Minute)
+	completed := started.Add(20 * time.Minute)
+
+	job := &model.Job{Submitted: &submitted, Started: &started, Completed: &completed}
+	job.Mode = "SLOW"
+	job.Method = "metrics-test"
+
+	ObserveJobStarted(job)
+	ObserveJobCompleted(job)
+	ObserveJobCompleted(&model.Job{})
+
+	rr := httptest.NewRecorder()
+	RegistryHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`denssweb_job_wait_seconds_count{method="metrics-test",mode="SLOW"} 1`,
+		`denssweb_job_run_seconds_count{method="metrics-test",mode="SLOW"} 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, rr.Body.String())
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &AppContext{DB: db}
+
+	for i := 0; i < 3; i++ {
+		err := model.QueueJob(db, &model.Job{Name: "pending", InputData: []byte("test"), FileType: "dat"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec(`update job set submitted = ?`, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	a.MetricsHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+
+	for _, line := range []string{
+		`denssweb_jobs{status="Pending"} 3`,
+		`denssweb_jobs{status="Running"} 0`,
+		`denssweb_queue_depth 3`,
+		`denssweb_emails_sent_total 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, body)
+		}
+	}
+
+	if age := testutil.ToFloat64(queueOldestAge); age < 3590 || age > 3700 {
+		t.Errorf("Incorrect oldest pending age: %v", age)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+// A held back notification listed in a digest email
+type DigestItem struct {
+	*model.DigestEntry
+	Event          *model.Event
+	UnsubscribeURL string
+}
+
+// Data passed to the digest email templates
+type DigestData struct {
+	Recipient string
+	Items     []*DigestItem
+}
+
+// Sign the unsubscribe link of the recipient of job notifications
+func unsubscribeSig(token, email string) string {
+	mac := hmac.New(sha256.New, []byte(viper.GetString("secret_key")))
+	fmt.Fprintf(mac, "unsubscribe\x00%s\x00%s", token, email)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Link to stop notifications for the job. Returns an empty string if
+// secret_key is not set as links must be signed
+func unsubscribeURL(token, email string) string {
+	if viper.GetString("secret_key") == "" || email == "" {
+		return ""
+	}
+
+	job := &model.Job{Token: token}
+	return fmt.Sprintf("%s/unsubscribe?sig=%s", job.URL(), url.QueryEscape(unsubscribeSig(token, email)))
+}
+
+// Returns true if sig is a valid unsubscribe signature for the job
+func ValidUnsubscribe(job *model.Job, sig string) bool {
+	if viper.GetString("secret_key") == "" || sig == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(unsubscribeSig(job.Token, job.Email)))
+}
+
+// Notify the submitter and webhooks of the job event. Errors are logged
+func (a *AppContext) Notify(job *model.Job, event, logExcerpt string) {
+	if len(job.Email) > 0 {
+		err := a.QueueEmail(job, event, logExcerpt)
+		if err != nil {
+			log.WithFields(JobFields(job)).WithFields(log.Fields{
+				"email": job.Email,
+				"url":   job.URL(),
+				"event": event,
+				"error": err,
+			}).Error("Failed to queue email")
+		}
+	}
+
+	err := a.QueueWebhooks(job, event)
+	if err != nil {
+		log.WithFields(JobFields(job)).WithFields(log.Fields{
+			"url":   job.URL(),
+			"event": event,
+			"error": err,
+		}).Error("Failed to queue webhooks")
+	}
+}
+
+// Time the results of a finished job are deleted. Returns nil if results are
+// kept forever
+func JobExpires(job *model.Job) *time.Time {
+	days := viper.GetInt("job_retention_days")
+	if days <= 0 || job.Completed == nil {
+		return nil
+	}
+
+	t := job.Completed.AddDate(0, 0, days)
+	return &t
+}
+
+// Queue a digest for each recipient whose oldest held back notification is
+// older than digest_interval seconds. Returns the number of digests queued
+func (a *AppContext) FlushDigests() (int, error) {
+	entries, err := model.FetchDigests(a.DB)
+	if err != nil {
+		return 0, err
+	}
+
+	interval := time.Duration(viper.GetInt("digest_interval")) * time.Second
+	queued := 0
+	for len(entries) > 0 {
+		// Entries are ordered by recipient
+		n := 1
+		for n < len(entries) && entries[n].Recipient == entries[0].Recipient {
+			n++
+		}
+		group := entries[:n]
+		entries = entries[n:]
+
+		if time.Since(*group[0].Created) < interval {
+			continue
+		}
+
+		err = a.queueDigest(group)
+		if err != nil {
+			return queued, err
+		}
+		queued++
+	}
+
+	return queued, nil
+}
+
+// Render the digest of the held back notifications of one recipient, queue
+// it in the outbox and delete the notifications
+func (a *AppContext) queueDigest(entries []*model.DigestEntry) error {
+	data := &DigestData{Recipient: entries[0].Recipient}
+	for _, d := range entries {
+		e := model.FindEvent(d.Event)
+		if e == nil {
+			continue
+		}
+		data.Items = append(data.Items, &DigestItem{
+			DigestEntry:    d,
+			Event:          e,
+			UnsubscribeURL: unsubscribeURL(d.JobToken, d.Recipient),
+		})
+	}
+
+	subject := fmt.Sprintf("[DENSSWeb] %d job notifications", len(data.Items))
+	subject, text, html, err := a.renderMessage("digest", subject, data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := buildMessage(newHeader(data.Recipient, subject, ""), text, html, nil)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"email": data.Recipient,
+		"count": len(data.Items),
+	}).Info("Queueing digest email")
+
+	err = model.QueueEmail(a.DB, &model.OutboxMessage{
+		Recipient: data.Recipient,
+		Subject:   subject,
+		Message:   msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	return model.DeleteDigests(a.DB, entries)
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+func TestUnsubscribeURL(t *testing.T) {
+	job := testJob()
+
+	if u := unsubscribeURL(job.Token, job.Email); u != "" {
+		t.Errorf("Unsubscribe links should not be created without a secret key: %s", u)
+	}
+
+	viper.Set("secret_key", "secret")
+	defer viper.Set("secret_key", nil)
+
+	u, err := url.Parse(unsubscribeURL(job.Token, job.Email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/job/abc/unsubscribe" {
+		t.Errorf("Incorrect unsubscribe path: %s", u.Path)
+	}
+
+	sig := u.Query().Get("sig")
+	if !ValidUnsubscribe(job, sig) {
+		t.Errorf("Unsubscribe signature should be valid")
+	}
+
+	other := testJob()
+	other.Email = "other@example.edu"
+	if ValidUnsubscribe(other, sig) || ValidUnsubscribe(job, "") || ValidUnsubscribe(job, sig[1:]) {
+		t.Errorf("Unsubscribe signature should only be valid for the job recipient")
+	}
+}
+
+func TestRenderEventEmails(t *testing.T) {
+	viper.Set("secret_key", "secret")
+	defer viper.Set("secret_key", nil)
+
+	a := &AppContext{Tmpldir: filepath.Join("..", "templates")}
+	job := testJob()
+	expires := time.Date(2030, 1, 2, 12, 0, 0, 0, time.Local)
+
+	for _, e := range model.Events {
+		data := newEmailData(job, e.Status, "")
+		data.Expires = &expires
+
+		_, text, html, err := a.renderEmail(data)
+		if err != nil {
+			t.Fatalf("%s: %s", e.Name, err)
+		}
+		if html == nil {
+			t.Errorf("%s: missing HTML email", e.Name)
+		}
+		if e.Name != model.EventCancelled && !bytes.Contains(text, []byte("/job/abc/unsubscribe?sig=")) {
+			t.Errorf("%s: missing unsubscribe link:\n%s", e.Name, text)
+		}
+		if e.Name == model.EventExpiring && !bytes.Contains(text, []byte("2030/01/02")) {
+			t.Errorf("Expiring email missing expiry date:\n%s", text)
+		}
+	}
+}
+
+func TestQueueEmailPreferences(t *testing.T) {
+	viper.Set("email_from", "denss@example.edu")
+	viper.Set("enable_notifications", true)
+	viper.Set("secret_key", "secret")
+	viper.Set("digest_interval", 0)
+	defer viper.Set("enable_notifications", nil)
+	defer viper.Set("secret_key", nil)
+	defer viper.Set("digest_interval", nil)
+
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &AppContext{DB: db, Tmpldir: filepath.Join("..", "templates")}
+
+	job := &model.Job{Name: "single", Email: "x@example.edu", InputData: []byte("test"), FileType: "dat"}
+	job.Notify = []string{model.EventStarted}
+	err = model.QueueJob(db, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{model.EventQueued, model.EventStarted, model.EventCompleted} {
+		err = a.QueueEmail(job, e, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outbox, err := model.FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox) != 1 || !strings.Contains(outbox[0].Subject, "Started") {
+		t.Fatalf("Only the chosen event should be emailed: got %d emails", len(outbox))
+	}
+	if !bytes.Contains(outbox[0].Message, []byte("List-Unsubscribe: <")) {
+		t.Errorf("Email missing List-Unsubscribe header")
+	}
+
+	if err = a.QueueEmail(job, "bogus", ""); err == nil {
+		t.Errorf("Invalid events should fail")
+	}
+
+	// Digest jobs of the same recipient are sent in one email
+	for _, name := range []string{"batch1", "batch2"} {
+		job := &model.Job{Name: name, Email: "y@example.edu", InputData: []byte("test"), FileType: "dat"}
+		job.Digest = true
+		err = model.QueueJob(db, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range []string{model.EventQueued, model.EventCompleted} {
+			err = a.QueueEmail(job, e, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	outbox, err = model.FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox) != 1 {
+		t.Fatalf("Digest notifications should be held back: got %d emails", len(outbox))
+	}
+
+	n, err := a.FlushDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Incorrect number of digests: got %d should be 1", n)
+	}
+
+	outbox, err = model.FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox) != 2 {
+		t.Fatalf("Digest should be queued: got %d emails", len(outbox))
+	}
+	digest := outbox[1]
+	if digest.Recipient != "y@example.edu" || digest.Subject != "[DENSSWeb] 4 job notifications" {
+		t.Errorf("Incorrect digest: %s %s", digest.Recipient, digest.Subject)
+	}
+	for _, s := range []string{"batch1", "batch2", "Completed"} {
+		if !bytes.Contains(digest.Message, []byte(s)) {
+			t.Errorf("Digest missing %q", s)
+		}
+	}
+
+	entries, err := model.FetchDigests(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Sent digest entries should be deleted")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"errors"
+	"net/textproto"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+const (
+	// Maximum number of messages delivered in one pass of the outbox
+	OutboxBatchSize = 50
+)
+
+// Delay before the next attempt after the given number of failed attempts.
+// Doubles with each attempt starting at base up to max
+func backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+// Delay before the next attempt to deliver an email
+func retryDelay(attempts int) time.Duration {
+	return backoff(attempts,
+		time.Duration(viper.GetInt("outbox_retry_delay"))*time.Second,
+		time.Duration(viper.GetInt("outbox_max_delay"))*time.Second)
+}
+
+// Returns true if the SMTP server permanently rejected the message. Retrying
+// a 5xx reply to the sender, recipient or message will not succeed
+func permanentError(err error) bool {
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500
+}
+
+// Attempt delivery of all messages in the outbox that are due. Failed
+// messages are retried with exponential backoff until outbox_max_attempts.
+// Returns the number of messages delivered
+func (a *AppContext) DeliverOutbox() (int, error) {
+	messages, err := model.FetchDueEmails(a.DB, OutboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range messages {
+		err := sendMail(viper.GetString("email_from"), m.Recipient, m.Message)
+		if err == nil {
+			sent++
+			emailsSent.Inc()
+			if err := m.MarkSent(a.DB); err != nil {
+				return sent, err
+			}
+			log.WithFields(log.Fields{
+				"email_id": m.ID,
+				"email":    m.Recipient,
+				"job_id":   m.JobID,
+			}).Info("Email delivered")
+			continue
+		}
+
+		emailFailures.Inc()
+		logger := log.WithFields(log.Fields{
+			"email_id": m.ID,
+			"email":    m.Recipient,
+			"job_id":   m.JobID,
+			"attempts": m.Attempts + 1,
+			"error":    err,
+		})
+
+		if permanentError(err) || m.Attempts+1 >= viper.GetInt("outbox_max_attempts") {
+			logger.Error("Giving up delivering email")
+			err = m.MarkFailed(a.DB, err)
+		} else {
+			delay := retryDelay(m.Attempts + 1)
+			logger.WithField("retry", delay).Warn("Failed to deliver email")
+			err = m.MarkRetry(a.DB, err, delay)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// Queue due digests and deliver messages in the outbox every outbox_interval
+// seconds. Never returns
+func (a *AppContext) RunOutbox() {
+	interval := time.Duration(viper.GetInt("outbox_interval")) * time.Second
+
+	for {
+		_, err := a.FlushDigests()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to queue digest emails")
+		}
+
+		_, err = a.DeliverOutbox()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to deliver email outbox")
+		}
+
+		time.Sleep(interval)
+	}
+}
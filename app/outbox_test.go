@@ -0,0 +1,122 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+func TestRetryDelay(t *testing.T) {
+	viper.Set("outbox_retry_delay", 60)
+	viper.Set("outbox_max_delay", 600)
+	defer viper.Set("outbox_retry_delay", nil)
+	defer viper.Set("outbox_max_delay", nil)
+
+	tests := map[int]time.Duration{
+		1: time.Minute,
+		2: 2 * time.Minute,
+		4: 8 * time.Minute,
+		5: 10 * time.Minute,
+		9: 10 * time.Minute,
+	}
+
+	for attempts, delay := range tests {
+		if d := retryDelay(attempts); d != delay {
+			t.Errorf("Incorrect delay after %d attempts: got %s should be %s", attempts, d, delay)
+		}
+	}
+}
+
+func TestDeliverOutbox(t *testing.T) {
+	defer resetSMTPConfig()
+	viper.Set("email_from", "denss@example.edu")
+	viper.Set("enable_notifications", true)
+	defer viper.Set("enable_notifications", nil)
+
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &AppContext{DB: db, Tmpldir: "../templates"}
+
+	job := testJob()
+	err = a.QueueEmail(job, model.EventCompleted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Email = "reject@example.edu"
+	err = a.QueueEmail(job, model.EventCompleted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SMTP server down. Both messages are retried later
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("smtp_host", "127.0.0.1")
+	viper.Set("smtp_port", ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	sent, err := a.DeliverOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 0 {
+		t.Errorf("No emails should be sent with the SMTP server down")
+	}
+
+	stats, err := model.FetchOutboxStats(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[model.OutboxPending] != 2 {
+		t.Errorf("Emails should be pending: %v", stats)
+	}
+
+	s := newSMTPStandIn(t, false, false, false)
+	defer s.Close()
+
+	// Make the messages due again
+	_, err = db.Exec(`update email_outbox set next_attempt = ?`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err = a.DeliverOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 {
+		t.Errorf("Incorrect number of emails sent: got %d should be 1", sent)
+	}
+
+	stats, err = model.FetchOutboxStats(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[model.OutboxSent] != 1 || stats[model.OutboxFailed] != 1 {
+		t.Errorf("Rejected email should fail permanently: %v", stats)
+	}
+}
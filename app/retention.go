@@ -0,0 +1,93 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+const (
+	// How often expired jobs are deleted
+	RetentionInterval = time.Hour
+)
+
+// Notify submitters of finished jobs whose results are deleted within
+// expiry_notice_days and delete jobs finished more than job_retention_days
+// ago. Does nothing if job_retention_days is not set
+func (a *AppContext) ExpireJobs() error {
+	days := viper.GetInt("job_retention_days")
+	if days <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+
+	notice := viper.GetInt("expiry_notice_days")
+	if notice > 0 {
+		if notice > days {
+			notice = days
+		}
+
+		jobs, err := model.FetchExpiringJobs(a.DB, cutoff, now.AddDate(0, 0, notice-days))
+		if err != nil {
+			return err
+		}
+
+		for _, job := range jobs {
+			a.Notify(job, model.EventExpiring, "")
+
+			job.ExpiryNotified = true
+			err = model.UpdateParams(a.DB, job)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	n, err := model.ExpireJobs(a.DB, cutoff)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.WithFields(log.Fields{
+			"count":  n,
+			"before": cutoff,
+		}).Info("Deleted expired jobs")
+	}
+
+	return nil
+}
+
+// Delete expired jobs every RetentionInterval. Never returns
+func (a *AppContext) RunRetention() {
+	for {
+		err := a.ExpireJobs()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to delete expired jobs")
+		}
+
+		time.Sleep(RetentionInterval)
+	}
+}
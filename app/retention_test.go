@@ -0,0 +1,86 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+func TestExpireJobs(t *testing.T) {
+	viper.Set("email_from", "denss@example.edu")
+	viper.Set("enable_notifications", true)
+	viper.Set("job_retention_days", 30)
+	defer viper.Set("enable_notifications", nil)
+	defer viper.Set("job_retention_days", nil)
+
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &AppContext{DB: db, Tmpldir: filepath.Join("..", "templates")}
+
+	completed := map[string]int{"old": 31, "expiring": 29, "recent": 1}
+	jobs := make(map[string]*model.Job)
+	for name, days := range completed {
+		job := &model.Job{Name: name, Email: "x@example.edu", InputData: []byte("test"), FileType: "dat"}
+		job.Notify = []string{model.EventExpiring}
+		err = model.QueueJob(db, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.DensityMap = mrc.New(4, 4, 4, 1.0).Encode()
+		err = model.CompleteJob(db, job, model.StatusComplete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`update job set completed = ? where id = ?`, time.Now().AddDate(0, 0, -days), job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs[name] = job
+	}
+
+	// Run twice to check submitters are only notified once
+	for i := 0; i < 2; i++ {
+		err = a.ExpireJobs()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outbox, err := model.FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox) != 1 || outbox[0].JobID != jobs["expiring"].ID {
+		t.Fatalf("Expiring job should be notified once: got %d emails", len(outbox))
+	}
+
+	if _, err := model.FetchJob(db, jobs["old"].Token); err == nil {
+		t.Errorf("Expired job should be deleted")
+	}
+	if _, err := model.FetchJob(db, jobs["recent"].Token); err != nil {
+		t.Errorf("Recent job should not be deleted: %s", err)
+	}
+}
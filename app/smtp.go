@@ -0,0 +1,250 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Implements the LOGIN authentication mechanism which is not provided by
+// net/smtp. Like smtp.PlainAuth it refuses to send credentials over
+// unencrypted connections to hosts other than localhost
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func newLoginAuth(username, password, host string) smtp.Auth {
+	return &loginAuth{username: username, password: password, host: host}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+// Connect to the SMTP server using the configured smtp_tls mode: none,
+// starttls or tls (implicit TLS, usually port 465)
+func dialSMTP() (*smtp.Client, error) {
+	host := viper.GetString("smtp_host")
+	addr := net.JoinHostPort(host, fmt.Sprintf("%d", viper.GetInt("smtp_port")))
+	timeout := time.Duration(viper.GetInt("smtp_timeout")) * time.Second
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: viper.GetBool("smtp_insecure_skip_verify"),
+	}
+
+	mode := strings.ToLower(viper.GetString("smtp_tls"))
+
+	var conn net.Conn
+	var err error
+	switch mode {
+	case "", "none", "starttls":
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	case "tls":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig)
+	default:
+		return nil, fmt.Errorf("Invalid smtp_tls mode %s. Should be none, starttls or tls", mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to SMTP server %s: %s", addr, err)
+	}
+
+	// Bound the whole SMTP conversation
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP greeting from %s failed: %s", addr, err)
+	}
+
+	if mode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("SMTP STARTTLS failed: %s", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Authentication configured with smtp_username, smtp_password and smtp_auth
+// (plain or login). Returns nil if no username is set
+func smtpAuth() (smtp.Auth, error) {
+	username := viper.GetString("smtp_username")
+	if username == "" {
+		return nil, nil
+	}
+
+	host := viper.GetString("smtp_host")
+	password := viper.GetString("smtp_password")
+
+	switch strings.ToLower(viper.GetString("smtp_auth")) {
+	case "", "plain":
+		return smtp.PlainAuth("", username, password, host), nil
+	case "login":
+		return newLoginAuth(username, password, host), nil
+	}
+
+	return nil, fmt.Errorf("Invalid smtp_auth mechanism %s. Should be plain or login", viper.GetString("smtp_auth"))
+}
+
+// Connect to the configured SMTP server and authenticate if a username is
+// set
+func connectSMTP() (*smtp.Client, error) {
+	auth, err := smtpAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dialSMTP()
+	if err != nil {
+		return nil, err
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			c.Close()
+			return nil, errors.New("SMTP server does not support authentication")
+		}
+		if err := c.Auth(auth); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("SMTP authentication failed: %s", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Deliver the message to the configured SMTP server. Errors at each stage of
+// the SMTP conversation are returned
+func sendMail(from, to string, msg []byte) error {
+	c, err := connectSMTP()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("SMTP server rejected sender %s: %w", from, err)
+	}
+
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient %s: %w", to, err)
+	}
+
+	wc, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %s", err)
+	}
+
+	if _, err := wc.Write(msg); err != nil {
+		wc.Close()
+		return fmt.Errorf("Failed to write email message: %s", err)
+	}
+
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+
+	if err := c.Quit(); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("SMTP QUIT failed after message was accepted")
+	}
+
+	return nil
+}
+
+// Send a plain text test email to check the SMTP configuration. Sent even if
+// notifications are disabled
+func (a *AppContext) SendTestEmail(to string) error {
+	from := viper.GetString("email_from")
+	if len(from) == 0 {
+		return errors.New("Invalid from address. Please configure a from address before sending email")
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("To", to)
+	header.Set("Subject", "[DENSSWeb] Test email")
+	header.Set("From", from)
+
+	text := fmt.Sprintf("This is a test email from DENSSWeb sent through %s:%d.\n\nCheers!\n",
+		viper.GetString("smtp_host"), viper.GetInt("smtp_port"))
+
+	msg, err := buildMessage(header, []byte(text), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return sendMail(from, to, msg)
+}
+
+// Check the SMTP server accepts a connection and the configured credentials
+// without sending any mail
+func CheckSMTP() error {
+	c, err := connectSMTP()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Quit()
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Minimal SMTP server standing in for a mail relay
+type smtpStandIn struct {
+	ln          net.Listener
+	tlsConfig   *tls.Config
+	implicitTLS bool
+	startTLS    bool
+	auth        bool
+
+	mu       sync.Mutex
+	user     string
+	messages []string
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newSMTPStandIn(t *testing.T, implicitTLS, startTLS, auth bool) *smtpStandIn {
+	s := &smtpStandIn{
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}},
+		implicitTLS: implicitTLS,
+		startTLS:    startTLS,
+		auth:        auth,
+	}
+
+	var err error
+	if implicitTLS {
+		s.ln, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	viper.Set("smtp_host", "127.0.0.1")
+	viper.Set("smtp_port", s.ln.Addr().(*net.TCPAddr).Port)
+	viper.Set("smtp_insecure_skip_verify", true)
+
+	return s
+}
+
+func (s *smtpStandIn) Close() {
+	s.ln.Close()
+}
+
+func (s *smtpStandIn) checkLogin(user, pass string) bool {
+	if user != "denss" || pass != "secret" {
+		return false
+	}
+
+	s.mu.Lock()
+	s.user = user
+	s.mu.Unlock()
+	return true
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	secure := s.implicitTLS
+	tp.PrintfLine("220 localhost ESMTP stand-in")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			ext := []string{"localhost"}
+			if s.startTLS && !secure {
+				ext = append(ext, "STARTTLS")
+			}
+			if s.auth {
+				ext = append(ext, "AUTH PLAIN LOGIN")
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, e)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tconn := tls.Server(conn, s.tlsConfig)
+			if err := tconn.Handshake(); err != nil {
+				return
+			}
+			conn = tconn
+			tp = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			fields := strings.Fields(line)
+			ok := false
+			if len(fields) == 3 && strings.ToUpper(fields[1]) == "PLAIN" {
+				dec, _ := base64.StdEncoding.DecodeString(fields[2])
+				parts := strings.Split(string(dec), "\x00")
+				ok = len(parts) == 3 && s.checkLogin(parts[1], parts[2])
+			} else if len(fields) == 2 && strings.ToUpper(fields[1]) == "LOGIN" {
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				u, _ := tp.ReadLine()
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				p, _ := tp.ReadLine()
+				user, _ := base64.StdEncoding.DecodeString(u)
+				pass, _ := base64.StdEncoding.DecodeString(p)
+				ok = s.checkLogin(string(user), string(pass))
+			}
+			if ok {
+				tp.PrintfLine("235 Authentication successful")
+			} else {
+				tp.PrintfLine("535 Authentication failed")
+			}
+		case "MAIL", "NOOP", "RSET":
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			if strings.Contains(line, "reject") {
+				tp.PrintfLine("550 No such user")
+			} else {
+				tp.PrintfLine("250 OK")
+			}
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := ioutil.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+			tp.PrintfLine("250 Queued")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func resetSMTPConfig() {
+	for _, key := range []string{"smtp_host", "smtp_port", "smtp_tls", "smtp_auth", "smtp_username", "smtp_password", "smtp_insecure_skip_verify", "email_from"} {
+		viper.Set(key, nil)
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	defer resetSMTPConfig()
+	viper.Set("email_from", "denss@example.edu")
+
+	tests := []struct {
+		name        string
+		implicitTLS bool
+		startTLS    bool
+		mode        string
+		auth        string
+		password    string
+		to          string
+		err         string
+	}{
+		{name: "plain", mode: "none", to: "user@example.edu"},
+		{name: "starttls", startTLS: true, mode: "starttls", auth: "plain", password: "secret", to: "user@example.edu"},
+		{name: "tls", implicitTLS: true, mode: "tls", auth: "login", password: "secret", to: "user@example.edu"},
+		{name: "bad password", startTLS: true, mode: "starttls", auth: "plain", password: "wrong", to: "user@example.edu", err: "authentication failed"},
+		{name: "rejected", mode: "none", to: "reject@example.edu", err: "rejected recipient"},
+		{name: "no starttls", mode: "starttls", to: "user@example.edu", err: "does not support STARTTLS"},
+	}
+
+	for _, test := range tests {
+		s := newSMTPStandIn(t, test.implicitTLS, test.startTLS, test.auth != "")
+		viper.Set("smtp_tls", test.mode)
+		viper.Set("smtp_auth", test.auth)
+		viper.Set("smtp_username", "")
+		if test.auth != "" {
+			viper.Set("smtp_username", "denss")
+			viper.Set("smtp_password", test.password)
+		}
+
+		a := &AppContext{}
+		err := a.SendTestEmail(test.to)
+		s.Close()
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if len(s.messages) != 1 || !strings.Contains(s.messages[0], "Subject: [DENSSWeb] Test email") {
+			t.Errorf("%s: message not delivered: %v", test.name, s.messages)
+		}
+		if test.auth != "" && s.user != "denss" {
+			t.Errorf("%s: client did not authenticate", test.name)
+		}
+	}
+}
+
+func TestLoginAuthUnencrypted(t *testing.T) {
+	// Credentials are not sent in the clear to hosts other than localhost
+	a := newLoginAuth("denss", "secret", "mail.example.edu")
+	_, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.edu", Auth: []string{"LOGIN"}})
+	if err == nil {
+		t.Errorf("Login auth should require TLS")
+	}
+}
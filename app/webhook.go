@@ -0,0 +1,319 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+const (
+	// Maximum number of webhook deliveries attempted in one pass
+	WebhookBatchSize = 50
+
+	// Header with the HMAC-SHA256 signature of the request body
+	SignatureHeader = "X-DENSSWeb-Signature"
+)
+
+// Request body POSTed to webhooks
+type WebhookPayload struct {
+	Event     string            `json:"event"`
+	ID        int64             `json:"id"`
+	Token     string            `json:"token"`
+	Name      string            `json:"name"`
+	Status    string            `json:"status"`
+	Submitted *time.Time        `json:"submitted"`
+	Started   *time.Time        `json:"started"`
+	Completed *time.Time        `json:"completed"`
+	Timestamp time.Time         `json:"timestamp"`
+	URLs      map[string]string `json:"urls"`
+}
+
+// Load the webhooks receiving the events of all jobs from the config
+func configWebhooks() ([]*model.Webhook, error) {
+	var hooks []*model.Webhook
+	err := viper.UnmarshalKey("webhooks", &hooks)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid webhooks: %s", err)
+	}
+
+	for _, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return hooks, nil
+}
+
+// Build the webhook payload of the job event. Artifact URLs are included for
+// the files available with the job status
+func newWebhookPayload(job *model.Job, event string) *WebhookPayload {
+	url := job.URL()
+	p := &WebhookPayload{
+		Event:     event,
+		ID:        job.ID,
+		Token:     job.Token,
+		Name:      job.Name,
+		Status:    model.StatusName(job.StatusID),
+		Submitted: job.Submitted,
+		Started:   job.Started,
+		Completed: job.Completed,
+		Timestamp: time.Now(),
+		URLs: map[string]string{
+			"job":    url,
+			"status": url + "/status",
+		},
+	}
+
+	switch job.StatusID {
+	case model.StatusComplete:
+		p.URLs["density_map"] = url + "/density-map.ccp4"
+		p.URLs["fsc_chart"] = url + "/fsc.png"
+		p.URLs["summary_chart"] = url + "/summary.png"
+		p.URLs["fsc_data"] = url + "/fsc.json"
+		p.URLs["stats_data"] = url + "/stats.json"
+		p.URLs["raw_data"] = fmt.Sprintf("%s/denss%d-%s.zip", url, job.ID, job.Name)
+	case model.StatusError:
+		p.URLs["raw_data"] = fmt.Sprintf("%s/denss%d-output.zip", url, job.ID)
+	}
+
+	return p
+}
+
+// Sign the request body with HMAC-SHA256. The signature is sent in the
+// X-DENSSWeb-Signature header as sha256=<hex digest>
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Queue a signed delivery of the job event to the webhook of the job and the
+// webhooks in the config
+func (a *AppContext) QueueWebhooks(job *model.Job, event string) error {
+	hooks, err := configWebhooks()
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 && job.Webhook == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(newWebhookPayload(job, event))
+	if err != nil {
+		return err
+	}
+
+	queue := func(h *model.Webhook, global bool) error {
+		return model.QueueWebhook(a.DB, &model.WebhookDelivery{
+			JobID:     job.ID,
+			URL:       h.URL,
+			Event:     event,
+			Payload:   body,
+			Signature: SignPayload(h.Secret, body),
+			Global:    global,
+		})
+	}
+
+	for _, h := range hooks {
+		if err := queue(h, true); err != nil {
+			return err
+		}
+	}
+
+	if job.Webhook != "" {
+		return queue(&model.Webhook{URL: job.Webhook, Secret: job.WebhookSecret}, false)
+	}
+
+	return nil
+}
+
+// Returns true if retrying a request that got the HTTP status code will not
+// succeed. Redirects are not followed so they are permanent too
+func permanentStatus(code int) bool {
+	return code >= 300 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// Dialer Control refusing connections to non public addresses. Checked when
+// connecting so host names resolving to internal addresses are refused too
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !model.PublicIP(ip) {
+		return model.ErrPrivateWebhook
+	}
+
+	return nil
+}
+
+// HTTP client for webhook deliveries. Redirects are never followed and unless
+// allowPrivate only public addresses are connected to, without a proxy
+func webhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if allowPrivate {
+		transport.Proxy = http.ProxyFromEnvironment
+	} else {
+		dialer.Control = publicOnly
+	}
+
+	return &http.Client{
+		Timeout:   time.Duration(viper.GetInt("webhook_timeout")) * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// POST the delivery to the webhook. Returns the HTTP status code, or zero if
+// no response was received
+func postWebhook(client *http.Client, d *model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DENSSWeb-Webhook")
+	req.Header.Set("X-DENSSWeb-Event", d.Event)
+	req.Header.Set("X-DENSSWeb-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set(SignatureHeader, d.Signature)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("Webhook responded with %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+// Attempt all webhook deliveries that are due. Failed deliveries are retried
+// with exponential backoff until webhook_max_attempts. Returns the number of
+// deliveries accepted
+func (a *AppContext) DeliverWebhooks() (int, error) {
+	deliveries, err := model.FetchDueWebhooks(a.DB, WebhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// Webhooks from the config are trusted. Job webhooks are given by
+	// submitters so only reach public addresses unless allowed
+	globalClient := webhookClient(true)
+	jobClient := webhookClient(viper.GetBool("job_webhooks_allow_private"))
+
+	delivered := 0
+	for _, d := range deliveries {
+		client := jobClient
+		if d.Global {
+			client = globalClient
+		}
+
+		code, err := postWebhook(client, d)
+		if err == nil {
+			delivered++
+			if err := d.MarkDelivered(a.DB, code); err != nil {
+				return delivered, err
+			}
+			log.WithFields(log.Fields{
+				"delivery_id": d.ID,
+				"url":         d.URL,
+				"job_id":      d.JobID,
+				"event":       d.Event,
+			}).Info("Webhook delivered")
+			continue
+		}
+
+		logger := log.WithFields(log.Fields{
+			"delivery_id": d.ID,
+			"url":         d.URL,
+			"job_id":      d.JobID,
+			"event":       d.Event,
+			"attempts":    d.Attempts + 1,
+			"error":       err,
+		})
+
+		if permanentStatus(code) || errors.Is(err, model.ErrPrivateWebhook) || d.Attempts+1 >= viper.GetInt("webhook_max_attempts") {
+			logger.Error("Giving up delivering webhook")
+			err = d.MarkFailed(a.DB, code, err)
+		} else {
+			delay := backoff(d.Attempts+1,
+				time.Duration(viper.GetInt("webhook_retry_delay"))*time.Second,
+				time.Duration(viper.GetInt("webhook_max_delay"))*time.Second)
+			logger.WithField("retry", delay).Warn("Failed to deliver webhook")
+			err = d.MarkRetry(a.DB, code, err, delay)
+		}
+		if err != nil {
+			return delivered, err
+		}
+	}
+
+	return delivered, nil
+}
+
+// Deliver webhooks every webhook_interval seconds. Never returns
+func (a *AppContext) RunWebhooks() {
+	interval := time.Duration(viper.GetInt("webhook_interval")) * time.Second
+
+	for {
+		_, err := a.DeliverWebhooks()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to deliver webhooks")
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+func TestWebhookPayload(t *testing.T) {
+	job := testJob()
+	job.StatusID = model.StatusComplete
+
+	p := newWebhookPayload(job, model.EventCompleted)
+	if p.Status != "Complete" || p.Token != "abc" || p.Event != model.EventCompleted {
+		t.Errorf("Incorrect payload: %+v", p)
+	}
+	if p.URLs["density_map"] != job.URL()+"/density-map.ccp4" || p.URLs["raw_data"] != job.URL()+"/denss7-lysozyme.zip" {
+		t.Errorf("Incorrect artifact URLs: %v", p.URLs)
+	}
+
+	job.StatusID = model.StatusPending
+	p = newWebhookPayload(job, model.EventQueued)
+	if _, ok := p.URLs["density_map"]; ok || p.Status != "Pending" {
+		t.Errorf("Pending jobs should not have artifact URLs: %v", p.URLs)
+	}
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]*WebhookPayload)
+	codes := map[string]int{"/global": http.StatusOK, "/job": http.StatusServiceUnavailable}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		secret := "global secret"
+		if r.URL.Path == "/job" {
+			secret = "job secret"
+		}
+		if r.Header.Get(SignatureHeader) != SignPayload(secret, body) {
+			t.Errorf("Invalid signature for %s", r.URL.Path)
+		}
+
+		var p WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("Invalid payload: %s", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		received[r.URL.Path] = append(received[r.URL.Path], &p)
+		w.WriteHeader(codes[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	viper.Set("webhooks", []map[string]interface{}{{"url": srv.URL + "/global", "secret": "global secret"}})
+	viper.Set("job_webhooks_allow_private", true)
+	defer viper.Set("webhooks", nil)
+	defer viper.Set("job_webhooks_allow_private", nil)
+
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &AppContext{DB: db}
+
+	job := &model.Job{Name: "hook", InputData: []byte("test"), FileType: "dat"}
+	job.Webhook = srv.URL + "/job"
+	job.WebhookSecret = "job secret"
+	err = model.QueueJob(db, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.DensityMap = mrc.New(4, 4, 4, 1.0).Encode()
+	err = model.CompleteJob(db, job, model.StatusComplete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.QueueWebhooks(job, model.EventCompleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := a.DeliverWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Incorrect number of deliveries: got %d should be 1", n)
+	}
+
+	if len(received["/global"]) != 1 || received["/global"][0].Completed == nil || received["/global"][0].URLs["fsc_chart"] == "" {
+		t.Errorf("Global webhook should receive the completed job")
+	}
+
+	deliveries, err := model.FetchJobWebhooks(db, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != model.WebhookPending || deliveries[0].ResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("Server errors should be retried: %+v", deliveries[0])
+	}
+
+	// Client errors are not retried
+	codes["/job"] = http.StatusNotFound
+	_, err = db.Exec(`update webhook_delivery set next_attempt = created`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.DeliverWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err = model.FetchJobWebhooks(db, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deliveries[0].Status != model.WebhookFailed || deliveries[0].Attempts != 2 {
+		t.Errorf("Client errors should not be retried: %+v", deliveries[0])
+	}
+}
+
+func TestDeliverWebhooksRestricted(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/global" {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	viper.Set("webhooks", []map[string]interface{}{{"url": srv.URL + "/global", "secret": "global secret"}})
+	defer viper.Set("webhooks", nil)
+
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &AppContext{DB: db}
+
+	job := &model.Job{Name: "hook", InputData: []byte("test"), FileType: "dat"}
+	job.Webhook = srv.URL + "/job"
+	job.WebhookSecret = "job secret"
+	err = model.QueueJob(db, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.QueueWebhooks(job, model.EventQueued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := a.DeliverWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("No deliveries should succeed: got %d", n)
+	}
+	if received["/job"] != 0 {
+		t.Errorf("Job webhooks should not connect to loopback addresses")
+	}
+	if received["/global"] != 1 || received["/elsewhere"] != 0 {
+		t.Errorf("Redirects should not be followed: %v", received)
+	}
+
+	deliveries, err := model.FetchJobWebhooks(db, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != model.WebhookFailed || !strings.Contains(deliveries[0].LastError, "private network") {
+		t.Errorf("Delivery to a private address should fail: %+v", deliveries)
+	}
+
+	var status string
+	err = db.Get(&status, `select status from webhook_delivery where global = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != model.WebhookFailed {
+		t.Errorf("Redirected delivery should fail: %s", status)
+	}
+}
+
+func TestConfigWebhooksInvalid(t *testing.T) {
+	viper.Set("webhooks", []map[string]interface{}{{"url": "https://example.edu"}})
+	defer viper.Set("webhooks", nil)
+
+	_, err := configWebhooks()
+	if err == nil {
+		t.Errorf("Webhooks without a secret should be rejected")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/denssweb/app"
+)
+
+var (
+	// Templates, static files and emails. The LiteMol dist files are copied
+	// into templates/static by build.sh before building a release
+	//go:embed templates
+	templates embed.FS
+
+	// Chart scripts run when native_charts is disabled
+	//go:embed scripts/*.py
+	scripts embed.FS
+)
+
+func init() {
+	tmpl, err := fs.Sub(templates, "templates")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.EmbeddedTemplates = tmpl
+
+	s, err := fs.Sub(scripts, "scripts")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.EmbeddedScripts = s
+}
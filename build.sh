@@ -8,13 +8,11 @@
 DENSSWEB_DIR='./.denssweb-release'
 VERSION=$(git describe --long --tags --dirty --always 2>/dev/null | cut -f2 -d'v')
 
-# Copy denssweb templates and LiteMol js/css dist/templates
+# Copy LiteMol js/css into templates/static so they are embedded in the binary
 tmpl_dist(){
-    mkdir -p dist
-    cp -R ./templates dist
-    cp -R ./LiteMol/dist/css/* ./dist/templates/static/css/
-    cp -R ./LiteMol/dist/js/*.js ./dist/templates/static/js/
-    cp -R ./LiteMol/dist/fonts ./dist/templates/static/
+    cp -R ./LiteMol/dist/css/* ./templates/static/css/
+    cp -R ./LiteMol/dist/js/*.js ./templates/static/js/
+    cp -R ./LiteMol/dist/fonts ./templates/static/
 }
 
 # Create a denssweb release
@@ -30,8 +28,6 @@ make_release(){
     cp ./LICENSE ${REL_DIR}/ 
     cp ./LiteMol/LICENSE ${REL_DIR}/LICENSE.LiteMol 
     cp ./denssweb.yaml.sample ${REL_DIR}/ 
-    cp -R ./dist/templates ${REL_DIR}/ 
-    cp -R ./scripts ${REL_DIR}/ 
     cp -R ./ddl ${REL_DIR}/ 
 
     if [ "$GOOS" == "windows" ]; then
@@ -0,0 +1,422 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package chart renders the simple line charts DENSSWeb displays for a job
+// (FSC curve, summary statistics) as PNG or SVG without any external
+// plotting dependencies.
+package chart
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+)
+
+var (
+	// Series colors, taken from the matplotlib ggplot style
+	Palette = []color.Color{
+		color.RGBA{0xE2, 0x4A, 0x33, 0xFF},
+		color.RGBA{0x34, 0x8A, 0xBD, 0xFF},
+		color.RGBA{0x98, 0x8E, 0xD5, 0xFF},
+		color.RGBA{0x77, 0x77, 0x77, 0xFF},
+		color.RGBA{0xFB, 0xC1, 0x5E, 0xFF},
+		color.RGBA{0x8E, 0xBA, 0x42, 0xFF},
+		color.RGBA{0xFF, 0xB5, 0xB8, 0xFF},
+	}
+
+	backgroundColor = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	panelColor      = color.RGBA{0xE5, 0xE5, 0xE5, 0xFF}
+	gridColor       = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	textColor       = color.RGBA{0x44, 0x44, 0x44, 0xFF}
+	refLineColor    = color.RGBA{0x44, 0x44, 0x44, 0xFF}
+)
+
+const (
+	marginLeft   = 80
+	marginRight  = 25
+	marginTop    = 40
+	marginBottom = 55
+	panelGap     = 12
+	fontSize     = 12
+	titleSize    = 15
+)
+
+// A line series
+type Series struct {
+	X     []float64
+	Y     []float64
+	Color color.Color
+	Width float64
+
+	// Optional label shown in the panel legend
+	Label string
+}
+
+// A horizontal reference line
+type HLine struct {
+	Y      float64
+	Color  color.Color
+	Dashed bool
+}
+
+// Text placed relative to the panel. X and Y are fractions of the panel width
+// and height measured from the lower left corner.
+type Annotation struct {
+	X    float64
+	Y    float64
+	Text string
+}
+
+// A single set of axes
+type Panel struct {
+	Title       string
+	YLabel      string
+	LogY        bool
+	Series      []*Series
+	HLines      []*HLine
+	Annotations []*Annotation
+
+	// Optional fixed y range. Computed from the data if both are zero
+	YMin float64
+	YMax float64
+}
+
+// A Figure is one or more panels stacked vertically sharing the same x axis
+type Figure struct {
+	Width  int
+	Height int
+	XLabel string
+	Panels []*Panel
+}
+
+type scale struct {
+	min, max float64
+	log      bool
+}
+
+func (s *scale) norm(v float64) float64 {
+	if s.log {
+		v = math.Log10(v)
+	}
+	if s.max == s.min {
+		return 0.5
+	}
+	return (v - s.min) / (s.max - s.min)
+}
+
+type rect struct {
+	x0, y0, x1, y1 float64
+}
+
+// Drawing surface implemented by the PNG and SVG backends. Coordinates are in
+// pixels with the origin in the top left corner.
+type canvas interface {
+	fillRect(r rect, c color.Color)
+	polyline(xs, ys []float64, c color.Color, width float64, dashed bool)
+	text(x, y float64, s string, size float64, c color.Color, anchor textAnchor, vertical bool)
+	textWidth(s string, size float64) float64
+}
+
+type textAnchor int
+
+const (
+	anchorStart textAnchor = iota
+	anchorMiddle
+	anchorEnd
+)
+
+func (f *Figure) xRange() (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, p := range f.Panels {
+		for _, s := range p.Series {
+			for _, x := range s.X {
+				if math.IsNaN(x) || math.IsInf(x, 0) {
+					continue
+				}
+				min = math.Min(min, x)
+				max = math.Max(max, x)
+			}
+		}
+	}
+
+	if math.IsInf(min, 0) {
+		return 0, 1
+	}
+
+	return min, max
+}
+
+func (p *Panel) yRange() (float64, float64) {
+	if p.YMin != 0 || p.YMax != 0 {
+		if p.LogY {
+			return math.Log10(p.YMin), math.Log10(p.YMax)
+		}
+		return p.YMin, p.YMax
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	add := func(y float64) {
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			return
+		}
+		if p.LogY {
+			if y <= 0 {
+				return
+			}
+			y = math.Log10(y)
+		}
+		min = math.Min(min, y)
+		max = math.Max(max, y)
+	}
+	for _, s := range p.Series {
+		for _, y := range s.Y {
+			add(y)
+		}
+	}
+	for _, h := range p.HLines {
+		add(h.Y)
+	}
+
+	if math.IsInf(min, 0) {
+		return 0, 1
+	}
+	if min == max {
+		min -= 0.5
+		max += 0.5
+	}
+
+	// Pad range by 5% like matplotlib
+	pad := (max - min) * 0.05
+	return min - pad, max + pad
+}
+
+// Compute roughly n evenly spaced "nice" tick positions between min and max
+func linearTicks(min, max float64, n int) []float64 {
+	span := max - min
+	if span <= 0 {
+		return []float64{min}
+	}
+
+	raw := span / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := mag
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		step = m * mag
+		if step >= raw {
+			break
+		}
+	}
+
+	ticks := make([]float64, 0)
+	for t := math.Ceil(min/step) * step; t <= max+step*1e-9; t += step {
+		// Avoid printing -0
+		if math.Abs(t) < step*1e-9 {
+			t = 0
+		}
+		ticks = append(ticks, t)
+	}
+
+	return ticks
+}
+
+// Compute tick positions (in log10 space) at each power of ten
+func logTicks(min, max float64) []float64 {
+	ticks := make([]float64, 0)
+	for t := math.Ceil(min); t <= math.Floor(max); t++ {
+		ticks = append(ticks, t)
+	}
+
+	if len(ticks) == 0 {
+		return []float64{min, max}
+	}
+
+	// Skip every other decade if crowded
+	if len(ticks) > 8 {
+		thinned := make([]float64, 0)
+		for i, t := range ticks {
+			if i%2 == 0 {
+				thinned = append(thinned, t)
+			}
+		}
+		ticks = thinned
+	}
+
+	return ticks
+}
+
+func formatTick(v float64, log bool) string {
+	if log {
+		return "1e" + strconv.Itoa(int(math.Round(v)))
+	}
+
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+func (f *Figure) draw(c canvas) {
+	w, h := float64(f.Width), float64(f.Height)
+	c.fillRect(rect{0, 0, w, h}, backgroundColor)
+
+	if len(f.Panels) == 0 {
+		return
+	}
+
+	xmin, xmax := f.xRange()
+	xs := &scale{min: xmin, max: xmax}
+
+	n := float64(len(f.Panels))
+	plotTop := float64(marginTop)
+	plotBottom := h - marginBottom
+	panelHeight := (plotBottom - plotTop - panelGap*(n-1)) / n
+
+	for i, p := range f.Panels {
+		top := plotTop + float64(i)*(panelHeight+panelGap)
+		area := rect{marginLeft, top, w - marginRight, top + panelHeight}
+		last := i == len(f.Panels)-1
+		p.draw(c, area, xs, last)
+	}
+
+	// Title of the first panel doubles as the figure title
+	if f.Panels[0].Title != "" {
+		c.text((marginLeft+w-marginRight)/2, marginTop-12, f.Panels[0].Title, titleSize, textColor, anchorMiddle, false)
+	}
+
+	if f.XLabel != "" {
+		c.text((marginLeft+w-marginRight)/2, h-12, f.XLabel, fontSize, textColor, anchorMiddle, false)
+	}
+}
+
+func (p *Panel) draw(c canvas, area rect, xs *scale, showXTicks bool) {
+	ymin, ymax := p.yRange()
+	ys := &scale{min: ymin, max: ymax, log: p.LogY}
+
+	px := func(x float64) float64 {
+		return area.x0 + xs.norm(x)*(area.x1-area.x0)
+	}
+	py := func(y float64) float64 {
+		return area.y1 - ys.norm(y)*(area.y1-area.y0)
+	}
+
+	c.fillRect(area, panelColor)
+
+	// Grid lines and tick labels
+	for _, t := range linearTicks(xs.min, xs.max, 6) {
+		x := area.x0 + (t-xs.min)/(xs.max-xs.min)*(area.x1-area.x0)
+		c.polyline([]float64{x, x}, []float64{area.y0, area.y1}, gridColor, 1, false)
+		if showXTicks {
+			c.text(x, area.y1+16, formatTick(t, false), fontSize, textColor, anchorMiddle, false)
+		}
+	}
+
+	var yticks []float64
+	if p.LogY {
+		yticks = logTicks(ymin, ymax)
+	} else {
+		yticks = linearTicks(ymin, ymax, 5)
+	}
+	for _, t := range yticks {
+		y := area.y1 - (t-ymin)/(ymax-ymin)*(area.y1-area.y0)
+		c.polyline([]float64{area.x0, area.x1}, []float64{y, y}, gridColor, 1, false)
+		c.text(area.x0-6, y+4, formatTick(t, p.LogY), fontSize, textColor, anchorEnd, false)
+	}
+
+	for _, l := range p.HLines {
+		if p.LogY && l.Y <= 0 {
+			continue
+		}
+		col := l.Color
+		if col == nil {
+			col = refLineColor
+		}
+		y := py(l.Y)
+		c.polyline([]float64{area.x0, area.x1}, []float64{y, y}, col, 1, l.Dashed)
+	}
+
+	for i, s := range p.Series {
+		col := s.Color
+		if col == nil {
+			col = Palette[i%len(Palette)]
+		}
+		width := s.Width
+		if width == 0 {
+			width = 1.5
+		}
+
+		// Split the series on points that can't be plotted (log of <= 0)
+		xp := make([]float64, 0, len(s.X))
+		yp := make([]float64, 0, len(s.Y))
+		flush := func() {
+			if len(xp) > 1 {
+				c.polyline(xp, yp, col, width, false)
+			}
+			xp = xp[:0]
+			yp = yp[:0]
+		}
+		for j := range s.X {
+			if j >= len(s.Y) {
+				break
+			}
+			x, y := s.X[j], s.Y[j]
+			if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(y, 0) || (p.LogY && y <= 0) {
+				flush()
+				continue
+			}
+			xp = append(xp, px(x))
+			yp = append(yp, py(y))
+		}
+		flush()
+	}
+
+	p.drawLegend(c, area)
+
+	for _, a := range p.Annotations {
+		x := area.x0 + a.X*(area.x1-area.x0)
+		y := area.y1 - a.Y*(area.y1-area.y0)
+		c.text(x, y, a.Text, fontSize, textColor, anchorStart, false)
+	}
+
+	if p.YLabel != "" {
+		c.text(18, (area.y0+area.y1)/2, p.YLabel, fontSize, textColor, anchorMiddle, true)
+	}
+}
+
+// Draw the labels of the series in the upper right corner of the panel
+func (p *Panel) drawLegend(c canvas, area rect) {
+	width := 0.0
+	for _, s := range p.Series {
+		if s.Label != "" {
+			width = math.Max(width, c.textWidth(s.Label, fontSize))
+		}
+	}
+	if width == 0 {
+		return
+	}
+
+	x := area.x1 - width - 40
+	y := area.y0 + 18
+	for i, s := range p.Series {
+		if s.Label == "" {
+			continue
+		}
+		col := s.Color
+		if col == nil {
+			col = Palette[i%len(Palette)]
+		}
+		c.polyline([]float64{x, x + 24}, []float64{y - 4, y - 4}, col, 2, false)
+		c.text(x+30, y, s.Label, fontSize, textColor, anchorStart, false)
+		y += 18
+	}
+}
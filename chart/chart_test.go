@@ -0,0 +1,253 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package chart
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func loadFSC(t *testing.T) *FSC {
+	fh, err := os.Open(filepath.Join("testdata", "output_1_fsc.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	fsc, err := ParseFSC(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fsc
+}
+
+// Compare PNG output against golden file. Small differences in anti-aliasing
+// are tolerated as floating point results can vary slightly across platforms
+func checkGoldenPNG(t *testing.T, name string, data []byte) {
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := png.Decode(bytes.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want.Bounds() != got.Bounds() {
+		t.Fatalf("Incorrect image size for %s: got %v should be %v", name, got.Bounds(), want.Bounds())
+	}
+
+	b := want.Bounds()
+	diff := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !similar(want, got, x, y) {
+				diff++
+			}
+		}
+	}
+
+	if float64(diff)/float64(b.Dx()*b.Dy()) > 0.001 {
+		t.Errorf("Image %s differs from golden file in %d pixels", name, diff)
+	}
+}
+
+func similar(a, b image.Image, x, y int) bool {
+	r1, g1, b1, _ := a.At(x, y).RGBA()
+	r2, g2, b2, _ := b.At(x, y).RGBA()
+	d := func(u, v uint32) float64 {
+		return math.Abs(float64(u>>8) - float64(v>>8))
+	}
+	return d(r1, r2) <= 8 && d(g1, g2) <= 8 && d(b1, b2) <= 8
+}
+
+func checkGoldenSVG(t *testing.T, name string, data []byte) {
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, data) {
+		t.Errorf("SVG %s differs from golden file", name)
+	}
+}
+
+func TestParseFSC(t *testing.T) {
+	fsc := loadFSC(t)
+
+	if len(fsc.Frequency) != 32 {
+		t.Errorf("Incorrect number of FSC points: got %d should be %d", len(fsc.Frequency), 32)
+	}
+
+	res := fsc.Resolution()
+	if math.Abs(res-11.765) > 0.001 {
+		t.Errorf("Incorrect resolution: got %.3f should be %.3f", res, 11.765)
+	}
+
+	_, err := ParseFSC(strings.NewReader("0.01 0.99\nbad data\n"))
+	if err == nil {
+		t.Errorf("Invalid FSC data parsed")
+	}
+
+	_, err = ParseFSC(strings.NewReader("\n\n"))
+	if err == nil {
+		t.Errorf("Empty FSC data parsed")
+	}
+}
+
+func TestParseFit(t *testing.T) {
+	fh, err := os.Open(filepath.Join("testdata", "output_1_0_map.fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	fit, err := ParseFit(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fit.Q) != 60 {
+		t.Errorf("Incorrect number of fit points: got %d should be %d", len(fit.Q), 60)
+	}
+
+	if fit.Chi2 < 0.5 || fit.Chi2 > 2 {
+		t.Errorf("Incorrect chi2 for data within error: got %.3f", fit.Chi2)
+	}
+
+	fit, err = ParseFit(strings.NewReader("0.1 10 1 8\n0.2 5 0 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := fit.Residuals()
+	if res[0] != 2 || !math.IsNaN(res[1]) {
+		t.Errorf("Incorrect residuals: got %v", res)
+	}
+	if fit.Chi2 != 4 {
+		t.Errorf("Points without error should be skipped in chi2: got %.3f should be %.3f", fit.Chi2, 4.0)
+	}
+
+	_, err = ParseFit(strings.NewReader("0.1 10 1\n"))
+	if err == nil {
+		t.Errorf("Fit data with missing columns parsed")
+	}
+}
+
+func TestReadStepStats(t *testing.T) {
+	runs, err := ReadStepStats("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != 3 {
+		t.Fatalf("Incorrect number of runs: got %d should be %d", len(runs), 3)
+	}
+
+	chi2, rg, sv := runs[0].Final()
+	if chi2 == 0 || rg == 0 || sv == 0 {
+		t.Errorf("Final stats should skip trailing zeros: got %f %f %f", chi2, rg, sv)
+	}
+
+	_, err = ReadStepStats(filepath.Join("testdata", "missing"))
+	if err == nil {
+		t.Errorf("Missing stats files should fail")
+	}
+}
+
+func TestFSCChart(t *testing.T) {
+	fig := FSCChart(loadFSC(t))
+
+	var buf bytes.Buffer
+	if err := fig.WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGoldenPNG(t, "fsc.png", buf.Bytes())
+
+	buf.Reset()
+	if err := fig.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGoldenSVG(t, "fsc.svg", buf.Bytes())
+}
+
+func TestSummaryChart(t *testing.T) {
+	runs, err := ReadStepStats("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fig := SummaryChart(runs)
+
+	var buf bytes.Buffer
+	if err := fig.WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGoldenPNG(t, "summary.png", buf.Bytes())
+
+	buf.Reset()
+	if err := fig.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGoldenSVG(t, "summary.svg", buf.Bytes())
+}
+
+func TestCompareChart(t *testing.T) {
+	fsc := loadFSC(t)
+	cross := &FSC{Frequency: fsc.Frequency, Correlation: make([]float64, len(fsc.Correlation))}
+	for i, c := range fsc.Correlation {
+		cross.Correlation[i] = c * 0.8
+	}
+
+	fig := CompareChart(cross, fsc, nil, "lysozyme", "")
+
+	var buf bytes.Buffer
+	if err := fig.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGoldenSVG(t, "compare.svg", buf.Bytes())
+}
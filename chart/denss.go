@@ -0,0 +1,452 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package chart
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 600
+)
+
+// Fourier Shell Correlation curve
+type FSC struct {
+	// Spatial frequency (1/Å)
+	Frequency []float64 `json:"frequency"`
+
+	// Correlation at each frequency
+	Correlation []float64 `json:"correlation"`
+}
+
+// Fit of the intensity calculated from the density map to the experimental
+// data
+type Fit struct {
+	// Scattering vector q (1/Å)
+	Q []float64 `json:"q"`
+
+	// Experimental intensity
+	Intensity []float64 `json:"intensity"`
+
+	// Experimental error
+	Error []float64 `json:"error"`
+
+	// Intensity calculated from the density map
+	Calculated []float64 `json:"calculated"`
+
+	// Reduced χ² of the fit
+	Chi2 float64 `json:"chi2"`
+}
+
+// Statistics by step for a single DENSS run
+type StepStats struct {
+	Chi2          []float64 `json:"chi2"`
+	Rg            []float64 `json:"rg"`
+	SupportVolume []float64 `json:"support_volume"`
+}
+
+// Parse whitespace separated columns of floats, skipping blank lines and
+// comments. Returns the rows with at least minCols columns.
+func parseColumns(r io.Reader, minCols int) ([][]float64, error) {
+	rows := make([][]float64, 0)
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) < minCols {
+			return nil, fmt.Errorf("Expected at least %d columns on line %d", minCols, lineno)
+		}
+
+		row := make([]float64, len(parts))
+		for i, n := range parts {
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid floating point number found on line %d", lineno)
+			}
+			row[i] = f
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// Parse FSC data file (output_{id}_fsc.dat). First column is the frequency,
+// second column is the correlation
+func ParseFSC(r io.Reader) (*FSC, error) {
+	rows, err := parseColumns(r, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("FSC data file was empty")
+	}
+
+	fsc := &FSC{
+		Frequency:   make([]float64, len(rows)),
+		Correlation: make([]float64, len(rows)),
+	}
+	for i, row := range rows {
+		fsc.Frequency[i] = row[0]
+		fsc.Correlation[i] = row[1]
+	}
+
+	return fsc, nil
+}
+
+// Estimated resolution (Å). This is the inverse of the highest frequency
+// where the correlation is above 0.5. Returns 0 if no such frequency exists.
+func (f *FSC) Resolution() float64 {
+	for i := len(f.Frequency) - 1; i > 0; i-- {
+		if f.Correlation[i] > 0.5 {
+			if f.Frequency[i] == 0 {
+				return 0
+			}
+			return 1 / f.Frequency[i]
+		}
+	}
+
+	return 0
+}
+
+// Parse fit data file (output_{id}_{run}_map.fit). Columns are q, experimental
+// intensity, error and intensity calculated from the density map
+func ParseFit(r io.Reader) (*Fit, error) {
+	rows, err := parseColumns(r, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("Fit data file was empty")
+	}
+
+	fit := &Fit{
+		Q:          make([]float64, len(rows)),
+		Intensity:  make([]float64, len(rows)),
+		Error:      make([]float64, len(rows)),
+		Calculated: make([]float64, len(rows)),
+	}
+	for i, row := range rows {
+		fit.Q[i] = row[0]
+		fit.Intensity[i] = row[1]
+		fit.Error[i] = row[2]
+		fit.Calculated[i] = row[3]
+	}
+
+	fit.Chi2 = fit.chi2()
+
+	return fit, nil
+}
+
+// Residuals normalized by the experimental error. Points without a positive
+// error are NaN
+func (f *Fit) Residuals() []float64 {
+	res := make([]float64, len(f.Q))
+	for i := range res {
+		if f.Error[i] <= 0 {
+			res[i] = math.NaN()
+			continue
+		}
+		res[i] = (f.Intensity[i] - f.Calculated[i]) / f.Error[i]
+	}
+
+	return res
+}
+
+func (f *Fit) chi2() float64 {
+	sum := 0.0
+	n := 0
+	for _, r := range f.Residuals() {
+		if math.IsNaN(r) {
+			continue
+		}
+		sum += r * r
+		n++
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return sum / float64(n)
+}
+
+// Parse stats by step data file (output_{id}_{run}_stats_by_step.dat). Columns
+// are chi², Rg and support volume
+func ParseStepStats(r io.Reader) (*StepStats, error) {
+	rows, err := parseColumns(r, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("Stats by step data file was empty")
+	}
+
+	stats := &StepStats{
+		Chi2:          make([]float64, len(rows)),
+		Rg:            make([]float64, len(rows)),
+		SupportVolume: make([]float64, len(rows)),
+	}
+	for i, row := range rows {
+		stats.Chi2[i] = row[0]
+		stats.Rg[i] = row[1]
+		stats.SupportVolume[i] = row[2]
+	}
+
+	return stats, nil
+}
+
+// Read all stats by step files found in dir, ordered by file name
+func ReadStepStats(dir string) ([]*StepStats, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "output_*stats_by_step.dat"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No stats by step files found in %s", dir)
+	}
+
+	sort.Strings(files)
+
+	runs := make([]*StepStats, 0, len(files))
+	for _, path := range files {
+		fh, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		stats, err := ParseStepStats(fh)
+		fh.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Base(path), err)
+		}
+
+		runs = append(runs, stats)
+	}
+
+	return runs, nil
+}
+
+// Last non-zero value. DENSS pads the stats with zeros after convergence
+func lastNonZero(vals []float64) float64 {
+	for i := len(vals) - 1; i >= 0; i-- {
+		if vals[i] != 0 {
+			return vals[i]
+		}
+	}
+
+	return 0
+}
+
+// Remove the trailing rows of zeros DENSS writes after convergence
+func (s *StepStats) Trim() {
+	n := len(s.Chi2)
+	for n > 0 && s.Chi2[n-1] == 0 && s.Rg[n-1] == 0 && s.SupportVolume[n-1] == 0 {
+		n--
+	}
+
+	s.Chi2 = s.Chi2[:n]
+	s.Rg = s.Rg[:n]
+	s.SupportVolume = s.SupportVolume[:n]
+}
+
+// Final chi², Rg and support volume of the run
+func (s *StepStats) Final() (float64, float64, float64) {
+	return lastNonZero(s.Chi2), lastNonZero(s.Rg), lastNonZero(s.SupportVolume)
+}
+
+func meanStd(vals []float64) (float64, float64) {
+	if len(vals) == 0 {
+		return 0, 0
+	}
+
+	mean := 0.0
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+
+	variance := 0.0
+	for _, v := range vals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(vals))
+
+	return mean, math.Sqrt(variance)
+}
+
+func steps(n int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = float64(i)
+	}
+	return x
+}
+
+// Build the FSC curve chart with the 0.5 and 0.143 reference lines and the
+// estimated resolution
+func FSCChart(fsc *FSC) *Figure {
+	return &Figure{
+		Width:  DefaultWidth,
+		Height: DefaultHeight,
+		XLabel: "Resolution (1/Å)",
+		Panels: []*Panel{
+			{
+				Title:  "Fourier Shell Correlation Curve",
+				YLabel: "FSC",
+				Series: []*Series{
+					{X: fsc.Frequency, Y: fsc.Correlation},
+				},
+				HLines: []*HLine{
+					{Y: 0.5, Dashed: true},
+					{Y: 0.143, Dashed: true},
+				},
+				Annotations: []*Annotation{
+					{X: 0.6, Y: 0.9, Text: fmt.Sprintf("Resolution=%.3f Å", fsc.Resolution())},
+				},
+			},
+		},
+	}
+}
+
+// Build the chart of the experimental intensity and the intensity calculated
+// from the density map on a log scale with the normalized residuals below
+func FitChart(fit *Fit) *Figure {
+	return &Figure{
+		Width:  DefaultWidth,
+		Height: DefaultHeight,
+		XLabel: "q (1/Å)",
+		Panels: []*Panel{
+			{
+				Title:  "Fit to Experimental Data",
+				YLabel: "I(q)",
+				LogY:   true,
+				Series: []*Series{
+					{X: fit.Q, Y: fit.Intensity, Color: Palette[0], Width: 1, Label: "Experimental"},
+					{X: fit.Q, Y: fit.Calculated, Color: Palette[1], Label: "Density map"},
+				},
+				Annotations: []*Annotation{
+					{X: 0.05, Y: 0.1, Text: fmt.Sprintf("χ²=%.3f", fit.Chi2)},
+				},
+			},
+			{
+				YLabel: "ΔI/σ",
+				Series: []*Series{
+					{X: fit.Q, Y: fit.Residuals(), Color: Palette[0], Width: 1},
+				},
+				HLines: []*HLine{
+					{Y: 0, Dashed: true},
+				},
+			},
+		},
+	}
+}
+
+// Build the summary chart of chi², Rg and support volume by step for all runs
+func SummaryChart(runs []*StepStats) *Figure {
+	chi2 := &Panel{Title: "Statistics by Step", YLabel: "χ²", LogY: true}
+	rg := &Panel{YLabel: "Rg"}
+	sv := &Panel{YLabel: "Support Volume", LogY: true}
+
+	finalChi2 := make([]float64, len(runs))
+	finalRg := make([]float64, len(runs))
+	finalSV := make([]float64, len(runs))
+
+	for i, r := range runs {
+		color := Palette[i%len(Palette)]
+		chi2.Series = append(chi2.Series, &Series{X: steps(len(r.Chi2)), Y: r.Chi2, Color: color, Width: 1})
+		rg.Series = append(rg.Series, &Series{X: steps(len(r.Rg)), Y: r.Rg, Color: color, Width: 1})
+		sv.Series = append(sv.Series, &Series{X: steps(len(r.SupportVolume)), Y: r.SupportVolume, Color: color, Width: 1})
+		finalChi2[i], finalRg[i], finalSV[i] = r.Final()
+	}
+
+	for _, p := range []struct {
+		panel *Panel
+		vals  []float64
+	}{{chi2, finalChi2}, {rg, finalRg}, {sv, finalSV}} {
+		mean, std := meanStd(p.vals)
+		p.panel.Annotations = []*Annotation{
+			{X: 0.5, Y: 0.8, Text: fmt.Sprintf("Average=%.3f σ=%.3f", mean, std)},
+		}
+	}
+
+	return &Figure{
+		Width:  DefaultWidth,
+		Height: DefaultHeight,
+		XLabel: "Step",
+		Panels: []*Panel{chi2, rg, sv},
+	}
+}
+
+// Build the chart comparing two maps: the FSC between the maps overlaid with
+// the FSC curve of each job. Job curves may be nil
+func CompareChart(cross, a, b *FSC, nameA, nameB string) *Figure {
+	series := []*Series{
+		{X: cross.Frequency, Y: cross.Correlation, Label: "Map vs map"},
+	}
+	if a != nil {
+		series = append(series, &Series{X: a.Frequency, Y: a.Correlation, Color: Palette[1], Width: 1, Label: nameA})
+	}
+	if b != nil {
+		series = append(series, &Series{X: b.Frequency, Y: b.Correlation, Color: Palette[2], Width: 1, Label: nameB})
+	}
+
+	return &Figure{
+		Width:  DefaultWidth,
+		Height: DefaultHeight,
+		XLabel: "Resolution (1/Å)",
+		Panels: []*Panel{
+			{
+				Title:  "Fourier Shell Correlation Between Maps",
+				YLabel: "FSC",
+				Series: series,
+				HLines: []*HLine{
+					{Y: 0.5, Dashed: true},
+					{Y: 0.143, Dashed: true},
+				},
+				Annotations: []*Annotation{
+					{X: 0.05, Y: 0.05, Text: fmt.Sprintf("Resolution=%.3f Å", cross.Resolution())},
+				},
+			},
+		},
+	}
+}
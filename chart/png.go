@@ -0,0 +1,190 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+var (
+	fontOnce  sync.Once
+	fontFaces map[float64]font.Face
+	fontMutex sync.Mutex
+	fontData  *opentype.Font
+)
+
+func face(size float64) font.Face {
+	fontOnce.Do(func() {
+		f, err := opentype.Parse(goregular.TTF)
+		if err != nil {
+			panic(err)
+		}
+		fontData = f
+		fontFaces = make(map[float64]font.Face)
+	})
+
+	fontMutex.Lock()
+	defer fontMutex.Unlock()
+
+	if f, ok := fontFaces[size]; ok {
+		return f
+	}
+
+	f, err := opentype.NewFace(fontData, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fontFaces[size] = f
+
+	return f
+}
+
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngCanvas) fillRect(r rect, c color.Color) {
+	b := image.Rect(int(math.Round(r.x0)), int(math.Round(r.y0)), int(math.Round(r.x1)), int(math.Round(r.y1)))
+	draw.Draw(p.img, b, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func (p *pngCanvas) polyline(xs, ys []float64, c color.Color, width float64, dashed bool) {
+	if len(xs) < 2 {
+		return
+	}
+
+	b := p.img.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	hw := width / 2
+
+	segment := func(x0, y0, x1, y1 float64) {
+		dx, dy := x1-x0, y1-y0
+		l := math.Hypot(dx, dy)
+		if l == 0 {
+			return
+		}
+		// Extend each segment by half the width so joins don't leave gaps
+		ux, uy := dx/l*hw, dy/l*hw
+		nx, ny := -uy, ux
+		x0, y0 = x0-ux, y0-uy
+		x1, y1 = x1+ux, y1+uy
+		z.MoveTo(float32(x0+nx), float32(y0+ny))
+		z.LineTo(float32(x1+nx), float32(y1+ny))
+		z.LineTo(float32(x1-nx), float32(y1-ny))
+		z.LineTo(float32(x0-nx), float32(y0-ny))
+		z.ClosePath()
+	}
+
+	for i := 1; i < len(xs); i++ {
+		if !dashed {
+			segment(xs[i-1], ys[i-1], xs[i], ys[i])
+			continue
+		}
+
+		// 6px dash, 4px gap
+		dx, dy := xs[i]-xs[i-1], ys[i]-ys[i-1]
+		l := math.Hypot(dx, dy)
+		for s := 0.0; s < l; s += 10 {
+			e := math.Min(s+6, l)
+			segment(xs[i-1]+dx*s/l, ys[i-1]+dy*s/l, xs[i-1]+dx*e/l, ys[i-1]+dy*e/l)
+		}
+	}
+
+	z.Draw(p.img, b, image.NewUniform(c), image.Point{})
+}
+
+func (p *pngCanvas) textWidth(s string, size float64) float64 {
+	adv := font.MeasureString(face(size), s)
+	return float64(adv) / 64
+}
+
+func (p *pngCanvas) text(x, y float64, s string, size float64, c color.Color, anchor textAnchor, vertical bool) {
+	f := face(size)
+	w := p.textWidth(s, size)
+
+	switch anchor {
+	case anchorMiddle:
+		x -= w / 2
+	case anchorEnd:
+		x -= w
+	}
+
+	if !vertical {
+		d := &font.Drawer{
+			Dst:  p.img,
+			Src:  image.NewUniform(c),
+			Face: f,
+			Dot:  fixed.Point26_6{X: fixed.Int26_6(math.Round(x * 64)), Y: fixed.Int26_6(math.Round(y * 64))},
+		}
+		d.DrawString(s)
+		return
+	}
+
+	// Render text horizontally into a mask then rotate 90 degrees
+	// counter-clockwise, centered vertically on y
+	m := f.Metrics()
+	th := (m.Ascent + m.Descent).Ceil()
+	tw := int(math.Ceil(w))
+	mask := image.NewAlpha(image.Rect(0, 0, tw, th))
+	d := &font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque,
+		Face: f,
+		Dot:  fixed.Point26_6{X: 0, Y: m.Ascent},
+	}
+	d.DrawString(s)
+
+	rot := image.NewAlpha(image.Rect(0, 0, th, tw))
+	for i := 0; i < tw; i++ {
+		for j := 0; j < th; j++ {
+			rot.SetAlpha(j, tw-1-i, mask.AlphaAt(i, j))
+		}
+	}
+
+	x0 := int(math.Round(x + w/2 - float64(th)/2))
+	y0 := int(math.Round(y - float64(tw)/2))
+	draw.DrawMask(p.img, image.Rect(x0, y0, x0+th, y0+tw), image.NewUniform(c), image.Point{}, rot, image.Point{}, draw.Over)
+}
+
+// Render figure as an image
+func (f *Figure) Image() *image.RGBA {
+	c := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, f.Width, f.Height))}
+	f.draw(c)
+	return c.img
+}
+
+// Write figure to w in PNG format
+func (f *Figure) WritePNG(w io.Writer) error {
+	return png.Encode(w, f.Image())
+}
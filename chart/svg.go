@@ -0,0 +1,97 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package chart
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func svgColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+func (s *svgCanvas) fillRect(r rect, c color.Color) {
+	fmt.Fprintf(&s.buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`+"\n",
+		r.x0, r.y0, r.x1-r.x0, r.y1-r.y0, svgColor(c))
+}
+
+func (s *svgCanvas) polyline(xs, ys []float64, c color.Color, width float64, dashed bool) {
+	if len(xs) < 2 {
+		return
+	}
+
+	s.buf.WriteString(`<polyline fill="none" points="`)
+	for i := range xs {
+		if i > 0 {
+			s.buf.WriteString(" ")
+		}
+		fmt.Fprintf(&s.buf, "%.2f,%.2f", xs[i], ys[i])
+	}
+	fmt.Fprintf(&s.buf, `" stroke="%s" stroke-width="%.2f"`, svgColor(c), width)
+	if dashed {
+		s.buf.WriteString(` stroke-dasharray="6,4"`)
+	}
+	s.buf.WriteString("/>\n")
+}
+
+func (s *svgCanvas) textWidth(str string, size float64) float64 {
+	// Use the same font metrics as the PNG renderer so layout matches
+	return (&pngCanvas{}).textWidth(str, size)
+}
+
+func (s *svgCanvas) text(x, y float64, str string, size float64, c color.Color, anchor textAnchor, vertical bool) {
+	a := "start"
+	switch anchor {
+	case anchorMiddle:
+		a = "middle"
+	case anchorEnd:
+		a = "end"
+	}
+
+	transform := ""
+	if vertical {
+		transform = fmt.Sprintf(` transform="rotate(-90 %.2f %.2f)"`, x, y)
+	}
+
+	fmt.Fprintf(&s.buf, `<text x="%.2f" y="%.2f" font-family="Go, sans-serif" font-size="%.0f" fill="%s" text-anchor="%s"%s>`,
+		x, y, size, svgColor(c), a, transform)
+	xml.EscapeText(&s.buf, []byte(str))
+	s.buf.WriteString("</text>\n")
+}
+
+// Write figure to w in SVG format
+func (f *Figure) WriteSVG(w io.Writer) error {
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&c.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		f.Width, f.Height, f.Width, f.Height)
+	f.draw(c)
+	c.buf.WriteString("</svg>\n")
+
+	_, err := c.buf.WriteTo(w)
+	return err
+}
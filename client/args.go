@@ -0,0 +1,114 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Build the flags for the parameters of the job supported by method. A warning
+// is logged for each parameter used by the job that the method doesn't support
+func paramArgs(log *logrus.Entry, job *model.Job, method string) []string {
+	args := make([]string, 0)
+	for _, p := range model.Params {
+		if !p.IsUsed(job) {
+			continue
+		}
+
+		if !p.Supports(method) {
+			log.WithFields(logrus.Fields{
+				"method": method,
+				"param":  p.Name,
+			}).Warn("Parameter not supported by method, ignoring")
+			continue
+		}
+
+		args = append(args, p.Args(job, method)...)
+	}
+
+	return args
+}
+
+// Arguments for a single denss.py run
+func denssArgs(log *logrus.Entry, job *model.Job, inputFile, outputPrefix string) []string {
+	args := []string{
+		"-f",
+		inputFile,
+		"-o",
+		outputPrefix,
+		"--plot_off",
+	}
+
+	return append(args, paramArgs(log, job, model.MethodDenss)...)
+}
+
+// Arguments for denss.all.py
+func denssAllArgs(log *logrus.Entry, job *model.Job, inputFile, outputPrefix string, threads int) []string {
+	args := []string{
+		"-f",
+		inputFile,
+		"-o",
+		outputPrefix,
+		"-j",
+		fmt.Sprintf("%d", threads),
+		"--plot_off",
+		"--quiet",
+	}
+
+	if viper.GetBool("enable_gpu") {
+		args = append(args, "--gpu")
+	}
+
+	return append(args, paramArgs(log, job, model.MethodDenssAll)...)
+}
+
+// Arguments for superdenss. The denss.py arguments are passed as a single
+// string with -i
+func superdenssArgs(log *logrus.Entry, job *model.Job, inputFile, outputPrefix string, threads int) []string {
+	args := []string{
+		"-f",
+		inputFile,
+		"-o",
+		outputPrefix,
+		"-j",
+		fmt.Sprintf("%d", threads),
+		"-p",
+	}
+
+	if job.Enantiomer {
+		args = append(args, "-e")
+	}
+
+	if job.MaxRuns > 0 {
+		args = append(args, "-n")
+		args = append(args, fmt.Sprintf("%d", job.MaxRuns))
+	}
+
+	dargs := []string{"--plot_off", "--quiet"}
+	dargs = append(dargs, paramArgs(log, job, model.MethodSuperdenss)...)
+
+	args = append(args, "-i")
+	args = append(args, strings.Join(dargs, " "))
+
+	return args
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/ubccr/denssweb/model"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// Jobs with the defaults set by QueueJob and with every parameter set
+func testJobs() map[string]*model.Job {
+	defaults := &model.Job{
+		ID:           1,
+		Oversampling: 3.0,
+		Electrons:    10000,
+		MaxSteps:     3000,
+		MaxRuns:      20,
+	}
+	defaults.Mode = "fast"
+	defaults.Units = "a"
+	defaults.Enantiomer = true
+
+	full := &model.Job{
+		ID:           2,
+		Dmax:         50.5,
+		Oversampling: 5.0,
+		VoxelSize:    2.5,
+		NumSamples:   64,
+		Electrons:    20000,
+		MaxSteps:     5000,
+		MaxRuns:      8,
+	}
+	full.Mode = "membrane"
+	full.Units = "nm"
+	full.Symmetry = 2
+	full.SymmetryAxis = 3
+	full.SymmetrySteps = "3000 5000 7000"
+
+	return map[string]*model.Job{"defaults": defaults, "full": full}
+}
+
+// Compare argv, one argument per line, against golden file
+func checkGoldenArgs(t *testing.T, name string, args []string) {
+	golden := filepath.Join("testdata", name+".args.golden")
+	data := []byte(strings.Join(args, "\n") + "\n")
+	if *update {
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(expected) != string(data) {
+		t.Errorf("Arguments %s differ from golden file:\ngot:\n%s\nshould be:\n%s", name, data, expected)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	log := logrus.NewEntry(logger)
+
+	for name, job := range testJobs() {
+		checkGoldenArgs(t, "denss-"+name, denssArgs(log, job, "input.dat", "output_0"))
+		checkGoldenArgs(t, "denssall-"+name, denssAllArgs(log, job, "input.dat", "output_1", 4))
+		checkGoldenArgs(t, "superdenss-"+name, superdenssArgs(log, job, "input.dat", "output_1", 4))
+	}
+
+	// Only the units are unsupported, by superdenss
+	for _, e := range hook.Entries {
+		if e.Data["method"] != model.MethodSuperdenss || e.Data["param"] != "units" {
+			t.Errorf("Incorrect unsupported parameter warning: %v", e.Data)
+		}
+	}
+}
+
+func TestParamArgsUnsupported(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	log := logrus.NewEntry(logger)
+
+	job := testJobs()["defaults"]
+	job.Units = "nm"
+
+	if args := paramArgs(log, job, model.MethodDenssAll); !strings.Contains(strings.Join(args, " "), "--units nm") {
+		t.Errorf("Units should be passed to denss.all.py: got %v", args)
+	}
+	if len(hook.Entries) != 0 {
+		t.Errorf("Supported parameters should not warn: got %d warnings", len(hook.Entries))
+	}
+
+	args := paramArgs(log, job, model.MethodSuperdenss)
+	for _, a := range args {
+		if a == "--units" {
+			t.Errorf("Units should not be passed to superdenss: got %v", args)
+		}
+	}
+
+	if len(hook.Entries) != 1 {
+		t.Fatalf("Incorrect number of warnings: got %d should be %d", len(hook.Entries), 1)
+	}
+
+	e := hook.LastEntry()
+	if e.Level != logrus.WarnLevel || e.Data["param"] != "units" || e.Data["method"] != model.MethodSuperdenss {
+		t.Errorf("Incorrect warning: %s %v", e.Level, e.Data)
+	}
+}
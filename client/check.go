@@ -0,0 +1,87 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+)
+
+const (
+	// Maximum time to wait for an external tool to print its help
+	ToolCheckTimeout = 60 * time.Second
+)
+
+// Run the tool with --help, or --version if that fails, to check it and its
+// dependencies are installed. Python scripts with missing modules fail on
+// import
+func checkTool(path string) error {
+	err := checkExecutable(path)
+	if err != nil {
+		return err
+	}
+
+	for _, flag := range []string{"--help", "--version"} {
+		ctx, cancel := context.WithTimeout(context.Background(), ToolCheckTimeout)
+		out, cerr := exec.CommandContext(ctx, path, flag).CombinedOutput()
+		cancel()
+		if cerr == nil {
+			return nil
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		err = fmt.Errorf("%s %s failed: %s: %s", path, flag, cerr, lines[len(lines)-1])
+	}
+
+	return err
+}
+
+// Checks of the external tools and work directory used by the worker. Tools
+// with an empty path are optional and not checked, except the chart scripts
+// which default to the embedded copies
+func PreflightChecks() []app.Check {
+	keys := []string{"denss_path", "denssall_path", "superdenss_path", "align_path", "pdb2mrc_path", "fit_data_path"}
+	if !viper.GetBool("native_charts") {
+		keys = append(keys, "fsc_path", "summary_path")
+	}
+
+	checks := []app.Check{}
+	for _, key := range keys {
+		key := key
+		_, embedded := embeddedScripts[key]
+		if viper.GetString(key) == "" && key != "denssall_path" && !embedded {
+			continue
+		}
+		checks = append(checks, app.Check{Name: key, Run: func() error {
+			path, err := toolPath(key)
+			if err != nil {
+				return err
+			}
+			return checkTool(path)
+		}})
+	}
+	checks = append(checks, app.Check{Name: "work_dir", Run: checkWorkDir})
+
+	return checks
+}
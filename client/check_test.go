@@ -0,0 +1,59 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir, name, body string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckTool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	help := writeScript(t, dir, "help.py", `[ "$1" = "--help" ]`)
+	if err := checkTool(help); err != nil {
+		t.Errorf("Tool with --help should pass: %s", err)
+	}
+
+	version := writeScript(t, dir, "version.py", `[ "$1" = "--version" ]`)
+	if err := checkTool(version); err != nil {
+		t.Errorf("Tool with --version should pass: %s", err)
+	}
+
+	broken := writeScript(t, dir, "broken.py", "echo Traceback\necho \"ModuleNotFoundError: No module named 'matplotlib'\"\nexit 1\n")
+	err = checkTool(broken)
+	if err == nil || !strings.Contains(err.Error(), "matplotlib") {
+		t.Errorf("Broken tool should fail with the last line of output: %v", err)
+	}
+}
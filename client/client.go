@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -32,6 +33,11 @@ import (
 	"github.com/ubccr/denssweb/model"
 )
 
+const (
+	// Number of lines of the job log included in failure emails
+	LogExcerptLines = 20
+)
+
 func init() {
 	// Try and set sensible defaults here
 	wd, err := os.Getwd()
@@ -42,123 +48,177 @@ func init() {
 	viper.SetDefault("work_dir", filepath.Join(wd, "denssweb-work"))
 	viper.SetDefault("denss_path", "/usr/local/bin/denss.py")
 	viper.SetDefault("eman2dir", filepath.Join(os.Getenv("HOME"), "EMAN2"))
-	viper.SetDefault("fsc_path", filepath.Join(wd, "scripts", "denssweb-fsc-chart.py"))
-	viper.SetDefault("summary_path", filepath.Join(wd, "scripts", "denssweb-summary-chart.py"))
+	viper.SetDefault("native_charts", true)
+	viper.SetDefault("fsc_path", "")
+	viper.SetDefault("summary_path", "")
 	// Defaults to 10 minutes
 	viper.SetDefault("max_seconds", 3600)
+	viper.SetDefault("align_path", "")
+	viper.SetDefault("pdb2mrc_path", "/usr/local/bin/denss.pdb2mrc.py")
+	viper.SetDefault("fit_data_path", "/usr/local/bin/denss.fit_data.py")
+	viper.SetDefault("client_listen", "")
+	viper.SetDefault("worker_stall_seconds", 0)
+	viper.SetDefault("work_dir_min_free_mb", 1024)
 }
 
-func processJob(ctx *app.AppContext, job *model.Job, threads int) error {
+func processJob(ctx *app.AppContext, plog *logrus.Entry, job *model.Job, threads int) error {
 	// TODO make the percent complete more accurate
 
 	os.Setenv("LD_LIBRARY_PATH", filepath.Join(viper.GetString("eman2dir"), "lib"))
 	os.Setenv("PYTHONPATH", filepath.Join(viper.GetString("eman2dir"), "lib"))
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Creating job directory")
+	plog.Info("Creating job directory")
 
 	model.LogJobMessage(ctx.DB, job, "Setup", "Creating job directory", 0)
 	workDir := filepath.Join(viper.GetString("work_dir"), fmt.Sprintf("denss%d-%s", job.ID, job.Name))
 	os.RemoveAll(workDir)
 	err := os.MkdirAll(workDir, 0700)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to create working directory")
 		model.LogJobMessage(ctx.DB, job, "Setup Failed", "Failed to create job directory", 0)
 		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Creating log file")
+	plog.Info("Creating log file")
 
 	model.LogJobMessage(ctx.DB, job, "Setup", "Creating log file", 5)
 
-	log := logrus.New()
-
 	logPath := filepath.Join(workDir, fmt.Sprintf("denss-%d.log", job.ID))
 	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY, 0640)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to create job log file")
 		model.LogJobMessage(ctx.DB, job, "Setup Failed", "Failed to create job log file", 0)
 		return err
 	}
 	defer logFile.Close()
 
-	log.Out = logFile
+	log := app.JobLogger(logFile, job)
+
+	if job.Fit && job.FileType == "dat" {
+		plog.Info("Fitting experimental data")
+
+		model.LogJobMessage(ctx.DB, job, "Fit Data", "Fitting a smooth curve to the experimental data", 15)
+		err = fitData(log, job, workDir)
+		if err != nil {
+			plog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Failed to run denss.fit_data.py")
+			model.LogJobMessage(ctx.DB, job, "Fit Data Failed", "Failed to fit experimental data", 0)
+			return err
+		}
+	}
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Running DENSS All")
+	plog.Info("Running DENSS All")
 
 	model.LogJobMessage(ctx.DB, job, "Run DENSS All", "Performing parallel DENSS runs", 25)
 	err = runDenssAll(log, job, workDir, threads)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to run denss.all.py")
 		model.LogJobMessage(ctx.DB, job, "Run DENSS All Failed", "Failed to run DENSS All", 0)
 		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Saving MRC file")
+	plog.Info("Saving MRC file")
 
 	job.DensityMap, err = ioutil.ReadFile(filepath.Join(workDir, fmt.Sprintf("output_%d", job.ID), fmt.Sprintf("output_%d_avg.mrc", job.ID)))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to read MRC file")
 		model.LogJobMessage(ctx.DB, job, "Reading MRC file failed", "Failed to read MRC file", 0)
 		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Creating FSC Curve")
+	plog.Info("Computing density map statistics")
+
+	err = mapStats(log, job)
+	if err != nil {
+		plog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Invalid MRC file")
+		model.LogJobMessage(ctx.DB, job, "Invalid MRC file", "Failed to parse MRC file", 0)
+		return err
+	}
+
+	plog.Info("Rendering density map images")
+
+	err = mapImages(log, job)
+	if err != nil {
+		plog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to render density map images")
+		model.LogJobMessage(ctx.DB, job, "Density Map Images Failed", "Failed to render density map images", 0)
+		return err
+	}
+
+	if len(job.ModelData) > 0 {
+		plog.Info("Aligning density map to atomic model")
+		model.LogJobMessage(ctx.DB, job, "Align Model", "Aligning density map to atomic model", 75)
+
+		// The reconstruction is still useful without the model comparison so
+		// failures here are not fatal
+		err = alignModel(log, job, workDir)
+		if err != nil {
+			plog.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warn("Failed to align density map to atomic model")
+		}
+	}
+
+	plog.Info("Parsing results")
+	model.LogJobMessage(ctx.DB, job, "Parse Results", "Parsing FSC curve and summary statistics", 80)
+	err = parseResults(log, job, workDir)
+	if err != nil {
+		plog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to parse results")
+		model.LogJobMessage(ctx.DB, job, "Parse Results Failed", "Failed to parse FSC curve and summary statistics", 0)
+		return err
+	}
+
+	// Older versions of DENSS don't write the fit of the density map to the
+	// data so this is not fatal
+	err = parseFit(log, job, workDir)
+	if err != nil {
+		plog.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Failed to parse fit data")
+	}
+
+	plog.Info("Creating FSC Curve")
 	model.LogJobMessage(ctx.DB, job, "FSC Curve", "Plotting FSC Cruve", 85)
 	err = plotFSC(log, job, workDir)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to plot FSC curve")
 		model.LogJobMessage(ctx.DB, job, "FSC Curve Failed", "Failed to plot FSC curve", 0)
 		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Creating Summary Chart")
+	plog.Info("Creating Summary Chart")
 	model.LogJobMessage(ctx.DB, job, "Summary Chart", "Plotting Summary Stats", 90)
 	err = plotSummary(log, job, workDir)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to plot Summary chart")
 		model.LogJobMessage(ctx.DB, job, "Summary Chart Failed", "Failed to plot summary stats", 0)
 		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Creating zip archive")
+	plog.Info("Creating zip archive")
 	model.LogJobMessage(ctx.DB, job, "Creating ZIP", "Building zip archive of raw data", 95)
 	err = createZIP(job, workDir)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		plog.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to create zip archive")
 		model.LogJobMessage(ctx.DB, job, "Create ZIP Failed", "Failed to create zip archive", 0)
 		return err
@@ -167,129 +227,158 @@ func processJob(ctx *app.AppContext, job *model.Job, threads int) error {
 	return nil
 }
 
+// Last lines of the job log included in failure emails
+func logExcerpt(job *model.Job, workDir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(workDir, fmt.Sprintf("denss-%d.log", job.ID)))
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > LogExcerptLines {
+		lines = lines[len(lines)-LogExcerptLines:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func RunClient(ctx *app.AppContext, maxThreads int) {
 	logrus.Info("--------------------------------------------")
 	logrus.Info("Client config")
 	logrus.Info("--------------------------------------------")
 	logrus.Infof("Path to denss.py: %s", viper.GetString("denss_path"))
 	logrus.Infof("Path to EMAN2: %s", viper.GetString("eman2dir"))
-	logrus.Infof("Path to denssweb-fsc-chart.py: %s", viper.GetString("fsc_path"))
-	logrus.Infof("Path to denss-summary-chart.py: %s", viper.GetString("summary_path"))
+	if viper.GetBool("native_charts") {
+		logrus.Info("Rendering charts natively")
+	} else {
+		logrus.Infof("Path to denssweb-fsc-chart.py: %s", scriptSource("fsc_path"))
+		logrus.Infof("Path to denss-summary-chart.py: %s", scriptSource("summary_path"))
+	}
+	if viper.GetString("align_path") != "" {
+		logrus.Infof("Path to denss.align.py: %s", viper.GetString("align_path"))
+	} else {
+		logrus.Info("Aligning maps natively")
+	}
+	logrus.Infof("Path to denss.pdb2mrc.py: %s", viper.GetString("pdb2mrc_path"))
+	logrus.Infof("Path to denss.fit_data.py: %s", viper.GetString("fit_data_path"))
 	logrus.Infof("Max number of seconds: %d", viper.GetInt("max_seconds"))
 	logrus.Infof("Job Work directory: %s", viper.GetString("work_dir"))
 	logrus.Infof("Max threads: %d", maxThreads)
+	logrus.Infof("Worker name: %s", app.WorkerName())
+	if viper.GetString("client_listen") != "" {
+		logrus.Infof("Serving metrics and health checks on: http://%s", viper.GetString("client_listen"))
+	}
 	logrus.Info("--------------------------------------------")
 	runtime.GOMAXPROCS(maxThreads)
 
+	if viper.GetString("client_listen") != "" {
+		go serveClient(ctx, viper.GetString("client_listen"))
+	}
+
+	wlog := logrus.WithField("worker", app.WorkerName())
+
 	for {
+		beat()
 		time.Sleep(3 * time.Second)
 
 		job, err := model.FetchNextPending(ctx.DB)
 		if err != nil {
 			if err != sql.ErrNoRows {
-				logrus.WithFields(logrus.Fields{
+				wlog.WithFields(logrus.Fields{
 					"error": err.Error(),
 				}).Error("Failed to fetch pending job")
+			} else {
+				// Comparisons are quick so only run them when no jobs are
+				// waiting
+				runNextComparison(ctx, wlog)
 			}
 			continue
 		}
 
-		logrus.WithFields(logrus.Fields{
-			"id":  job.ID,
+		jlog := wlog.WithFields(app.JobFields(job))
+		jlog.WithFields(logrus.Fields{
 			"url": job.URL(),
 		}).Info("Processing new job")
 
+		app.ObserveJobStarted(job)
+		ctx.Notify(job, model.EventStarted, "")
+
 		workDir := filepath.Join(viper.GetString("work_dir"), fmt.Sprintf("denss%d-%s", job.ID, job.Name))
 
-		err = processJob(ctx, job, maxThreads)
+		err = processJob(ctx, jlog, job, maxThreads)
 		if err != nil {
 			// create zip of logs if job failed
-			logrus.WithFields(logrus.Fields{
-				"id": job.ID,
-			}).Info("Creating zip archive for failed job")
+			jlog.Info("Creating zip archive for failed job")
 			zerr := createZIP(job, workDir)
 			if zerr != nil {
-				logrus.WithFields(logrus.Fields{
+				jlog.WithFields(logrus.Fields{
 					"error": zerr.Error(),
-					"id":    job.ID,
 				}).Error("Failed to create zip archive for failed job")
 			}
 
 			cerr := model.CompleteJob(ctx.DB, job, model.StatusError)
 			if cerr != nil {
-				logrus.WithFields(logrus.Fields{
+				jlog.WithFields(logrus.Fields{
 					"error": cerr.Error(),
 					"url":   job.URL(),
-					"id":    job.ID,
 				}).Error("Failed save failed job to database")
+			} else {
+				app.ObserveJobCompleted(job)
 			}
 
-			logrus.WithFields(logrus.Fields{
+			jlog.WithFields(logrus.Fields{
 				"error": err.Error(),
 				"url":   job.URL(),
-				"id":    job.ID,
 			}).Error("Failed to process job")
 
+			excerpt := logExcerpt(job, workDir)
+
 			err = os.RemoveAll(workDir)
 			if err != nil {
-				logrus.WithFields(logrus.Fields{
+				jlog.WithFields(logrus.Fields{
 					"error":   err.Error(),
 					"url":     job.URL(),
-					"id":      job.ID,
 					"workDir": workDir,
 				}).Error("Failed to clean up work dir")
 			}
 
-			if len(job.Email) > 0 {
-				err = ctx.SendEmail(job.Email, "FAILED", job.URL(), job.ID)
-				if err != nil {
-					logrus.WithFields(logrus.Fields{
-						"job_id": job.ID,
-						"email":  job.Email,
-						"url":    job.URL(),
-						"status": "FAILED",
-						"error":  err,
-					}).Error("Failed to send email")
-				}
-			}
+			ctx.Notify(job, model.EventFailed, excerpt)
 			continue
 		}
 
-		if len(job.Email) > 0 {
-			err = ctx.SendEmail(job.Email, "COMPLETED", job.URL(), job.ID)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"job_id": job.ID,
-					"email":  job.Email,
-					"url":    job.URL(),
-					"status": "COMPLETED",
-					"error":  err,
-				}).Error("Failed to send email")
-			}
-		}
-
 		model.LogJobMessage(ctx.DB, job, "Complete", "Job completed successfully", 100)
 		err = model.CompleteJob(ctx.DB, job, model.StatusComplete)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
+			jlog.WithFields(logrus.Fields{
 				"error": err.Error(),
 				"url":   job.URL(),
-				"id":    job.ID,
 			}).Error("Failed to save completed job")
+
+			// Don't leave the job stuck in running state
+			cerr := model.CompleteJob(ctx.DB, job, model.StatusError)
+			if cerr != nil {
+				jlog.WithFields(logrus.Fields{
+					"error": cerr.Error(),
+					"url":   job.URL(),
+				}).Error("Failed save failed job to database")
+			}
+			ctx.Notify(job, model.EventFailed, "")
 			continue
 		}
 
-		logrus.WithFields(logrus.Fields{
-			"id":  job.ID,
+		app.ObserveJobCompleted(job)
+
+		jlog.WithFields(logrus.Fields{
 			"url": job.URL(),
 		}).Info("Job processed succesfully")
 
+		ctx.Notify(job, model.EventCompleted, "")
+
 		err = os.RemoveAll(workDir)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
+			jlog.WithFields(logrus.Fields{
 				"error":   err.Error(),
 				"url":     job.URL(),
-				"id":      job.ID,
 				"workDir": workDir,
 			}).Error("Failed to clean up work dir")
 		}
@@ -0,0 +1,39 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"os/exec"
+	"time"
+
+	"github.com/ubccr/denssweb/app"
+)
+
+// Run cmd returning its combined output and record its duration and exit code
+// under the given processing step. The worker beats as the command starts and
+// exits so long jobs are not mistaken for a stalled loop
+func runCommand(step string, cmd *exec.Cmd) ([]byte, error) {
+	beat()
+	defer beat()
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	app.ObserveCommand(step, time.Since(start), err)
+
+	return out, err
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+// Fetch the density map and FSC curve of a completed job
+func fetchJobMap(ctx *app.AppContext, token string) (*mrc.Map, *chart.FSC, error) {
+	job, err := model.FetchDensityMap(ctx.DB, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := mrc.Decode(job.DensityMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job, err = model.FetchFSCData(ctx.DB, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Jobs completed before the FSC data was stored have no curve
+	if len(job.FSCData) == 0 {
+		return m, nil, nil
+	}
+
+	fsc := &chart.FSC{}
+	err = json.Unmarshal(job.FSCData, fsc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, fsc, nil
+}
+
+// Run denss.align.py in workDir to align the map in file moving to the map or
+// atomic model in file ref. Returns the aligned map
+func denssAlign(log *logrus.Entry, workDir, moving, ref string) (*mrc.Map, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
+	defer cancel()
+
+	args := []string{
+		"-f",
+		moving,
+		"-ref",
+		ref,
+		"-o",
+		"aligned",
+	}
+
+	log.WithFields(logrus.Fields{
+		"moving": moving,
+		"ref":    ref,
+	}).Info("Running denss.align.py")
+
+	cmd := exec.CommandContext(ctx, viper.GetString("align_path"), args...)
+	cmd.Dir = workDir
+	out, err := runCommand("align", cmd)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"output": string(out),
+		}).Error("Failed to run denss.align.py")
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(workDir, "aligned.mrc"))
+	if err != nil {
+		return nil, err
+	}
+
+	return mrc.Decode(data)
+}
+
+// Align map b to map a with denss.align.py and resample it onto the grid of a
+func runDenssAlign(log *logrus.Entry, comp *model.Comparison, a, b *mrc.Map) (*mrc.Map, error) {
+	workDir := filepath.Join(viper.GetString("work_dir"), fmt.Sprintf("comparison%d", comp.ID))
+	os.RemoveAll(workDir)
+	err := os.MkdirAll(workDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	err = ioutil.WriteFile(filepath.Join(workDir, "reference.mrc"), a.Encode(), 0640)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(workDir, "moving.mrc"), b.Encode(), 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	aligned, err := denssAlign(log, workDir, "moving.mrc", "reference.mrc")
+	if err != nil {
+		return nil, err
+	}
+
+	return aligned.Resample(a), nil
+}
+
+// Align the map of job B to job A and compute the FSC between them, the
+// difference map and the overlay chart
+func processComparison(ctx *app.AppContext, log *logrus.Entry, comp *model.Comparison) error {
+	model.LogComparisonMessage(ctx.DB, comp, "Setup", "Fetching density maps")
+	a, fscA, err := fetchJobMap(ctx, comp.JobAToken)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch density map of job %d: %s", comp.JobA, err)
+	}
+	b, fscB, err := fetchJobMap(ctx, comp.JobBToken)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch density map of job %d: %s", comp.JobB, err)
+	}
+
+	var aligned *mrc.Map
+	if viper.GetString("align_path") != "" {
+		model.LogComparisonMessage(ctx.DB, comp, "Align", "Aligning maps with denss.align.py")
+		aligned, err = runDenssAlign(log, comp, a, b)
+		if err != nil {
+			return err
+		}
+		comp.Correlation = mrc.Correlation(a, aligned)
+	} else {
+		model.LogComparisonMessage(ctx.DB, comp, "Align", "Aligning maps by principal axes")
+		aligned, comp.Correlation = b.Align(a)
+	}
+
+	model.LogComparisonMessage(ctx.DB, comp, "FSC", "Computing FSC between maps")
+	freq, corr, err := mrc.FSC(a, aligned)
+	if err != nil {
+		return err
+	}
+	fsc := &chart.FSC{Frequency: freq, Correlation: corr}
+	comp.FSCData, err = json.Marshal(fsc)
+	if err != nil {
+		return err
+	}
+
+	diff, err := mrc.Difference(a, aligned)
+	if err != nil {
+		return err
+	}
+	comp.DifferenceMap = diff.Encode()
+	comp.AlignedMap = aligned.Encode()
+
+	var buf bytes.Buffer
+	err = chart.CompareChart(fsc, fscA, fscB, comp.JobAName, comp.JobBName).WritePNG(&buf)
+	if err != nil {
+		return err
+	}
+	comp.OverlayChart = buf.Bytes()
+
+	log.WithFields(logrus.Fields{
+		"correlation": comp.Correlation,
+		"resolution":  fsc.Resolution(),
+	}).Info("Comparison completed")
+
+	return nil
+}
+
+// Process the next pending comparison, if any
+func runNextComparison(ctx *app.AppContext, log *logrus.Entry) {
+	comp, err := model.FetchNextPendingComparison(ctx.DB)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Failed to fetch pending comparison")
+		}
+		return
+	}
+
+	log = log.WithField("comparison_id", comp.ID)
+	log.WithFields(logrus.Fields{
+		"url": comp.URL(),
+	}).Info("Processing new comparison")
+
+	status := model.StatusComplete
+	err = processComparison(ctx, log, comp)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to process comparison")
+		model.LogComparisonMessage(ctx.DB, comp, "Failed", err.Error())
+		status = model.StatusError
+	} else {
+		model.LogComparisonMessage(ctx.DB, comp, "Complete", "Comparison completed successfully")
+	}
+
+	err = model.CompleteComparison(ctx.DB, comp, status)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to save comparison")
+	}
+}
@@ -23,7 +23,6 @@ import (
 	"io/ioutil"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -33,69 +32,29 @@ import (
 )
 
 // Exec single denss.py process
-func execDenss(log *logrus.Logger, job *model.Job, workDir, inputFile string, thread int) error {
+func execDenss(log *logrus.Entry, job *model.Job, workDir, inputFile string, thread int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
 	defer cancel()
 
 	outputPrefix := filepath.Join(workDir, fmt.Sprintf("output_%d", thread))
-	args := []string{
-		"-f",
-		inputFile,
-		"--oversampling",
-		fmt.Sprintf("%.4f", job.Oversampling),
-		"-o",
-		outputPrefix,
-		"--plot_off",
-		"--mode",
-		strings.ToUpper(job.Mode),
-	}
-
-	if job.Dmax > 0 {
-		args = append(args, "-d")
-		args = append(args, fmt.Sprintf("%.4f", job.Dmax))
-	}
-	if job.VoxelSize > 0 {
-		args = append(args, "--voxel")
-		args = append(args, fmt.Sprintf("%.4f", job.VoxelSize))
-	}
-	if job.Electrons > 0 {
-		args = append(args, "--ne")
-		args = append(args, fmt.Sprintf("%d", job.Electrons))
-	}
-	if job.NumSamples > 0 {
-		args = append(args, "--nsamples")
-		args = append(args, fmt.Sprintf("%d", job.NumSamples))
-	}
-	if !job.Enantiomer {
-		args = append(args, "--enforce_connectivity_off")
-	}
-
-	/*
-		if job.MaxSteps > 0 {
-			args = append(args, "--steps")
-			args = append(args, fmt.Sprintf("%d", job.MaxSteps))
-		}
-	*/
+	args := denssArgs(log, job, inputFile, outputPrefix)
 
 	log.WithFields(logrus.Fields{
-		"id":     job.ID,
 		"thread": thread,
 	}).Info("Running denss")
 
 	cmd := exec.CommandContext(ctx, viper.GetString("denss_path"), args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("denss", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"output": string(out),
 		}).Error("Failed to run denss job")
 		return err
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":     job.ID,
 		"thread": thread,
 	}).Info("denss completed successfully")
 
@@ -103,7 +62,7 @@ func execDenss(log *logrus.Logger, job *model.Job, workDir, inputFile string, th
 }
 
 // Run denss.py in parallel
-func runDenss(log *logrus.Logger, job *model.Job, workDir string, threads int) error {
+func runDenss(log *logrus.Entry, job *model.Job, workDir string, threads int) error {
 	threads = 1
 
 	inputFile := filepath.Join(workDir, fmt.Sprintf("input.%s", job.FileType))
@@ -111,7 +70,6 @@ func runDenss(log *logrus.Logger, job *model.Job, workDir string, threads int) e
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to write input data file")
 		return err
 	}
@@ -126,7 +84,6 @@ func runDenss(log *logrus.Logger, job *model.Job, workDir string, threads int) e
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":        job.ID,
 		"batches":   batches,
 		"threads":   threads,
 		"remainder": remainder,
@@ -148,7 +105,6 @@ func runDenss(log *logrus.Logger, job *model.Job, workDir string, threads int) e
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":        job.ID,
 		"batches":   batches,
 		"threads":   threads,
 		"remainder": remainder,
@@ -158,7 +114,7 @@ func runDenss(log *logrus.Logger, job *model.Job, workDir string, threads int) e
 	return nil
 }
 
-func runDenssBatch(log *logrus.Logger, job *model.Job, workDir, inputFile string, threads, batchOffset int) error {
+func runDenssBatch(log *logrus.Entry, job *model.Job, workDir, inputFile string, threads, batchOffset int) error {
 	var wg sync.WaitGroup
 	errChannel := make(chan error, 1)
 
@@ -166,7 +122,6 @@ func runDenssBatch(log *logrus.Logger, job *model.Job, workDir, inputFile string
 	finished := make(chan bool, 1)
 
 	log.WithFields(logrus.Fields{
-		"id":          job.ID,
 		"batchOffset": batchOffset,
 		"threads":     threads,
 	}).Info("Spawning denss.py parallel runs")
@@ -23,7 +23,6 @@ import (
 	"io/ioutil"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -32,7 +31,7 @@ import (
 )
 
 // Run denss.all.py
-func runDenssAll(log *logrus.Logger, job *model.Job, workDir string, threads int) error {
+func runDenssAll(log *logrus.Entry, job *model.Job, workDir string, threads int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
 	defer cancel()
 
@@ -41,78 +40,29 @@ func runDenssAll(log *logrus.Logger, job *model.Job, workDir string, threads int
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to write input data file")
 		return err
 	}
 
 	outputPrefix := fmt.Sprintf("output_%d", job.ID)
-	args := []string{
-		"-f",
-		inputFile,
-		"-o",
-		outputPrefix,
-		"-j",
-		fmt.Sprintf("%d", threads),
-		"--plot_off",
-		"--quiet",
-		"--mode",
-		strings.ToUpper(job.Mode),
-	}
-
-	if viper.GetBool("enable_gpu") {
-		args = append(args, "--gpu")
-	}
-
-	if job.Units != "" {
-		args = append(args, "--units")
-		args = append(args, job.Units)
-	}
-
-	if !job.Enantiomer {
-		args = append(args, "-en_off")
-	}
-
-	if job.Dmax > 0 {
-		args = append(args, "-d")
-		args = append(args, fmt.Sprintf("%.4f", job.Dmax))
-	}
-	if job.Electrons > 0 {
-		args = append(args, "--ne")
-		args = append(args, fmt.Sprintf("%d", job.Electrons))
-	}
-	if job.Symmetry > 0 {
-		args = append(args, "-ncs")
-		args = append(args, fmt.Sprintf("%d", job.Symmetry))
-		if job.SymmetryAxis > 0 {
-			args = append(args, "-ncs_axis")
-			args = append(args, fmt.Sprintf("%d", job.SymmetryAxis))
-		}
-		if job.SymmetrySteps != "" {
-			args = append(args, "-ncs_steps")
-			args = append(args, job.SymmetrySteps)
-		}
-	}
+	args := denssAllArgs(log, job, inputFile, outputPrefix, threads)
 
 	log.WithFields(logrus.Fields{
-		"id":      job.ID,
 		"threads": threads,
 	}).Info("Running denss.all.py")
 
 	cmd := exec.CommandContext(ctx, viper.GetString("denssall_path"), args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("denss.all", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"output": string(out),
 		}).Error("Failed to run denss.all.py job")
 		return err
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":      job.ID,
 		"threads": threads,
 	}).Info("denss.all.py completed successfully")
 
@@ -32,7 +32,7 @@ import (
 )
 
 // Combine DENSS output files into a single HDF file
-func buildStack(log *logrus.Logger, job *model.Job, workDir string) error {
+func buildStack(log *logrus.Entry, job *model.Job, workDir string) error {
 	stackFile := filepath.Join(workDir, "stack.hdf")
 
 	args := []string{
@@ -44,17 +44,15 @@ func buildStack(log *logrus.Logger, job *model.Job, workDir string) error {
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":        job.ID,
 		"stackFile": stackFile,
 	}).Info("Building stack hdf using EMAN2")
 
 	e2stacks := filepath.Join(viper.GetString("eman2dir"), "bin", "e2buildstacks.py")
 	cmd := exec.Command(e2stacks, args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("e2buildstacks", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
-			"id":        job.ID,
 			"error":     err.Error(),
 			"stackFile": stackFile,
 			"output":    string(out),
@@ -63,7 +61,6 @@ func buildStack(log *logrus.Logger, job *model.Job, workDir string) error {
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":        job.ID,
 		"stackFile": stackFile,
 	}).Info("stack hdf built successfully")
 
@@ -71,7 +68,7 @@ func buildStack(log *logrus.Logger, job *model.Job, workDir string) error {
 }
 
 // Run initial subtomogram averaging
-func runSubtomogramAveraging(log *logrus.Logger, job *model.Job, workDir string) error {
+func runSubtomogramAveraging(log *logrus.Entry, job *model.Job, workDir string) error {
 	stackFile := filepath.Join(workDir, "stack.hdf")
 
 	args := []string{
@@ -82,17 +79,15 @@ func runSubtomogramAveraging(log *logrus.Logger, job *model.Job, workDir string)
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":        job.ID,
 		"stackFile": stackFile,
 	}).Info("Building subtomogram using EMAN2")
 
 	e2binaryTree := filepath.Join(viper.GetString("eman2dir"), "bin", "e2spt_binarytree.py")
 	cmd := exec.Command(e2binaryTree, args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("e2spt_binarytree", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
-			"id":        job.ID,
 			"error":     err.Error(),
 			"stackFile": stackFile,
 			"output":    string(out),
@@ -106,7 +101,6 @@ func runSubtomogramAveraging(log *logrus.Logger, job *model.Job, workDir string)
 	_, err = os.Stat(finalAvgFile)
 	if os.IsNotExist(err) {
 		log.WithFields(logrus.Fields{
-			"id":           job.ID,
 			"error":        err.Error(),
 			"finalAvgFile": finalAvgFile,
 			"stackFile":    stackFile,
@@ -115,7 +109,6 @@ func runSubtomogramAveraging(log *logrus.Logger, job *model.Job, workDir string)
 		return err
 	} else if err != nil {
 		log.WithFields(logrus.Fields{
-			"id":           job.ID,
 			"error":        err.Error(),
 			"finalAvgFile": finalAvgFile,
 			"stackFile":    stackFile,
@@ -125,7 +118,6 @@ func runSubtomogramAveraging(log *logrus.Logger, job *model.Job, workDir string)
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":        job.ID,
 		"stackFile": stackFile,
 	}).Info("subtomogram built successfully")
 
@@ -133,7 +125,7 @@ func runSubtomogramAveraging(log *logrus.Logger, job *model.Job, workDir string)
 }
 
 // Run averaging
-func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads int) error {
+func runAveraging(log *logrus.Entry, job *model.Job, workDir string, threads int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
 	defer cancel()
 
@@ -154,7 +146,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":               job.ID,
 		"stackResizedFile": stackResizedFile,
 		"refFile":          refFile,
 		"threads":          threads,
@@ -163,11 +154,10 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	e2spt := filepath.Join(viper.GetString("eman2dir"), "bin", "e2spt_classaverage.py")
 	cmd := exec.CommandContext(ctx, e2spt, args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("e2spt_classaverage", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":            err.Error(),
-			"id":               job.ID,
 			"stackResizedFile": stackResizedFile,
 			"threads":          threads,
 			"output":           string(out),
@@ -181,7 +171,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	_, err = os.Stat(densityMapHDF)
 	if os.IsNotExist(err) {
 		log.WithFields(logrus.Fields{
-			"id":               job.ID,
 			"error":            err.Error(),
 			"hdf":              densityMapHDF,
 			"stackResizedFile": stackResizedFile,
@@ -190,7 +179,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 		return err
 	} else if err != nil {
 		log.WithFields(logrus.Fields{
-			"id":               job.ID,
 			"error":            err.Error(),
 			"hdf":              densityMapHDF,
 			"stackResizedFile": stackResizedFile,
@@ -200,7 +188,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":               job.ID,
 		"stackResizedFile": stackResizedFile,
 		"threads":          threads,
 	}).Info("Averaging completed successfully")
@@ -208,7 +195,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	densityMapCCP4 := filepath.Join(workDir, "output_averaged.ccp4")
 
 	log.WithFields(logrus.Fields{
-		"id":   job.ID,
 		"hdf":  densityMapHDF,
 		"ccp4": densityMapCCP4,
 	}).Info("Converting electron density map to CCP4")
@@ -221,11 +207,10 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 
 	cmd = exec.CommandContext(ctx, e2proc3d, args...)
 	cmd.Dir = workDir
-	out, err = cmd.CombinedOutput()
+	out, err = runCommand("e2proc3d", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"hdf":    densityMapHDF,
 			"ccp4":   densityMapCCP4,
 			"output": string(out),
@@ -237,7 +222,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"hdf":    densityMapHDF,
 			"ccp4":   densityMapCCP4,
 			"output": string(out),
@@ -246,7 +230,6 @@ func runAveraging(log *logrus.Logger, job *model.Job, workDir string, threads in
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":   job.ID,
 		"hdf":  densityMapHDF,
 		"ccp4": densityMapCCP4,
 	}).Info("Successfully converted electron density map to CCP4")
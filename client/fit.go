@@ -0,0 +1,138 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Fit a smooth curve to raw experimental data with denss.fit_data.py. The fit
+// replaces the input data of the job so DENSS uses it for the reconstruction
+func fitData(log *logrus.Entry, job *model.Job, workDir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
+	defer cancel()
+
+	inputFile := fmt.Sprintf("input.%s", job.FileType)
+	err := ioutil.WriteFile(filepath.Join(workDir, inputFile), job.InputData, 0640)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to write input data file")
+		return err
+	}
+
+	args := []string{
+		"-f",
+		inputFile,
+		"-o",
+		"input_fit",
+		"--no_gui",
+	}
+
+	if job.Dmax > 0 {
+		args = append(args, "-d")
+		args = append(args, fmt.Sprintf("%.4f", job.Dmax))
+	}
+
+	log.Info("Running denss.fit_data.py")
+
+	cmd := exec.CommandContext(ctx, viper.GetString("fit_data_path"), args...)
+	cmd.Dir = workDir
+	out, err := runCommand("fit_data", cmd)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"output": string(out),
+		}).Error("Failed to run denss.fit_data.py")
+		return err
+	}
+
+	job.InputData, err = ioutil.ReadFile(filepath.Join(workDir, "input_fit.fit"))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to read fit data file")
+		return err
+	}
+	job.FileType = "fit"
+
+	log.Info("denss.fit_data.py completed successfully")
+
+	return nil
+}
+
+// Parse the fit of the intensity calculated from the density map to the
+// experimental data and store it in the job as JSON. DENSS writes a fit for
+// each run, the fit of the averaged map is used when present otherwise the
+// fit of the first run.
+func parseFit(log *logrus.Entry, job *model.Job, workDir string) error {
+	outputPrefix := fmt.Sprintf("output_%d", job.ID)
+	outputDir := filepath.Join(workDir, outputPrefix)
+
+	fitFile := filepath.Join(outputDir, outputPrefix+"_avg_map.fit")
+	if _, err := os.Stat(fitFile); err != nil {
+		files, err := filepath.Glob(filepath.Join(outputDir, outputPrefix+"_*_map.fit"))
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("No fit data files found in %s", outputDir)
+		}
+		sort.Strings(files)
+		fitFile = files[0]
+	}
+
+	log.WithFields(logrus.Fields{
+		"data": fitFile,
+	}).Info("Parsing fit data")
+
+	fh, err := os.Open(fitFile)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	fit, err := chart.ParseFit(fh)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"data":  fitFile,
+		}).Error("Failed to parse fit data file")
+		return err
+	}
+
+	job.FitData, err = json.Marshal(fit)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -18,24 +18,30 @@
 package client
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
 	"github.com/ubccr/denssweb/model"
 )
 
 // Create Fourier Shell Correlation (FSC) curve
-func plotFSC(log *logrus.Logger, job *model.Job, workDir string) error {
+func plotFSC(log *logrus.Entry, job *model.Job, workDir string) error {
 	outputPrefix := fmt.Sprintf("output_%d", job.ID)
 	fscData := filepath.Join(workDir, outputPrefix, outputPrefix+"_fsc.dat")
 	fscPNG := filepath.Join(workDir, "fsc.png")
 
+	if viper.GetBool("native_charts") {
+		return renderFSC(log, job, fscData, workDir)
+	}
+
 	log.WithFields(logrus.Fields{
-		"id":   job.ID,
 		"data": fscData,
 	}).Info("Plotting fsc curve")
 
@@ -46,13 +52,20 @@ func plotFSC(log *logrus.Logger, job *model.Job, workDir string) error {
 		fscPNG,
 	}
 
-	cmd := exec.Command(viper.GetString("fsc_path"), args...)
+	script, err := toolPath("fsc_path")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to find FSC chart script")
+		return err
+	}
+
+	cmd := exec.Command(script, args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("fsc_chart", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"data":   fscData,
 			"png":    fscPNG,
 			"output": string(out),
@@ -64,7 +77,6 @@ func plotFSC(log *logrus.Logger, job *model.Job, workDir string) error {
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"data":   fscData,
 			"png":    fscPNG,
 			"output": string(out),
@@ -73,10 +85,78 @@ func plotFSC(log *logrus.Logger, job *model.Job, workDir string) error {
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":   job.ID,
 		"data": fscData,
 		"png":  fscPNG,
 	}).Info("Successfully created FSC curve")
 
 	return nil
 }
+
+// Render Fourier Shell Correlation (FSC) curve natively in PNG and SVG format
+func renderFSC(log *logrus.Entry, job *model.Job, fscData, workDir string) error {
+	log.WithFields(logrus.Fields{
+		"data": fscData,
+	}).Info("Rendering fsc curve")
+
+	fh, err := os.Open(fscData)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"data":  fscData,
+		}).Error("Failed to open FSC data file")
+		return err
+	}
+	defer fh.Close()
+
+	fsc, err := chart.ParseFSC(fh)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"data":  fscData,
+		}).Error("Failed to parse FSC data file")
+		return err
+	}
+
+	job.FSCChart, err = writeChart(chart.FSCChart(fsc), filepath.Join(workDir, "fsc"))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"data":  fscData,
+		}).Error("Failed to render FSC curve")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"data":       fscData,
+		"resolution": fsc.Resolution(),
+	}).Info("Successfully rendered FSC curve")
+
+	return nil
+}
+
+// Write figure to prefix.png and prefix.svg. Returns the PNG bytes
+func writeChart(fig *chart.Figure, prefix string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := fig.WritePNG(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(prefix+".png", buf.Bytes(), 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	svg, err := os.Create(prefix + ".svg")
+	if err != nil {
+		return nil, err
+	}
+	defer svg.Close()
+
+	err = fig.WriteSVG(svg)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
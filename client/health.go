@@ -0,0 +1,159 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+)
+
+const (
+	// Extra time allowed beyond max_seconds between loop iterations or
+	// external commands before the worker is considered stalled
+	StallGrace = 5 * time.Minute
+)
+
+var (
+	// Unix time in nanoseconds of the last loop iteration or external
+	// command start or exit
+	lastBeat int64
+)
+
+// Record that the worker is making progress
+func beat() {
+	atomic.StoreInt64(&lastBeat, time.Now().UnixNano())
+}
+
+// Maximum time between beats of a healthy worker
+func stallTimeout() time.Duration {
+	if s := viper.GetInt("worker_stall_seconds"); s > 0 {
+		return time.Duration(s) * time.Second
+	}
+
+	return time.Duration(viper.GetInt64("max_seconds"))*time.Second + StallGrace
+}
+
+// Check the worker loop ran recently. Long running jobs beat as each
+// external command starts and exits
+func checkLoop() error {
+	last := atomic.LoadInt64(&lastBeat)
+	if last == 0 {
+		return fmt.Errorf("Worker loop has not started")
+	}
+
+	since := time.Since(time.Unix(0, last))
+	if since > stallTimeout() {
+		return fmt.Errorf("Worker loop last ran %s ago", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// Check path is a regular file executable by someone
+func checkExecutable(path string) error {
+	if path == "" {
+		return fmt.Errorf("Path not configured")
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if fi.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+
+	return nil
+}
+
+// Check the work directory is writable and has at least
+// work_dir_min_free_mb of free space
+func checkWorkDir() error {
+	dir := viper.GetString("work_dir")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".healthcheck-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	bytes, err := freeSpace(dir)
+	if err != nil {
+		return err
+	}
+
+	free := bytes / (1024 * 1024)
+	min := uint64(viper.GetInt64("work_dir_min_free_mb"))
+	if free < min {
+		return fmt.Errorf("%s has %d MB free, need at least %d MB", dir, free, min)
+	}
+
+	return nil
+}
+
+// Config keys of external executables run by the worker. The chart scripts
+// are only run when charts are not rendered natively
+func executables() []string {
+	keys := []string{"denssall_path"}
+	if !viper.GetBool("native_charts") {
+		keys = append(keys, "fsc_path", "summary_path")
+	}
+
+	return keys
+}
+
+// Checks for the liveness of the worker
+func liveChecks() []app.Check {
+	return []app.Check{
+		{Name: "loop", Run: checkLoop},
+	}
+}
+
+// Checks for the worker to be able to run jobs
+func readyChecks(ctx *app.AppContext) []app.Check {
+	checks := ctx.ReadyChecks()
+	for _, key := range executables() {
+		key := key
+		checks = append(checks, app.Check{Name: key, Run: func() error {
+			path, err := toolPath(key)
+			if err != nil {
+				return err
+			}
+			return checkExecutable(path)
+		}})
+	}
+	checks = append(checks,
+		app.Check{Name: "work_dir", Run: checkWorkDir},
+		app.Check{Name: "loop", Run: checkLoop})
+
+	return checks
+}
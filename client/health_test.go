@@ -0,0 +1,104 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "denss.all.py")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkExecutable(script) == nil {
+		t.Errorf("Non executable file should fail")
+	}
+	if checkExecutable(dir) == nil {
+		t.Errorf("Directory should fail")
+	}
+	if checkExecutable("") == nil {
+		t.Errorf("Empty path should fail")
+	}
+	if checkExecutable(filepath.Join(dir, "missing.py")) == nil {
+		t.Errorf("Missing file should fail")
+	}
+
+	os.Chmod(script, 0755)
+	if err := checkExecutable(script); err != nil {
+		t.Errorf("Executable file should pass: %s", err)
+	}
+}
+
+func TestCheckWorkDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	viper.Set("work_dir", filepath.Join(dir, "work"))
+	viper.Set("work_dir_min_free_mb", 1)
+	defer viper.Set("work_dir", nil)
+	defer viper.Set("work_dir_min_free_mb", nil)
+
+	if err := checkWorkDir(); err != nil {
+		t.Errorf("Work dir should pass: %s", err)
+	}
+
+	viper.Set("work_dir_min_free_mb", int64(1)<<50)
+	if checkWorkDir() == nil {
+		t.Errorf("Work dir without enough free space should fail")
+	}
+}
+
+func TestCheckLoop(t *testing.T) {
+	viper.Set("worker_stall_seconds", 60)
+	defer viper.Set("worker_stall_seconds", nil)
+	defer atomic.StoreInt64(&lastBeat, 0)
+
+	atomic.StoreInt64(&lastBeat, 0)
+	if checkLoop() == nil {
+		t.Errorf("Loop that never ran should fail")
+	}
+
+	beat()
+	if err := checkLoop(); err != nil {
+		t.Errorf("Recent loop should pass: %s", err)
+	}
+
+	atomic.StoreInt64(&lastBeat, time.Now().Add(-2*time.Minute).UnixNano())
+	if checkLoop() == nil {
+		t.Errorf("Stalled loop should fail")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ubccr/denssweb/app"
+)
+
+// Handler for the endpoints served by the client on client_listen
+func clientHandler(ctx *app.AppContext) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.RegistryHandler())
+	mux.Handle("/healthz", app.HealthHandler(liveChecks))
+	mux.Handle("/readyz", app.HealthHandler(func() []app.Check { return readyChecks(ctx) }))
+
+	return mux
+}
+
+// Serve the client endpoints on addr. Never returns
+func serveClient(ctx *app.AppContext, addr string) {
+	srv := &http.Server{
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+		Addr:         addr,
+		Handler:      clientHandler(ctx),
+	}
+
+	logrus.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+// Compute the density of the atomic model in file modelFile with
+// denss.pdb2mrc.py on a grid with the same voxel size and side as m
+func runPDB2MRC(log *logrus.Entry, job *model.Job, workDir, modelFile string, m *mrc.Map) (*mrc.Map, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
+	defer cancel()
+
+	voxel := m.VoxelSize()
+	args := []string{
+		"-f",
+		modelFile,
+		"-v",
+		fmt.Sprintf("%.4f", voxel[0]),
+		"-s",
+		fmt.Sprintf("%.4f", float64(m.NX)*voxel[0]),
+		"-o",
+		"model",
+	}
+
+	log.WithFields(logrus.Fields{
+		"model": modelFile,
+	}).Info("Running denss.pdb2mrc.py")
+
+	cmd := exec.CommandContext(ctx, viper.GetString("pdb2mrc_path"), args...)
+	cmd.Dir = workDir
+	out, err := runCommand("pdb2mrc", cmd)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"output": string(out),
+		}).Error("Failed to run denss.pdb2mrc.py")
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(workDir, "model.mrc"))
+	if err != nil {
+		return nil, err
+	}
+
+	return mrc.Decode(data)
+}
+
+// Align the averaged density map to the atomic model uploaded with the job and
+// compare it with the density computed from the model
+func alignModel(log *logrus.Entry, job *model.Job, workDir string) error {
+	modelFile := "model." + job.ModelType
+	err := ioutil.WriteFile(filepath.Join(workDir, modelFile), job.ModelData, 0640)
+	if err != nil {
+		return err
+	}
+
+	m, err := mrc.Decode(job.DensityMap)
+	if err != nil {
+		return err
+	}
+
+	modelMap, err := runPDB2MRC(log, job, workDir, modelFile, m)
+	if err != nil {
+		return err
+	}
+
+	var aligned *mrc.Map
+	if viper.GetString("align_path") != "" {
+		avgMap := filepath.Join(fmt.Sprintf("output_%d", job.ID), fmt.Sprintf("output_%d_avg.mrc", job.ID))
+		aligned, err = denssAlign(log, workDir, avgMap, modelFile)
+		if err != nil {
+			return err
+		}
+		aligned = aligned.Resample(modelMap)
+	} else {
+		aligned, _ = m.Align(modelMap)
+	}
+
+	freq, corr, err := mrc.FSC(modelMap, aligned)
+	if err != nil {
+		return err
+	}
+
+	fit := &model.ModelFit{
+		Correlation: mrc.Correlation(modelMap, aligned),
+		FSC:         &chart.FSC{Frequency: freq, Correlation: corr},
+	}
+
+	job.ModelFitData, err = json.Marshal(fit)
+	if err != nil {
+		return err
+	}
+
+	job.AlignedMap = aligned.Encode()
+	job.ModelMap = modelMap.Encode()
+
+	log.WithFields(logrus.Fields{
+		"correlation": fit.Correlation,
+		"resolution":  fit.FSC.Resolution(),
+	}).Info("Aligned density map to atomic model")
+
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+const (
+	// Size in pixels of the longest side of the density map images
+	mapImageSize = 256
+)
+
+// Parse FSC curve and statistics by step output from DENSS and store them in
+// the job as JSON
+func parseResults(log *logrus.Entry, job *model.Job, workDir string) error {
+	outputPrefix := fmt.Sprintf("output_%d", job.ID)
+	outputDir := filepath.Join(workDir, outputPrefix)
+	fscData := filepath.Join(outputDir, outputPrefix+"_fsc.dat")
+
+	log.WithFields(logrus.Fields{
+		"data": fscData,
+	}).Info("Parsing FSC curve data")
+
+	fh, err := os.Open(fscData)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"data":  fscData,
+		}).Error("Failed to open FSC data file")
+		return err
+	}
+	defer fh.Close()
+
+	fsc, err := chart.ParseFSC(fh)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"data":  fscData,
+		}).Error("Failed to parse FSC data file")
+		return err
+	}
+
+	job.FSCData, err = json.Marshal(fsc)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"outputDir": outputDir,
+	}).Info("Parsing statistics by step data")
+
+	runs, err := chart.ReadStepStats(outputDir)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":     err.Error(),
+			"outputDir": outputDir,
+		}).Error("Failed to read stats by step files")
+		return err
+	}
+
+	for _, r := range runs {
+		r.Trim()
+	}
+
+	job.StatsData, err = json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Parse the density map and store its statistics in the job as JSON. Returns
+// an error if the density map is not a valid MRC/CCP4 file
+func mapStats(log *logrus.Entry, job *model.Job) error {
+	m, err := mrc.Decode(job.DensityMap)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to parse density map")
+		return err
+	}
+
+	sigma := viper.GetFloat64("map_threshold_sigma")
+	stats := m.Stats(m.Summary().Sigma(sigma))
+
+	job.MapStatsData, err = json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"grid":   stats.Grid,
+		"rg":     stats.Rg,
+		"volume": stats.Volume,
+		"dmax":   stats.Dmax,
+	}).Info("Density map statistics")
+
+	return nil
+}
+
+// Render projections of the density map along x, y and z and the central
+// slices perpendicular to each axis as PNG images
+func mapImages(log *logrus.Entry, job *model.Job) error {
+	m, err := mrc.Decode(job.DensityMap)
+	if err != nil {
+		return err
+	}
+
+	job.Images = make(map[string][]byte)
+	for _, name := range []string{"x", "y", "z"} {
+		axis, _ := mrc.ParseAxis(name)
+
+		slice, err := m.Slice(axis, m.Dim(axis)/2)
+		if err != nil {
+			return err
+		}
+
+		for prefix, img := range map[string]image.Image{"projection": m.Projection(axis), "slice": slice} {
+			var buf bytes.Buffer
+			err = png.Encode(&buf, mrc.Scale(img, mapImageSize))
+			if err != nil {
+				return err
+			}
+			job.Images[prefix+"-"+name] = buf.Bytes()
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"images": len(job.Images),
+	}).Info("Rendered density map images")
+
+	return nil
+}
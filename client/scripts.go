@@ -0,0 +1,109 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+)
+
+// Chart scripts embedded in the binary by config key
+var embeddedScripts = map[string]string{
+	"fsc_path":     "denssweb-fsc-chart.py",
+	"summary_path": "denssweb-summary-chart.py",
+}
+
+// Path to the tool set by config key. Chart scripts without a configured
+// path are extracted from the binary into work_dir
+func toolPath(key string) (string, error) {
+	path := viper.GetString(key)
+	name, ok := embeddedScripts[key]
+	if path != "" || !ok {
+		return path, nil
+	}
+
+	return extractScript(name)
+}
+
+// Configured path of the chart script set by key, for logging
+func scriptSource(key string) string {
+	if path := viper.GetString(key); path != "" {
+		return path
+	}
+
+	return "embedded"
+}
+
+// Write the embedded script name to the scripts directory in work_dir. The
+// script is only rewritten when it differs from the embedded copy
+func extractScript(name string) (string, error) {
+	if app.EmbeddedScripts == nil {
+		return "", fmt.Errorf("Script %s is not embedded in this build", name)
+	}
+
+	data, err := fs.ReadFile(app.EmbeddedScripts, name)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(viper.GetString("work_dir"), "scripts")
+	path := filepath.Join(dir, name)
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return path, nil
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file and rename so concurrent jobs never run a
+	// partially written script
+	tmp, err := ioutil.TempFile(dir, name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Chmod(tmp.Name(), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+)
+
+func TestToolPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb-scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	viper.Set("work_dir", dir)
+	defer viper.Set("work_dir", nil)
+
+	if _, err := toolPath("fsc_path"); err == nil {
+		t.Errorf("Missing embedded scripts should fail")
+	}
+
+	app.EmbeddedScripts = fstest.MapFS{
+		"denssweb-fsc-chart.py": {Data: []byte("#!/usr/bin/env python\n")},
+	}
+	defer func() { app.EmbeddedScripts = nil }()
+
+	path, err := toolPath("fsc_path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "scripts", "denssweb-fsc-chart.py") {
+		t.Errorf("Unexpected script path: %s", path)
+	}
+	if err := checkExecutable(path); err != nil {
+		t.Errorf("Extracted script should be executable: %s", err)
+	}
+
+	err = ioutil.WriteFile(path, []byte("stale"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toolPath("fsc_path"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "#!/usr/bin/env python\n" {
+		t.Errorf("Changed script should be rewritten: %s %v", data, err)
+	}
+
+	viper.Set("fsc_path", "/opt/denssweb/fsc.py")
+	defer viper.Set("fsc_path", "")
+	if path, _ := toolPath("fsc_path"); path != "/opt/denssweb/fsc.py" {
+		t.Errorf("Configured path should override embedded script: %s", path)
+	}
+}
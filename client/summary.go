@@ -25,17 +25,20 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
 	"github.com/ubccr/denssweb/model"
 )
 
 // Create summary chart
-func plotSummary(log *logrus.Logger, job *model.Job, workDir string) error {
+func plotSummary(log *logrus.Entry, job *model.Job, workDir string) error {
 	summaryPNG := filepath.Join(workDir, "summary.png")
 	outputPrefix := fmt.Sprintf("output_%d", job.ID)
 
-	log.WithFields(logrus.Fields{
-		"id": job.ID,
-	}).Info("Plotting summary chart")
+	if viper.GetBool("native_charts") {
+		return renderSummary(log, job, filepath.Join(workDir, outputPrefix), workDir)
+	}
+
+	log.Info("Plotting summary chart")
 
 	args := []string{
 		"--input",
@@ -44,13 +47,20 @@ func plotSummary(log *logrus.Logger, job *model.Job, workDir string) error {
 		summaryPNG,
 	}
 
-	cmd := exec.Command(viper.GetString("summary_path"), args...)
+	script, err := toolPath("summary_path")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Failed to find Summary chart script")
+		return err
+	}
+
+	cmd := exec.Command(script, args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("summary_chart", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":   err.Error(),
-			"id":      job.ID,
 			"workDir": workDir,
 			"png":     summaryPNG,
 			"output":  string(out),
@@ -62,7 +72,6 @@ func plotSummary(log *logrus.Logger, job *model.Job, workDir string) error {
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":   err.Error(),
-			"id":      job.ID,
 			"workDir": workDir,
 			"png":     summaryPNG,
 			"output":  string(out),
@@ -71,9 +80,37 @@ func plotSummary(log *logrus.Logger, job *model.Job, workDir string) error {
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":  job.ID,
 		"png": summaryPNG,
 	}).Info("Successfully created Summary chart")
 
 	return nil
 }
+
+// Render summary chart natively in PNG and SVG format
+func renderSummary(log *logrus.Entry, job *model.Job, outputDir, workDir string) error {
+	log.Info("Rendering summary chart")
+
+	runs, err := chart.ReadStepStats(outputDir)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":     err.Error(),
+			"outputDir": outputDir,
+		}).Error("Failed to read stats by step files")
+		return err
+	}
+
+	job.SummaryChart, err = writeChart(chart.SummaryChart(runs), filepath.Join(workDir, "summary"))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":   err.Error(),
+			"workDir": workDir,
+		}).Error("Failed to render Summary chart")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"runs": len(runs),
+	}).Info("Successfully rendered Summary chart")
+
+	return nil
+}
@@ -23,7 +23,6 @@ import (
 	"io/ioutil"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -32,7 +31,7 @@ import (
 )
 
 // Run superdenss.py
-func runSuperdenss(log *logrus.Logger, job *model.Job, workDir string, threads int) error {
+func runSuperdenss(log *logrus.Entry, job *model.Job, workDir string, threads int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(viper.GetInt64("max_seconds"))*time.Second)
 	defer cancel()
 
@@ -41,91 +40,29 @@ func runSuperdenss(log *logrus.Logger, job *model.Job, workDir string, threads i
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"id":    job.ID,
 		}).Error("Failed to write input data file")
 		return err
 	}
 
 	outputPrefix := fmt.Sprintf("output_%d", job.ID)
-	sargs := []string{
-		"-f",
-		inputFile,
-		"-o",
-		outputPrefix,
-		"-j",
-		fmt.Sprintf("%d", threads),
-		"-p",
-	}
-
-	if job.Enantiomer {
-		sargs = append(sargs, "-e")
-	}
-
-	if job.MaxRuns > 0 {
-		sargs = append(sargs, "-n")
-		sargs = append(sargs, fmt.Sprintf("%d", job.MaxRuns))
-	}
-
-	args := []string{
-		"-os",
-		fmt.Sprintf("%.4f", job.Oversampling),
-		"--plot_off",
-		"--quiet",
-		"--mode",
-		strings.ToUpper(job.Mode),
-	}
-
-	if job.Dmax > 0 {
-		args = append(args, "-d")
-		args = append(args, fmt.Sprintf("%.4f", job.Dmax))
-	}
-	if job.VoxelSize > 0 {
-		args = append(args, "-v")
-		args = append(args, fmt.Sprintf("%.4f", job.VoxelSize))
-	}
-	if job.Electrons > 0 {
-		args = append(args, "--ne")
-		args = append(args, fmt.Sprintf("%d", job.Electrons))
-	}
-	if job.NumSamples > 0 {
-		args = append(args, "-n")
-		args = append(args, fmt.Sprintf("%d", job.NumSamples))
-	}
-	if job.Symmetry > 0 {
-		args = append(args, "-ncs")
-		args = append(args, fmt.Sprintf("%d", job.Symmetry))
-		if job.SymmetryAxis > 0 {
-			args = append(args, "-ncs_axis")
-			args = append(args, fmt.Sprintf("%d", job.SymmetryAxis))
-		}
-		if job.SymmetrySteps != "" {
-			args = append(args, "-ncs_steps")
-			args = append(args, job.SymmetrySteps)
-		}
-	}
-
-	sargs = append(sargs, "-i")
-	sargs = append(sargs, strings.Join(args, " "))
+	args := superdenssArgs(log, job, inputFile, outputPrefix, threads)
 
 	log.WithFields(logrus.Fields{
-		"id":      job.ID,
 		"threads": threads,
 	}).Info("Running superdenss")
 
-	cmd := exec.CommandContext(ctx, viper.GetString("superdenss_path"), sargs...)
+	cmd := exec.CommandContext(ctx, viper.GetString("superdenss_path"), args...)
 	cmd.Dir = workDir
-	out, err := cmd.CombinedOutput()
+	out, err := runCommand("superdenss", cmd)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error":  err.Error(),
-			"id":     job.ID,
 			"output": string(out),
 		}).Error("Failed to run superdenss job")
 		return err
 	}
 
 	log.WithFields(logrus.Fields{
-		"id":      job.ID,
 		"threads": threads,
 	}).Info("superdenss completed successfully")
 
@@ -14,6 +14,17 @@ CREATE TABLE `job` (
     `fsc_chart`        mediumblob        NULL,
     `summary_chart`    mediumblob        NULL,
     `raw_data`         longblob          NULL,
+    `fsc_data`         longtext          NULL,
+    `stats_data`       longtext          NULL,
+    `map_stats`        text              NULL,
+    `model_data`       longblob          NULL,
+    `model_type`       char(3)           NULL,
+    `aligned_map`      mediumblob        NULL,
+    `model_map`        mediumblob        NULL,
+    `model_fit`        mediumtext        NULL,
+    `data_fit`         mediumtext        NULL,
+    `webhook_url`      varchar(2048)     NULL,
+    `webhook_secret`   varchar(255)      NULL,
     `dmax`             float             NOT NULL,
     `num_samples`      int(11)           NOT NULL,
     `oversampling`     float             NOT NULL,
@@ -36,7 +47,93 @@ CREATE TABLE `job_status` (
     PRIMARY KEY      (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
+DROP TABLE IF EXISTS `job_image`;
+CREATE TABLE `job_image` (
+    `job_id`         int(11)           NOT NULL,
+    `name`           varchar(64)       NOT NULL,
+    `image`          mediumblob        NOT NULL,
+    PRIMARY KEY      (`job_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `comparison`;
+CREATE TABLE `comparison` (
+    `id`             int(11)           NOT NULL AUTO_INCREMENT,
+    `token`          varchar(255)      NOT NULL,
+    `job_a`          int(11)           NOT NULL,
+    `job_b`          int(11)           NOT NULL,
+    `status_id`      int(11)           NOT NULL,
+    `task`           varchar(255)      NOT NULL,
+    `log_message`    mediumtext        NOT NULL,
+    `correlation`    float             NOT NULL,
+    `fsc_data`       longtext          NULL,
+    `aligned_map`    mediumblob        NULL,
+    `difference_map` mediumblob        NULL,
+    `overlay_chart`  mediumblob        NULL,
+    `submitted`      datetime          NULL,
+    `started`        datetime          NULL,
+    `completed`      datetime          NULL,
+    PRIMARY KEY      (`id`),
+    UNIQUE           (`token`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `email_outbox`;
+CREATE TABLE `email_outbox` (
+    `id`             int(11)           NOT NULL AUTO_INCREMENT,
+    `job_id`         int(11)           NOT NULL,
+    `recipient`      varchar(255)      NOT NULL,
+    `subject`        varchar(255)      NOT NULL,
+    `message`        mediumblob        NOT NULL,
+    `status`         varchar(16)       NOT NULL,
+    `attempts`       int(11)           NOT NULL,
+    `last_error`     text              NOT NULL,
+    `created`        datetime          NULL,
+    `next_attempt`   datetime          NULL,
+    `sent`           datetime          NULL,
+    PRIMARY KEY      (`id`),
+    KEY              (`status`, `next_attempt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `email_digest`;
+CREATE TABLE `email_digest` (
+    `id`             int(11)           NOT NULL AUTO_INCREMENT,
+    `job_id`         int(11)           NOT NULL,
+    `recipient`      varchar(255)      NOT NULL,
+    `event`          varchar(16)       NOT NULL,
+    `created`        datetime          NULL,
+    PRIMARY KEY      (`id`),
+    KEY              (`recipient`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `webhook_delivery`;
+CREATE TABLE `webhook_delivery` (
+    `id`             int(11)           NOT NULL AUTO_INCREMENT,
+    `job_id`         int(11)           NOT NULL,
+    `url`            varchar(2048)     NOT NULL,
+    `event`          varchar(16)       NOT NULL,
+    `payload`        mediumblob        NOT NULL,
+    `signature`      varchar(255)      NOT NULL,
+    `global`         tinyint(1)        NOT NULL,
+    `status`         varchar(16)       NOT NULL,
+    `attempts`       int(11)           NOT NULL,
+    `response_code`  int(11)           NOT NULL,
+    `last_error`     text              NOT NULL,
+    `created`        datetime          NULL,
+    `next_attempt`   datetime          NULL,
+    `delivered`      datetime          NULL,
+    PRIMARY KEY      (`id`),
+    KEY              (`job_id`),
+    KEY              (`status`, `next_attempt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `schema_version`;
+CREATE TABLE `schema_version` (
+    `version`        int(11)           NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO schema_version SET version = 3;
+
 INSERT INTO job_status SET id = 1, status = "Pending";
 INSERT INTO job_status SET id = 2, status = "Running";
 INSERT INTO job_status SET id = 3, status = "Complete";
 INSERT INTO job_status SET id = 4, status = "Error";
+INSERT INTO job_status SET id = 5, status = "Cancelled";
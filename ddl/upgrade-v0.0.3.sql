@@ -0,0 +1,93 @@
+alter table `job` add column `fsc_data` longtext null after `raw_data`;
+alter table `job` add column `stats_data` longtext null after `fsc_data`;
+alter table `job` add column `map_stats` text null after `stats_data`;
+alter table `job` add column `model_data` longblob null after `map_stats`;
+alter table `job` add column `model_type` char(3) null after `model_data`;
+alter table `job` add column `aligned_map` mediumblob null after `model_type`;
+alter table `job` add column `model_map` mediumblob null after `aligned_map`;
+alter table `job` add column `model_fit` mediumtext null after `model_map`;
+alter table `job` add column `data_fit` mediumtext null after `model_fit`;
+alter table `job` add column `webhook_url` varchar(2048) null after `data_fit`;
+alter table `job` add column `webhook_secret` varchar(255) null after `webhook_url`;
+
+create table if not exists `job_image` (
+    `job_id`         int(11)           not null,
+    `name`           varchar(64)       not null,
+    `image`          mediumblob        not null,
+    primary key      (`job_id`, `name`)
+) engine=InnoDB default charset=utf8;
+
+create table if not exists `comparison` (
+    `id`             int(11)           not null auto_increment,
+    `token`          varchar(255)      not null,
+    `job_a`          int(11)           not null,
+    `job_b`          int(11)           not null,
+    `status_id`      int(11)           not null,
+    `task`           varchar(255)      not null,
+    `log_message`    mediumtext        not null,
+    `correlation`    float             not null,
+    `fsc_data`       longtext          null,
+    `aligned_map`    mediumblob        null,
+    `difference_map` mediumblob        null,
+    `overlay_chart`  mediumblob        null,
+    `submitted`      datetime          null,
+    `started`        datetime          null,
+    `completed`      datetime          null,
+    primary key      (`id`),
+    unique           (`token`)
+) engine=InnoDB default charset=utf8;
+
+create table if not exists `email_outbox` (
+    `id`             int(11)           not null auto_increment,
+    `job_id`         int(11)           not null,
+    `recipient`      varchar(255)      not null,
+    `subject`        varchar(255)      not null,
+    `message`        mediumblob        not null,
+    `status`         varchar(16)       not null,
+    `attempts`       int(11)           not null,
+    `last_error`     text              not null,
+    `created`        datetime          null,
+    `next_attempt`   datetime          null,
+    `sent`           datetime          null,
+    primary key      (`id`),
+    key              (`status`, `next_attempt`)
+) engine=InnoDB default charset=utf8;
+
+create table if not exists `email_digest` (
+    `id`             int(11)           not null auto_increment,
+    `job_id`         int(11)           not null,
+    `recipient`      varchar(255)      not null,
+    `event`          varchar(16)       not null,
+    `created`        datetime          null,
+    primary key      (`id`),
+    key              (`recipient`)
+) engine=InnoDB default charset=utf8;
+
+insert ignore into `job_status` set id = 5, status = "Cancelled";
+
+create table if not exists `webhook_delivery` (
+    `id`             int(11)           not null auto_increment,
+    `job_id`         int(11)           not null,
+    `url`            varchar(2048)     not null,
+    `event`          varchar(16)       not null,
+    `payload`        mediumblob        not null,
+    `signature`      varchar(255)      not null,
+    `global`         tinyint(1)        not null,
+    `status`         varchar(16)       not null,
+    `attempts`       int(11)           not null,
+    `response_code`  int(11)           not null,
+    `last_error`     text              not null,
+    `created`        datetime          null,
+    `next_attempt`   datetime          null,
+    `delivered`      datetime          null,
+    primary key      (`id`),
+    key              (`job_id`),
+    key              (`status`, `next_attempt`)
+) engine=InnoDB default charset=utf8;
+
+create table if not exists `schema_version` (
+    `version`        int(11)           not null
+) engine=InnoDB default charset=utf8;
+
+delete from `schema_version`;
+insert into `schema_version` set version = 3;
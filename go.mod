@@ -3,7 +3,6 @@ module github.com/ubccr/denssweb
 go 1.16
 
 require (
-	github.com/BurntSushi/toml v0.3.1 // indirect
 	github.com/asaskevich/govalidator v0.0.0-20180720115003-f9ffefc3facf
 	github.com/dchest/captcha v0.0.0-20170622155422-6a29415a8364
 	github.com/dustin/go-humanize v1.0.0
@@ -14,9 +13,11 @@ require (
 	github.com/jhoonb/archivex v0.0.0-20180718040744-0488e4ce1681
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/mattn/go-sqlite3 v1.10.0
-	github.com/sirupsen/logrus v1.3.0
+	github.com/prometheus/client_golang v1.12.2
+	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/viper v1.3.1
 	github.com/urfave/cli v1.20.0
 	github.com/urfave/negroni v1.0.0
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
 	google.golang.org/appengine v1.6.7 // indirect
 )
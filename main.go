@@ -18,6 +18,8 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"runtime"
 
 	log "github.com/sirupsen/logrus"
@@ -66,21 +68,21 @@ func main() {
 		&cli.BoolFlag{Name: "debug,d", Usage: "Print debug messages"},
 	}
 	capp.Before = func(c *cli.Context) error {
-		if c.GlobalBool("debug") {
-			log.SetLevel(log.InfoLevel)
-		} else {
-			log.SetLevel(log.WarnLevel)
-		}
-
 		conf := c.GlobalString("conf")
 		if len(conf) > 0 {
 			viper.SetConfigFile(conf)
 		}
 
-		err := viper.ReadInConfig()
+		confErr := viper.ReadInConfig()
+
+		err := app.ConfigureLogging(c.GlobalBool("debug"))
 		if err != nil {
+			return err
+		}
+
+		if confErr != nil {
 			log.WithFields(log.Fields{
-				"error": err.Error(),
+				"error": confErr.Error(),
 			}).Warn("Failed to parse config file. Using defaults")
 		}
 
@@ -126,6 +128,52 @@ func main() {
 				}
 				client.RunClient(ctx, c.Int("threads"))
 			},
+		},
+		{
+			Name:  "test-email",
+			Usage: "Send a test email to check the SMTP settings",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "to", Usage: "Email address to send the test email to"},
+			},
+			Action: func(c *cli.Context) {
+				to := c.String("to")
+				if len(to) == 0 {
+					log.Fatal("Please provide an email address with --to")
+				}
+
+				ctx, err := app.NewAppContext()
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+
+				err = ctx.SendTestEmail(to)
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+				fmt.Printf("Test email sent to %s\n", to)
+			},
+		},
+		{
+			Name:  "check",
+			Usage: "Check the config, database, external tools, templates and SMTP server",
+			Action: func(c *cli.Context) {
+				results, _ := app.RunChecks(app.ConfigChecks())
+
+				ctx, err := app.NewCheckContext()
+				if err != nil {
+					results = append(results, &app.CheckResult{Name: "database and templates", Error: err.Error()})
+				} else {
+					more, _ := app.RunChecks(ctx.PreflightChecks())
+					results = append(results, more...)
+				}
+
+				more, _ := app.RunChecks(client.PreflightChecks())
+				results = append(results, more...)
+
+				if app.PrintReport(os.Stdout, results) > 0 {
+					os.Exit(1)
+				}
+			},
 		}}
 
 	capp.RunAndExitOnError()
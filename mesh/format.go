@@ -0,0 +1,166 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Write mesh in Wavefront OBJ format
+func (m *Mesh) WriteOBJ(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# DENSSWeb isosurface\n")
+	fmt.Fprintf(bw, "# %d vertices, %d triangles\n", len(m.Vertices), len(m.Triangles))
+	for _, v := range m.Vertices {
+		fmt.Fprintf(bw, "v %.4f %.4f %.4f\n", v[0], v[1], v[2])
+	}
+	for _, n := range m.Normals {
+		fmt.Fprintf(bw, "vn %.4f %.4f %.4f\n", n[0], n[1], n[2])
+	}
+	for _, t := range m.Triangles {
+		// OBJ indices start at 1
+		fmt.Fprintf(bw, "f %d//%d %d//%d %d//%d\n", t[0]+1, t[0]+1, t[1]+1, t[1]+1, t[2]+1, t[2]+1)
+	}
+
+	return bw.Flush()
+}
+
+// Write mesh in binary STL format
+func (m *Mesh) WriteSTL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, 80)
+	copy(header, "DENSSWeb isosurface")
+	bw.Write(header)
+
+	binary.Write(bw, binary.LittleEndian, uint32(len(m.Triangles)))
+	for i, t := range m.Triangles {
+		binary.Write(bw, binary.LittleEndian, m.FaceNormal(i))
+		for _, v := range t {
+			binary.Write(bw, binary.LittleEndian, m.Vertices[v])
+		}
+		// Attribute byte count
+		binary.Write(bw, binary.LittleEndian, uint16(0))
+	}
+
+	return bw.Flush()
+}
+
+const (
+	glbMagic     = 0x46546C67 // glTF
+	glbVersion   = 2
+	glbChunkJSON = 0x4E4F534A // JSON
+	glbChunkBIN  = 0x004E4942 // BIN
+
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfTriangles    = 4
+)
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+// Pad buffer to a multiple of 4 bytes
+func pad4(buf *bytes.Buffer, b byte) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(b)
+	}
+}
+
+// Write mesh in glTF 2.0 binary (GLB) format
+func (m *Mesh) WriteGLB(w io.Writer) error {
+	var bin bytes.Buffer
+	binary.Write(&bin, binary.LittleEndian, m.Vertices)
+	posLen := bin.Len()
+	binary.Write(&bin, binary.LittleEndian, m.Normals)
+	normLen := bin.Len() - posLen
+	binary.Write(&bin, binary.LittleEndian, m.Triangles)
+	idxLen := bin.Len() - posLen - normLen
+	pad4(&bin, 0)
+
+	min, max := m.Bounds()
+
+	doc := map[string]interface{}{
+		"asset":  map[string]string{"version": "2.0", "generator": "DENSSWeb"},
+		"scene":  0,
+		"scenes": []interface{}{map[string]interface{}{"nodes": []int{0}}},
+		"nodes":  []interface{}{map[string]interface{}{"mesh": 0}},
+		"meshes": []interface{}{map[string]interface{}{
+			"primitives": []interface{}{map[string]interface{}{
+				"attributes": map[string]int{"POSITION": 0, "NORMAL": 1},
+				"indices":    2,
+				"mode":       gltfTriangles,
+			}},
+		}},
+		"accessors": []gltfAccessor{
+			{BufferView: 0, ComponentType: gltfFloat, Count: len(m.Vertices), Type: "VEC3", Min: min[:], Max: max[:]},
+			{BufferView: 1, ComponentType: gltfFloat, Count: len(m.Normals), Type: "VEC3"},
+			{BufferView: 2, ComponentType: gltfUnsignedInt, Count: len(m.Triangles) * 3, Type: "SCALAR"},
+		},
+		"bufferViews": []gltfBufferView{
+			{Buffer: 0, ByteOffset: 0, ByteLength: posLen, Target: gltfArrayBuffer},
+			{Buffer: 0, ByteOffset: posLen, ByteLength: normLen, Target: gltfArrayBuffer},
+			{Buffer: 0, ByteOffset: posLen + normLen, ByteLength: idxLen, Target: gltfElementArray},
+		},
+		"buffers": []interface{}{map[string]int{"byteLength": bin.Len()}},
+	}
+
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var jsonChunk bytes.Buffer
+	jsonChunk.Write(js)
+	pad4(&jsonChunk, ' ')
+
+	total := 12 + 8 + jsonChunk.Len() + 8 + bin.Len()
+	if total > math.MaxUint32 {
+		return fmt.Errorf("Mesh too large for glTF binary format")
+	}
+
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.LittleEndian, []uint32{glbMagic, glbVersion, uint32(total)})
+	binary.Write(bw, binary.LittleEndian, []uint32{uint32(jsonChunk.Len()), glbChunkJSON})
+	bw.Write(jsonChunk.Bytes())
+	binary.Write(bw, binary.LittleEndian, []uint32{uint32(bin.Len()), glbChunkBIN})
+	bw.Write(bin.Bytes())
+
+	return bw.Flush()
+}
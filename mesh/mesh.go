@@ -0,0 +1,365 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mesh generates isosurface triangle meshes from density maps using
+// marching cubes and writes them in OBJ, STL and glTF binary formats.
+package mesh
+
+import (
+	"math"
+
+	"github.com/ubccr/denssweb/mrc"
+)
+
+// A triangle mesh. Normals are per vertex and point away from the density.
+type Mesh struct {
+	Vertices  [][3]float32
+	Normals   [][3]float32
+	Triangles [][3]uint32
+}
+
+var (
+	// Cube corner offsets
+	corners = [8][3]int{
+		{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0},
+		{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1},
+	}
+
+	// Cube edges as pairs of corners
+	edges = [12][2]int{
+		{0, 1}, {1, 2}, {2, 3}, {3, 0},
+		{4, 5}, {5, 6}, {6, 7}, {7, 4},
+		{0, 4}, {1, 5}, {2, 6}, {3, 7},
+	}
+
+	// Cube faces as cycles of corners
+	faces = [6][4]int{
+		{0, 1, 2, 3}, {4, 5, 6, 7},
+		{0, 1, 5, 4}, {3, 2, 6, 7},
+		{0, 3, 7, 4}, {1, 2, 6, 5},
+	}
+
+	// Triangles (as triples of cube edges) for each of the 256 cube
+	// configurations. Built at init from the face crossings
+	triTable [256][]int
+)
+
+func init() {
+	buildTriTable()
+}
+
+func edgeIndex(a, b int) int {
+	for i, e := range edges {
+		if (e[0] == a && e[1] == b) || (e[0] == b && e[1] == a) {
+			return i
+		}
+	}
+	panic("invalid cube edge")
+}
+
+// Orient every face counter-clockwise when viewed from outside the cube
+func orientedFaces() [6][4]int {
+	var oriented [6][4]int
+	for f, face := range faces {
+		var p [4][3]float64
+		var center [3]float64
+		for i, c := range face {
+			for a := 0; a < 3; a++ {
+				p[i][a] = float64(corners[c][a])
+				center[a] += p[i][a] / 4
+			}
+		}
+		u := sub(p[1], p[0])
+		v := sub(p[2], p[1])
+		n := cross(u, v)
+		out := sub(center, [3]float64{0.5, 0.5, 0.5})
+		if dot(n, out) < 0 {
+			oriented[f] = [4]int{face[3], face[2], face[1], face[0]}
+		} else {
+			oriented[f] = face
+		}
+	}
+	return oriented
+}
+
+// Build the marching cubes case table. On each face of the cube the edges
+// crossing the surface are joined in pairs, keeping the inside corners of
+// ambiguous faces connected. Since the choice only depends on the values on
+// the face, neighboring cubes always agree and the surface is closed. The
+// joined segments form loops around the cube which are split into triangles.
+func buildTriTable() {
+	oriented := orientedFaces()
+
+	for config := 0; config < 256; config++ {
+		inside := func(c int) bool {
+			return config&(1<<uint(c)) != 0
+		}
+
+		// next[e] is the edge following e in the surface loop
+		next := make(map[int]int)
+		for _, face := range oriented {
+			type crossing struct {
+				edge  int
+				entry bool
+			}
+			crossings := make([]crossing, 0, 4)
+			for i := 0; i < 4; i++ {
+				a, b := face[i], face[(i+1)%4]
+				if inside(a) != inside(b) {
+					crossings = append(crossings, crossing{edgeIndex(a, b), inside(a)})
+				}
+			}
+
+			for i, c := range crossings {
+				if !c.entry {
+					continue
+				}
+				for j := 1; j < len(crossings); j++ {
+					o := crossings[(i+j)%len(crossings)]
+					if !o.entry {
+						next[c.edge] = o.edge
+						break
+					}
+				}
+			}
+		}
+
+		tris := make([]int, 0)
+		seen := make(map[int]bool)
+		for e := 0; e < 12; e++ {
+			if _, ok := next[e]; !ok || seen[e] {
+				continue
+			}
+
+			loop := []int{e}
+			seen[e] = true
+			for cur := next[e]; cur != e; cur = next[cur] {
+				loop = append(loop, cur)
+				seen[cur] = true
+			}
+
+			tris = append(tris, triangulate(loop)...)
+		}
+
+		triTable[config] = tris
+	}
+}
+
+// Whether cube edges a and b lie on the same face
+func sameFace(a, b int) bool {
+	for _, face := range faces {
+		on := 0
+		for _, c := range face {
+			for _, e := range []int{a, b} {
+				if edges[e][0] == c || edges[e][1] == c {
+					on++
+				}
+			}
+		}
+		if on == 4 {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a surface loop into triangles. Diagonals joining two edges on the same
+// face are avoided since they would be shared with the neighboring cube.
+func triangulate(loop []int) []int {
+	n := len(loop)
+	if n == 3 {
+		return []int{loop[0], loop[2], loop[1]}
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			if (i == 0 && j == n-1) || sameFace(loop[i], loop[j]) {
+				continue
+			}
+			a := append([]int{}, loop[i:j+1]...)
+			b := append(append([]int{}, loop[j:]...), loop[:i+1]...)
+			return append(triangulate(a), triangulate(b)...)
+		}
+	}
+
+	panic("marching cubes loop cannot be triangulated")
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func normalize(a [3]float64) [3]float64 {
+	l := math.Sqrt(dot(a, a))
+	if l == 0 {
+		return a
+	}
+	return [3]float64{a[0] / l, a[1] / l, a[2] / l}
+}
+
+// Generate the isosurface of the map at the given density level using
+// marching cubes. Coordinates are in Å.
+func Contour(m *mrc.Map, level float64) *Mesh {
+	mesh := &Mesh{}
+	voxel := m.VoxelSize()
+
+	value := func(i, j, k int) float64 {
+		return float64(m.At(i, j, k))
+	}
+
+	// Density gradient by central differences, clamped at the grid boundary
+	gradient := func(i, j, k int) [3]float64 {
+		clamp := func(v, n int) int {
+			if v < 0 {
+				return 0
+			}
+			if v >= n {
+				return n - 1
+			}
+			return v
+		}
+		return [3]float64{
+			(value(clamp(i+1, m.NX), j, k) - value(clamp(i-1, m.NX), j, k)) / (2 * voxel[0]),
+			(value(i, clamp(j+1, m.NY), k) - value(i, clamp(j-1, m.NY), k)) / (2 * voxel[1]),
+			(value(i, j, clamp(k+1, m.NZ)) - value(i, j, clamp(k-1, m.NZ))) / (2 * voxel[2]),
+		}
+	}
+
+	// Vertices are shared between neighboring cubes. Key is the grid index
+	// of the edge's first corner and the axis of the edge
+	shared := make(map[int]uint32)
+
+	vertex := func(x, y, z int, e int) uint32 {
+		a, b := corners[edges[e][0]], corners[edges[e][1]]
+		i0, j0, k0 := x+a[0], y+a[1], z+a[2]
+		i1, j1, k1 := x+b[0], y+b[1], z+b[2]
+		if i1 < i0 || j1 < j0 || k1 < k0 {
+			i0, j0, k0, i1, j1, k1 = i1, j1, k1, i0, j0, k0
+		}
+
+		axis := 0
+		if j1 != j0 {
+			axis = 1
+		} else if k1 != k0 {
+			axis = 2
+		}
+		key := m.Index(i0, j0, k0)*3 + axis
+		if idx, ok := shared[key]; ok {
+			return idx
+		}
+
+		v0, v1 := value(i0, j0, k0), value(i1, j1, k1)
+		t := 0.5
+		if v1 != v0 {
+			t = (level - v0) / (v1 - v0)
+		}
+
+		p0 := [3]float64{float64(i0), float64(j0), float64(k0)}
+		p1 := [3]float64{float64(i1), float64(j1), float64(k1)}
+		g0, g1 := gradient(i0, j0, k0), gradient(i1, j1, k1)
+
+		var pos, norm [3]float32
+		for a := 0; a < 3; a++ {
+			p := p0[a] + t*(p1[a]-p0[a])
+			pos[a] = float32(m.Origin[a] + (float64(m.Start[a])+p)*voxel[a])
+		}
+
+		// Normals point down the density gradient, away from the density
+		g := normalize([3]float64{
+			-(g0[0] + t*(g1[0]-g0[0])),
+			-(g0[1] + t*(g1[1]-g0[1])),
+			-(g0[2] + t*(g1[2]-g0[2])),
+		})
+		for a := 0; a < 3; a++ {
+			norm[a] = float32(g[a])
+		}
+
+		idx := uint32(len(mesh.Vertices))
+		mesh.Vertices = append(mesh.Vertices, pos)
+		mesh.Normals = append(mesh.Normals, norm)
+		shared[key] = idx
+		return idx
+	}
+
+	for z := 0; z < m.NZ-1; z++ {
+		for y := 0; y < m.NY-1; y++ {
+			for x := 0; x < m.NX-1; x++ {
+				config := 0
+				for c, off := range corners {
+					if value(x+off[0], y+off[1], z+off[2]) > level {
+						config |= 1 << uint(c)
+					}
+				}
+
+				tris := triTable[config]
+				for t := 0; t+2 < len(tris); t += 3 {
+					a := vertex(x, y, z, tris[t])
+					b := vertex(x, y, z, tris[t+1])
+					c := vertex(x, y, z, tris[t+2])
+					if a == b || b == c || a == c {
+						continue
+					}
+					mesh.Triangles = append(mesh.Triangles, [3]uint32{a, b, c})
+				}
+			}
+		}
+	}
+
+	return mesh
+}
+
+// Face normal of triangle t
+func (m *Mesh) FaceNormal(t int) [3]float32 {
+	tri := m.Triangles[t]
+	var p [3][3]float64
+	for i := 0; i < 3; i++ {
+		for a := 0; a < 3; a++ {
+			p[i][a] = float64(m.Vertices[tri[i]][a])
+		}
+	}
+
+	n := normalize(cross(sub(p[1], p[0]), sub(p[2], p[0])))
+	return [3]float32{float32(n[0]), float32(n[1]), float32(n[2])}
+}
+
+// Bounding box of the mesh vertices
+func (m *Mesh) Bounds() ([3]float32, [3]float32) {
+	var min, max [3]float32
+	for i, v := range m.Vertices {
+		for a := 0; a < 3; a++ {
+			if i == 0 || v[a] < min[a] {
+				min[a] = v[a]
+			}
+			if i == 0 || v[a] > max[a] {
+				max[a] = v[a]
+			}
+		}
+	}
+	return min, max
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mesh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ubccr/denssweb/mrc"
+)
+
+// Gaussian blob centered in an n^3 grid
+func blob(n int, voxel float64) *mrc.Map {
+	m := mrc.New(n, n, n, voxel)
+	c := float64(n-1) / 2
+	for k := 0; k < n; k++ {
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				d2 := (float64(i)-c)*(float64(i)-c) + (float64(j)-c)*(float64(j)-c) + (float64(k)-c)*(float64(k)-c)
+				m.Data[m.Index(i, j, k)] = float32(math.Exp(-d2 / 50))
+			}
+		}
+	}
+	return m
+}
+
+// Every directed edge must be used exactly once and its reverse exactly once
+// for a closed, consistently oriented surface
+func checkClosed(t *testing.T, m *Mesh) {
+	directed := make(map[[2]uint32]int)
+	for _, tri := range m.Triangles {
+		for i := 0; i < 3; i++ {
+			directed[[2]uint32{tri[i], tri[(i+1)%3]}]++
+		}
+	}
+
+	for e, n := range directed {
+		if n != 1 {
+			t.Fatalf("Edge %v used %d times", e, n)
+		}
+		if directed[[2]uint32{e[1], e[0]}] != 1 {
+			t.Fatalf("Edge %v has no matching reverse edge", e)
+		}
+	}
+}
+
+func TestContour(t *testing.T) {
+	m := blob(24, 2.0)
+	level := math.Exp(-1.0)
+	mesh := Contour(m, level)
+
+	if len(mesh.Triangles) == 0 {
+		t.Fatal("No triangles generated")
+	}
+
+	checkClosed(t, mesh)
+
+	// Sphere of radius sqrt(50) voxels. Euler characteristic should be 2
+	edges := len(mesh.Triangles) * 3 / 2
+	if chi := len(mesh.Vertices) - edges + len(mesh.Triangles); chi != 2 {
+		t.Errorf("Incorrect Euler characteristic: got %d should be 2", chi)
+	}
+
+	r := math.Sqrt(50) * 2.0
+	c := float32(23.0 / 2 * 2.0)
+	area := 0.0
+	for i, tri := range mesh.Triangles {
+		n := mesh.FaceNormal(i)
+		v := mesh.Vertices[tri[0]]
+		out := float64(n[0]*(v[0]-c) + n[1]*(v[1]-c) + n[2]*(v[2]-c))
+		if out <= 0 {
+			t.Fatalf("Triangle %d faces inwards", i)
+		}
+
+		var p [3][3]float64
+		for a := 0; a < 3; a++ {
+			for b := 0; b < 3; b++ {
+				p[a][b] = float64(mesh.Vertices[tri[a]][b])
+			}
+		}
+		x := cross(sub(p[1], p[0]), sub(p[2], p[0]))
+		area += math.Sqrt(dot(x, x)) / 2
+	}
+
+	expected := 4 * math.Pi * r * r
+	if math.Abs(area-expected)/expected > 0.05 {
+		t.Errorf("Incorrect surface area: got %.1f should be %.1f", area, expected)
+	}
+
+	for i, v := range mesh.Vertices {
+		n := mesh.Normals[i]
+		if n[0]*(v[0]-c)+n[1]*(v[1]-c)+n[2]*(v[2]-c) <= 0 {
+			t.Fatalf("Vertex normal %d points inwards", i)
+		}
+	}
+}
+
+func TestContourAmbiguous(t *testing.T) {
+	// Random noise exercises every cube configuration. Zero the border so the
+	// surface is closed
+	rnd := rand.New(rand.NewSource(42))
+	n := 16
+	m := mrc.New(n, n, n, 1.0)
+	for k := 1; k < n-1; k++ {
+		for j := 1; j < n-1; j++ {
+			for i := 1; i < n-1; i++ {
+				m.Data[m.Index(i, j, k)] = rnd.Float32()
+			}
+		}
+	}
+
+	checkClosed(t, Contour(m, 0.5))
+}
+
+func TestFormats(t *testing.T) {
+	mesh := Contour(blob(12, 1.5), 0.5)
+
+	var buf bytes.Buffer
+	err := mesh.WriteOBJ(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	faces := strings.Count(buf.String(), "\nf ")
+	if faces != len(mesh.Triangles) {
+		t.Errorf("Incorrect number of OBJ faces: got %d should be %d", faces, len(mesh.Triangles))
+	}
+
+	buf.Reset()
+	err = mesh.WriteSTL(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 84+50*len(mesh.Triangles) {
+		t.Errorf("Incorrect STL size: got %d should be %d", buf.Len(), 84+50*len(mesh.Triangles))
+	}
+	if count := binary.LittleEndian.Uint32(buf.Bytes()[80:]); int(count) != len(mesh.Triangles) {
+		t.Errorf("Incorrect STL triangle count: got %d should be %d", count, len(mesh.Triangles))
+	}
+
+	buf.Reset()
+	err = mesh.WriteGLB(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if binary.LittleEndian.Uint32(data) != glbMagic {
+		t.Errorf("Invalid GLB magic")
+	}
+	if int(binary.LittleEndian.Uint32(data[8:])) != len(data) {
+		t.Errorf("Incorrect GLB length: got %d should be %d", binary.LittleEndian.Uint32(data[8:]), len(data))
+	}
+	if len(data)%4 != 0 {
+		t.Errorf("GLB length should be 4 byte aligned: got %d", len(data))
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
+)
+
+var (
+	ErrCompareIncomplete = errors.New("Only completed jobs can be compared")
+	ErrCompareSelf       = errors.New("A job can not be compared with itself")
+)
+
+// A comparison of the density maps of two completed jobs. The map of job B is
+// aligned to the map of job A
+type Comparison struct {
+	// Unique ID for the comparison
+	ID int64 `db:"id" json:"id"`
+
+	// Unique comparison token
+	Token string `db:"token" json:"-"`
+
+	// Reference job
+	JobA      int64  `db:"job_a" json:"-"`
+	JobAToken string `db:"job_a_token" json:"-"`
+	JobAName  string `db:"job_a_name" json:"job_a_name"`
+
+	// Job aligned to the reference
+	JobB      int64  `db:"job_b" json:"-"`
+	JobBToken string `db:"job_b_token" json:"-"`
+	JobBName  string `db:"job_b_name" json:"job_b_name"`
+
+	// Comparison Status ID
+	StatusID int64 `db:"status_id" json:"-"`
+
+	// Comparison Status string
+	Status string `db:"status" json:"status"`
+
+	// Task Name
+	Task string `db:"task" json:"task"`
+
+	// Log message for task
+	LogMessage string `db:"log_message" json:"log_message"`
+
+	// Correlation of the aligned maps
+	Correlation float64 `db:"correlation" json:"correlation"`
+
+	// Fourier Shell Correlation (FSC) curve between the aligned maps in JSON
+	// format
+	FSCData []byte `db:"fsc_data" json:"-"`
+
+	// FSC curve between the aligned maps. Only used in json and templates
+	FSC *chart.FSC `db:"-" json:"fsc,omitempty"`
+
+	// Map of job B aligned to job A in CCP4 format
+	AlignedMap []byte `db:"aligned_map" json:"-"`
+
+	// Difference map (A - B) in CCP4 format
+	DifferenceMap []byte `db:"difference_map" json:"-"`
+
+	// Chart of the FSC between the maps overlaid with the FSC of each job
+	OverlayChart []byte `db:"overlay_chart" json:"-"`
+
+	// Date comparison was submitted
+	Submitted *time.Time `db:"submitted" json:"submitted"`
+
+	// Date comparison was started
+	Started *time.Time `db:"started" json:"started"`
+
+	// Date comparison was completed
+	Completed *time.Time `db:"completed" json:"completed"`
+}
+
+func (c *Comparison) URL() string {
+	return fmt.Sprintf("%s/comparison/%s", viper.GetString("base_url"), c.Token)
+}
+
+func (c *Comparison) JobAURL() string {
+	return fmt.Sprintf("%s/job/%s", viper.GetString("base_url"), c.JobAToken)
+}
+
+func (c *Comparison) JobBURL() string {
+	return fmt.Sprintf("%s/job/%s", viper.GetString("base_url"), c.JobBToken)
+}
+
+const comparisonColumns = `
+            c.id,
+            c.token,
+            c.job_a,
+            a.token as job_a_token,
+            a.name as job_a_name,
+            c.job_b,
+            b.token as job_b_token,
+            b.name as job_b_name,
+            c.status_id,
+            s.status,
+            c.task,
+            c.log_message,
+            c.correlation,
+            c.submitted,
+            c.started,
+            c.completed`
+
+const comparisonJoins = `
+        from comparison as c
+        join job as a on a.id = c.job_a
+        join job as b on b.id = c.job_b
+        join job_status s on s.id = c.status_id`
+
+// Queue a comparison of the density maps of jobs a and b. If the jobs have
+// already been compared the existing comparison is returned
+func QueueComparison(db *sqlx.DB, a, b *Job) (*Comparison, error) {
+	if a.StatusID != StatusComplete || b.StatusID != StatusComplete {
+		return nil, ErrCompareIncomplete
+	}
+	if a.ID == b.ID {
+		return nil, ErrCompareSelf
+	}
+
+	comp := Comparison{}
+	err := db.Get(&comp, `select `+comparisonColumns+comparisonJoins+`
+        where c.job_a = ? and c.job_b = ? and c.status_id != ?`, a.ID, b.ID, StatusError)
+	if err == nil {
+		return &comp, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	now := time.Now()
+	comp = Comparison{
+		Token:     randToken(),
+		JobA:      a.ID,
+		JobAToken: a.Token,
+		JobAName:  a.Name,
+		JobB:      b.ID,
+		JobBToken: b.Token,
+		JobBName:  b.Name,
+		StatusID:  StatusPending,
+		Status:    "Pending",
+		Task:      "Not started",
+		Submitted: &now,
+	}
+
+	res, err := tx.NamedExec(`
+        insert into comparison (
+            token,
+            job_a,
+            job_b,
+            status_id,
+            task,
+            log_message,
+            correlation,
+            submitted
+        ) values (
+            :token,
+            :job_a,
+            :job_b,
+            :status_id,
+            :task,
+            :log_message,
+            :correlation,
+            :submitted
+        )`, &comp)
+	if err != nil {
+		return nil, err
+	}
+
+	comp.ID, err = res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &comp, nil
+}
+
+// Fetch comparison by token. No binary data is included
+func FetchComparison(db *sqlx.DB, token string) (*Comparison, error) {
+	comp := Comparison{}
+	err := db.Get(&comp, `select `+comparisonColumns+`,
+            c.fsc_data`+comparisonJoins+`
+        where c.token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(comp.FSCData) > 0 {
+		comp.FSC = &chart.FSC{}
+		err = json.Unmarshal(comp.FSCData, comp.FSC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &comp, nil
+}
+
+// Fetch comparison by token including the aligned and difference maps and
+// the overlay chart
+func FetchComparisonFiles(db *sqlx.DB, token string) (*Comparison, error) {
+	comp := Comparison{}
+	err := db.Get(&comp, `select `+comparisonColumns+`,
+            c.fsc_data,
+            c.aligned_map,
+            c.difference_map,
+            c.overlay_chart`+comparisonJoins+`
+        where c.token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comp, nil
+}
+
+// Fetch the next pending comparison and mark it as running
+func FetchNextPendingComparison(db *sqlx.DB) (*Comparison, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	query := `select ` + comparisonColumns + comparisonJoins + `
+        where c.status_id = ?
+        order by c.submitted asc
+        limit 1`
+
+	// sqlite3 does not support row locking
+	if db.DriverName() == "mysql" {
+		query += ` for update`
+	}
+
+	comp := Comparison{}
+	err = tx.Get(&comp, query, StatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	comp.StatusID = StatusRunning
+	comp.Status = "Running"
+	now := time.Now()
+	comp.Started = &now
+
+	_, err = tx.NamedExec(`
+        update comparison set status_id = :status_id, started = :started
+        where id = :id`, &comp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comp, nil
+}
+
+// Update the current task of a running comparison
+func LogComparisonMessage(db *sqlx.DB, comp *Comparison, task, message string) error {
+	comp.Task = task
+	comp.LogMessage = message
+
+	_, err := db.NamedExec(`
+        update comparison set
+            task = :task,
+            log_message = :log_message
+        where id = :id`, comp)
+
+	return err
+}
+
+// Save the results of a comparison
+func CompleteComparison(db *sqlx.DB, comp *Comparison, statusID int) error {
+	comp.StatusID = int64(statusID)
+	now := time.Now()
+	comp.Completed = &now
+
+	_, err := db.NamedExec(`
+        update comparison set
+            status_id = :status_id,
+            correlation = :correlation,
+            fsc_data = :fsc_data,
+            aligned_map = :aligned_map,
+            difference_map = :difference_map,
+            overlay_chart = :overlay_chart,
+            completed = :completed
+        where id = :id`, comp)
+
+	return err
+}
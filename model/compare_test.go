@@ -0,0 +1,137 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+func completedJob(t *testing.T, db *sqlx.DB, name string) *Job {
+	job := &Job{Name: name, InputData: []byte("test"), FileType: "dat"}
+	err := QueueJob(db, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job.DensityMap = mrc.New(4, 4, 4, 1.0).Encode()
+	err = CompleteJob(db, job, StatusComplete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return job
+}
+
+func TestComparison(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := completedJob(t, db, "a")
+	b := completedJob(t, db, "b")
+
+	_, err = QueueComparison(db, a, a)
+	if err != ErrCompareSelf {
+		t.Errorf("Job should not be compared with itself")
+	}
+
+	comp, err := QueueComparison(db, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := QueueComparison(db, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Token != comp.Token {
+		t.Errorf("Existing comparison should be returned: got %s should be %s", again.Token, comp.Token)
+	}
+
+	next, err := FetchNextPendingComparison(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != comp.ID || next.JobAToken != a.Token || next.JobBName != "b" {
+		t.Errorf("Incorrect pending comparison: got %+v", next)
+	}
+
+	_, err = FetchNextPendingComparison(db)
+	if err != sql.ErrNoRows {
+		t.Errorf("No comparisons should be pending: got %v", err)
+	}
+
+	next.Correlation = 0.9
+	next.FSCData = []byte(`{"frequency":[0,0.1],"correlation":[1,0.4]}`)
+	next.DifferenceMap = []byte("diff")
+	err = CompleteComparison(db, next, StatusComplete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comp, err = FetchComparison(db, comp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Status != "Complete" || comp.Correlation != 0.9 || comp.FSC == nil || len(comp.FSC.Frequency) != 2 {
+		t.Errorf("Incorrect completed comparison: got %+v", comp)
+	}
+
+	comp, err = FetchComparisonFiles(db, comp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(comp.DifferenceMap) != "diff" {
+		t.Errorf("Incorrect difference map: got %s", comp.DifferenceMap)
+	}
+}
+
+func TestQueueComparisonError(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := completedJob(t, db, "a")
+	b := completedJob(t, db, "b")
+
+	// Break the lookup of existing comparisons
+	_, err = db.Exec(`drop table job_status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = QueueComparison(db, a, b)
+	if err == nil {
+		t.Errorf("Database errors should be returned")
+	}
+
+	var n int
+	err = db.Get(&n, `select count(*) from comparison`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Comparison should not be queued after a database error: got %d", n)
+	}
+}
@@ -18,24 +18,85 @@
 package model
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
+	// Version of the database schema. Bump with each ddl/upgrade-*.sql
+	SchemaVersion = 3
+
 	JobSchema = `
 		create table if not exists job 
 		(id integer primary key, status_id integer, input_data blob, dmax real,
          density_map blob, fsc_chart blob, summary_chart bob, raw_data blob, oversampling real, token string,
          electrons integer, max_steps integer, max_runs integer, params text, name string, num_samples integer,
          task string, percent_complete integer, log_message string, email string, file_type string,
-         voxel_size real, submitted datetime, started datetime, completed datetime)
+         voxel_size real, submitted datetime, started datetime, completed datetime, fsc_data text,
+         stats_data text, map_stats text, model_data blob, model_type string, aligned_map blob,
+         model_map blob, model_fit text, data_fit text, webhook_url string, webhook_secret string)
+	`
+	JobImageSchema = `
+		create table if not exists job_image
+		(job_id integer, name string, image blob, primary key (job_id, name))
+	`
+	ComparisonSchema = `
+		create table if not exists comparison
+		(id integer primary key, token string, job_a integer, job_b integer, status_id integer,
+         task string, log_message string, correlation real, fsc_data text, aligned_map blob,
+         difference_map blob, overlay_chart blob, submitted datetime, started datetime,
+         completed datetime)
+	`
+	OutboxSchema = `
+		create table if not exists email_outbox
+		(id integer primary key, job_id integer, recipient string, subject string, message blob,
+         status string, attempts integer, last_error string, created datetime,
+         next_attempt datetime, sent datetime)
+	`
+	DigestSchema = `
+		create table if not exists email_digest
+		(id integer primary key, job_id integer, recipient string, event string, created datetime)
+	`
+	WebhookSchema = `
+		create table if not exists webhook_delivery
+		(id integer primary key, job_id integer, url string, event string, payload blob,
+         signature string, global boolean, status string, attempts integer, response_code integer,
+         last_error string, created datetime, next_attempt datetime, delivered datetime)
 	`
 	JobStatusSchema = `
 		create table if not exists job_status
 		(id integer primary key, status string)
 	`
+	SchemaVersionSchema = `
+		create table if not exists schema_version
+		(version integer)
+	`
+)
+
+var (
+	// Columns added to the job table since the initial release
+	jobColumns = [][]string{
+		{"fsc_data", "text"},
+		{"stats_data", "text"},
+		{"map_stats", "text"},
+		{"model_data", "blob"},
+		{"model_type", "string"},
+		{"aligned_map", "blob"},
+		{"model_map", "blob"},
+		{"model_fit", "text"},
+		{"data_fit", "text"},
+		{"webhook_url", "string"},
+		{"webhook_secret", "string"},
+	}
+
+	// Tables of the current schema
+	schemaTables = []string{"job", "job_status", "job_image", "comparison",
+		"email_outbox", "email_digest", "webhook_delivery", "schema_version"}
 )
 
 func NewDB(driver, dsn string) (*sqlx.DB, error) {
@@ -59,6 +120,35 @@ func NewDB(driver, dsn string) (*sqlx.DB, error) {
 	return db, nil
 }
 
+// Open the database without creating or upgrading the schema. A missing
+// sqlite3 database file is an error instead of being created
+func OpenDB(driver, dsn string) (*sqlx.DB, error) {
+	if driver == "sqlite3" {
+		path := strings.TrimPrefix(dsn, "file:")
+		if i := strings.Index(path, "?"); i >= 0 {
+			path = path[:i]
+		}
+		if path != ":memory:" {
+			_, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	db, err := sqlx.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
 func initDB(db *sqlx.DB) error {
 	// Create tables if not exist
 	_, err := db.Exec(JobSchema)
@@ -71,6 +161,42 @@ func initDB(db *sqlx.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(JobImageSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ComparisonSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(OutboxSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(DigestSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(WebhookSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(SchemaVersionSchema)
+	if err != nil {
+		return err
+	}
+
+	// Add columns missing from databases created by older versions
+	err = addColumns(db, "job", jobColumns)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`replace into job_status (id,status) values (?,?)`, StatusPending, "Pending")
 	if err != nil {
 		return err
@@ -91,5 +217,105 @@ func initDB(db *sqlx.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(`replace into job_status (id,status) values (?,?)`, StatusCancelled, "Cancelled")
+	if err != nil {
+		return err
+	}
+
+	// Tables were created or upgraded above so the schema is current
+	_, err = db.Exec(`delete from schema_version`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`insert into schema_version (version) values (?)`, SchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Add any columns to an existing sqlite3 table that do not yet exist
+func addColumns(db *sqlx.DB, table string, columns [][]string) error {
+	rows, err := db.Queryx(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool)
+	for rows.Next() {
+		col := make(map[string]interface{})
+		err = rows.MapScan(col)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		exists[fmt.Sprintf("%s", col["name"])] = true
+	}
+	rows.Close()
+
+	for _, c := range columns {
+		if exists[c[0]] {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("alter table %s add column %s %s", table, c[0], c[1]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fetch the version of the database schema
+func FetchSchemaVersion(db *sqlx.DB) (int, error) {
+	var version int
+	err := db.Get(&version, `select version from schema_version`)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Check the database has all tables of the current schema, the job columns
+// added since the initial release and the current schema version
+func CheckSchema(db *sqlx.DB) error {
+	for _, table := range schemaTables {
+		rows, err := db.Queryx(fmt.Sprintf("select * from %s limit 0", table))
+		if err != nil {
+			return fmt.Errorf("Missing table %s. Apply the upgrade scripts in ddl/: %s", table, err)
+		}
+		columns, err := rows.Columns()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		if table != "job" {
+			continue
+		}
+
+		exists := make(map[string]bool)
+		for _, c := range columns {
+			exists[c] = true
+		}
+		for _, c := range jobColumns {
+			if !exists[c[0]] {
+				return fmt.Errorf("Missing column job.%s. Apply the upgrade scripts in ddl/", c[0])
+			}
+		}
+	}
+
+	version, err := FetchSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch schema version: %s", err)
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("Schema version is %d but %d is required. Apply the upgrade scripts in ddl/", version, SchemaVersion)
+	}
+
 	return nil
 }
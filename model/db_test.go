@@ -0,0 +1,139 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestUpgradeDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := filepath.Join(dir, "denssweb.db")
+
+	// Job table as created by v0.0.2
+	old, err := sqlx.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`create table job (id integer primary key, status_id integer, token string)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := NewDB("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, c := range jobColumns {
+		_, err = db.Exec("select " + c[0] + " from job")
+		if err != nil {
+			t.Errorf("Column %s missing after upgrade: %s", c[0], err)
+		}
+	}
+
+	err = CheckSchema(db)
+	if err != nil {
+		t.Errorf("Upgraded database should have the current schema: %s", err)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := FetchSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("Incorrect schema version: got %d should be %d", version, SchemaVersion)
+	}
+
+	_, err = db.Exec(`update schema_version set version = ?`, SchemaVersion-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckSchema(db) == nil {
+		t.Errorf("Old schema version should fail")
+	}
+
+	_, err = db.Exec(`drop table webhook_delivery`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckSchema(db)
+	if err == nil || !strings.Contains(err.Error(), "webhook_delivery") {
+		t.Errorf("Missing table should fail: %v", err)
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denssweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := filepath.Join(dir, "denssweb.db")
+	_, err = OpenDB("sqlite3", dsn+"?_busy_timeout=5000")
+	if err == nil {
+		t.Errorf("Missing database file should fail")
+	}
+	if _, err := os.Stat(dsn); !os.IsNotExist(err) {
+		t.Errorf("Missing database file should not be created")
+	}
+
+	old, err := sqlx.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`create table job (id integer primary key, status_id integer, token string)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := OpenDB("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if CheckSchema(db) == nil {
+		t.Errorf("Old schema should fail")
+	}
+	if _, err := FetchSchemaVersion(db); err == nil {
+		t.Errorf("Opening the database should not upgrade the schema")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Approximate memory used by a DENSS run per grid voxel. DENSS keeps
+	// around twenty real and complex arrays the size of the grid
+	BytesPerVoxel = 160
+
+	// Seconds per voxel, step and run used until enough jobs have completed
+	// to calibrate the runtime
+	DefaultVoxelStepSeconds = 1.5e-7
+
+	// Number of completed jobs used to calibrate the runtime
+	CalibrationJobs = 100
+
+	// Minimum number of completed jobs needed to calibrate the runtime
+	MinCalibrationJobs = 5
+
+	// Largest number of grid samples per side of a job
+	MaxGridSize = 1024
+)
+
+var (
+	ErrGridTooLarge = errors.New("Grid size is too large")
+)
+
+// Grid samples per side used by DENSS for each mode when neither the number
+// of samples or voxel size is given
+var modeSamples = map[string]int64{
+	"fast":     32,
+	"slow":     64,
+	"membrane": 64,
+}
+
+// Estimated resources used by a job
+type Estimate struct {
+	// Grid samples per side
+	GridSize int64 `json:"grid_size"`
+
+	// Total number of voxels in the grid
+	Voxels int64 `json:"voxels"`
+
+	// Approximate memory of a single DENSS run in bytes
+	Memory int64 `json:"memory"`
+
+	// Expected runtime in seconds
+	Runtime float64 `json:"runtime"`
+
+	// True if the runtime is calibrated from completed jobs
+	Calibrated bool `json:"calibrated"`
+}
+
+// Number of grid samples per side used by DENSS for the job. Without the
+// number of samples the grid size is derived from the voxel size, which
+// requires Dmax, or the default of the mode. Returns ErrGridTooLarge if the
+// grid has more than MaxGridSize samples per side
+func GridSize(j *Job) (int64, error) {
+	if j.NumSamples > 0 {
+		if j.NumSamples > MaxGridSize {
+			return 0, fmt.Errorf("%w: %d samples exceeds the maximum of %d", ErrGridTooLarge, j.NumSamples, MaxGridSize)
+		}
+		return j.NumSamples, nil
+	}
+
+	if j.VoxelSize > 0 {
+		if j.Dmax <= 0 || j.Oversampling <= 0 {
+			return 0, errors.New("Dmax and oversampling are required to estimate the grid size from the voxel size")
+		}
+
+		// Compared as a float so huge grids can't overflow the conversion
+		f := math.Ceil(j.Oversampling * j.Dmax / j.VoxelSize)
+		if f > MaxGridSize {
+			return 0, fmt.Errorf("%w: %.0f samples exceeds the maximum of %d", ErrGridTooLarge, f, MaxGridSize)
+		}
+
+		// DENSS uses an even number of samples
+		n := int64(f)
+		if n%2 != 0 {
+			n++
+		}
+		return n, nil
+	}
+
+	if n, ok := modeSamples[j.Mode]; ok {
+		return n, nil
+	}
+
+	return modeSamples["slow"], nil
+}
+
+// Amount of work of the job in voxel steps over all runs
+func work(j *Job, n int64) float64 {
+	runs := j.MaxRuns
+	if runs < 1 {
+		runs = 1
+	}
+
+	return float64(n*n*n) * float64(j.MaxSteps) * float64(runs)
+}
+
+// Estimates the resources of jobs. The runtime is calibrated from the
+// runtime of recently completed jobs
+type Estimator struct {
+	mu         sync.RWMutex
+	rate       float64
+	jobs       int
+	calibrated time.Time
+}
+
+func NewEstimator() *Estimator {
+	return &Estimator{rate: DefaultVoxelStepSeconds}
+}
+
+// Calibrate the runtime from the median seconds per voxel step of recently
+// completed jobs. The default rate is kept with too few completed jobs
+func (e *Estimator) Calibrate(db *sqlx.DB) error {
+	jobs := []*Job{}
+	err := db.Select(&jobs, `
+        select
+            j.id,
+            j.dmax,
+            j.oversampling,
+            j.num_samples,
+            j.voxel_size,
+            j.max_steps,
+            j.max_runs,
+            j.params,
+            j.started,
+            j.completed
+        from job as j
+        where j.status_id = ? and j.started is not null and j.completed is not null
+        order by j.completed desc
+        limit ?`, StatusComplete, CalibrationJobs)
+	if err != nil {
+		return err
+	}
+
+	rates := make([]float64, 0, len(jobs))
+	for _, j := range jobs {
+		if err := j.UnmarshallParams(); err != nil {
+			continue
+		}
+
+		n, err := GridSize(j)
+		if err != nil || work(j, n) == 0 {
+			continue
+		}
+
+		seconds := j.Completed.Sub(*j.Started).Seconds()
+		if seconds <= 0 {
+			continue
+		}
+
+		rates = append(rates, seconds/work(j, n))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.calibrated = time.Now()
+	e.jobs = len(rates)
+	if e.jobs < MinCalibrationJobs {
+		e.rate = DefaultVoxelStepSeconds
+		return nil
+	}
+
+	sort.Float64s(rates)
+	e.rate = rates[len(rates)/2]
+
+	return nil
+}
+
+// Time the estimator was last calibrated. Zero if never calibrated
+func (e *Estimator) Calibrated() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.calibrated
+}
+
+// Estimate the resources of the job. Parameters should have their defaults
+// set
+func (e *Estimator) Estimate(j *Job) (*Estimate, error) {
+	n, err := GridSize(j)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	rate, jobs := e.rate, e.jobs
+	e.mu.RUnlock()
+
+	voxels := n * n * n
+	return &Estimate{
+		GridSize:   n,
+		Voxels:     voxels,
+		Memory:     voxels * BytesPerVoxel,
+		Runtime:    work(j, n) * rate,
+		Calibrated: jobs >= MinCalibrationJobs,
+	}, nil
+}
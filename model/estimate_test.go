@@ -0,0 +1,116 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGridSize(t *testing.T) {
+	tests := []struct {
+		job  *Job
+		size int64
+	}{
+		{&Job{NumSamples: 50, VoxelSize: 3, Dmax: 100, Oversampling: 3}, 50},
+		{&Job{VoxelSize: 3, Dmax: 100, Oversampling: 3}, 100},
+		{&Job{VoxelSize: 7, Dmax: 100, Oversampling: 3}, 44},
+		{&Job{ExtraParams: ExtraParams{Mode: "fast"}}, 32},
+		{&Job{ExtraParams: ExtraParams{Mode: "slow"}}, 64},
+	}
+
+	for _, test := range tests {
+		n, err := GridSize(test.job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != test.size {
+			t.Errorf("Incorrect grid size for %+v: got %d should be %d", test.job.ExtraParams, n, test.size)
+		}
+	}
+
+	if _, err := GridSize(&Job{VoxelSize: 3}); err == nil {
+		t.Errorf("Grid size from voxel size should require Dmax")
+	}
+
+	// Largest grid allowed by the parameter ranges
+	large := []*Job{
+		{VoxelSize: 1, Dmax: 8000, Oversampling: 50},
+		{NumSamples: MaxGridSize + 2},
+	}
+	for _, j := range large {
+		if _, err := GridSize(j); !errors.Is(err, ErrGridTooLarge) {
+			t.Errorf("Grid of %+v should be too large: got %v", j, err)
+		}
+	}
+
+	e := NewEstimator()
+	est, err := e.Estimate(&Job{VoxelSize: 1, Dmax: 512, Oversampling: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.GridSize != MaxGridSize || est.Memory != MaxGridSize*MaxGridSize*MaxGridSize*BytesPerVoxel {
+		t.Errorf("Incorrect estimate of the largest grid: %+v", est)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{ExtraParams: ExtraParams{Mode: "fast"}}
+	SetDefaults(job, Params)
+
+	e := NewEstimator()
+	est, err := e.Estimate(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.GridSize != 32 || est.Memory != 32*32*32*BytesPerVoxel || est.Calibrated {
+		t.Errorf("Incorrect estimate: %+v", est)
+	}
+
+	// Completed jobs taking 10 minutes each
+	for i := 0; i < MinCalibrationJobs; i++ {
+		c := completedJob(t, db, "calibrate")
+		started := time.Now().Add(-10 * time.Minute)
+		_, err = db.Exec(db.Rebind(`update job set started = ? where id = ?`), started, c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = e.Calibrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job = &Job{}
+	SetDefaults(job, Params)
+	est, err = e.Estimate(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !est.Calibrated || math.Abs(est.Runtime-600) > 5 {
+		t.Errorf("Runtime should be calibrated from completed jobs: %+v", est)
+	}
+}
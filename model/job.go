@@ -19,6 +19,7 @@ package model
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -27,40 +28,93 @@ import (
 	humanize "github.com/dustin/go-humanize"
 	"github.com/jmoiron/sqlx"
 	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/mrc"
 )
 
 const (
-	_              = iota // 0
-	StatusPending         // 1
-	StatusRunning         // 2
-	StatusComplete        // 3
-	StatusError           // 4
+	_               = iota // 0
+	StatusPending          // 1
+	StatusRunning          // 2
+	StatusComplete         // 3
+	StatusError            // 4
+	StatusCancelled        // 5
 )
 
+var (
+	// Job status names as stored in the job_status table
+	statusNames = map[int64]string{
+		StatusPending:   "Pending",
+		StatusRunning:   "Running",
+		StatusComplete:  "Complete",
+		StatusError:     "Error",
+		StatusCancelled: "Cancelled",
+	}
+)
+
+// Name of the job status
+func StatusName(id int64) string {
+	return statusNames[id]
+}
+
+const (
+	// Name of the density map image shown as the job thumbnail
+	ThumbnailImage = "projection-z"
+)
+
+// Job parameters stored as JSON in the params column. These and the other
+// DENSS parameters of the Job are parsed and validated with the parameter
+// registry in params.go
 type ExtraParams struct {
 	// Symmetry
-	Symmetry int64 `db:"-" json:"ncs" valid:"-" schema:"ncs"`
+	Symmetry int64 `db:"-" json:"ncs" valid:"-" schema:"-"`
 
 	// Symmetry Axis
-	SymmetryAxis int64 `db:"-" json:"ncs_axis" valid:"-" schema:"ncs_axis"`
+	SymmetryAxis int64 `db:"-" json:"ncs_axis" valid:"-" schema:"-"`
 
 	// Symmetry Steps
-	SymmetrySteps string `db:"-" json:"ncs_steps" valid:"-" schema:"ncs_steps"`
+	SymmetrySteps string `db:"-" json:"ncs_steps" valid:"-" schema:"-"`
 
-	// Fit experimental data
-	Fit bool `db:"-" json:"fit" valid:"-" schema:"fit"`
+	// Fit a smooth curve to raw experimental data before running DENSS
+	Fit bool `db:"-" json:"fit" valid:"-" schema:"-"`
 
 	// Enantiomer
-	Enantiomer bool `db:"-" json:"enantiomer" valid:"-" schema:"enantiomer"`
+	Enantiomer bool `db:"-" json:"enantiomer" valid:"-" schema:"-"`
 
 	// Mode
-	Mode string `db:"-" json:"mode" valid:"-" schema:"mode"`
+	Mode string `db:"-" json:"mode" valid:"-" schema:"-"`
 
 	// Units
-	Units string `db:"-" json:"units" valid:"-" schema:"units"`
+	Units string `db:"-" json:"units" valid:"-" schema:"-"`
 
 	// Method
 	Method string `db:"-" json:"method" valid:"-" schema:"-"`
+
+	// Name of the preset the job was submitted with
+	Preset string `db:"-" json:"preset,omitempty" valid:"-" schema:"-"`
+
+	// Parameters changed to fit the limits of the submitter
+	Notices []string `db:"-" json:"notices,omitempty" valid:"-" schema:"-"`
+
+	// Events the submitter is notified of. Nil for the default events
+	Notify []string `db:"-" json:"notify" valid:"-" schema:"-"`
+
+	// Send notifications in a digest instead of an email per event
+	Digest bool `db:"-" json:"digest,omitempty" valid:"-" schema:"-"`
+
+	// Whether the submitter was notified the job results are expiring
+	ExpiryNotified bool `db:"-" json:"expiry_notified,omitempty" valid:"-" schema:"-"`
+}
+
+// Agreement between a density map aligned to an atomic model and the density
+// computed from the model
+type ModelFit struct {
+	// Correlation of the aligned map and the model density
+	Correlation float64 `json:"correlation"`
+
+	// Fourier Shell Correlation (FSC) between the aligned map and the model
+	// density
+	FSC *chart.FSC `json:"fsc"`
 }
 
 // A DENSS Job
@@ -112,8 +166,55 @@ type Job struct {
 	// A zip of the raw output from DENSS
 	RawData []byte `db:"raw_data" json:"-" valid:"-" schema:"-"`
 
-	// Maximum dimension of particle
-	Dmax float64 `db:"dmax" json:"-" valid:"range(10.0|1000.0)~Dmax should be between 10 and 1000" schema:"dmax"`
+	// Fourier Shell Correlation (FSC) curve data in JSON format
+	FSCData []byte `db:"fsc_data" json:"-" valid:"-" schema:"-"`
+
+	// Statistics by step for each DENSS run in JSON format
+	StatsData []byte `db:"stats_data" json:"-" valid:"-" schema:"-"`
+
+	// Density map statistics in JSON format
+	MapStatsData []byte `db:"map_stats" json:"-" valid:"-" schema:"-"`
+
+	// Density map statistics. Only used in json and templates
+	MapStats *mrc.Stats `db:"-" json:"map_stats,omitempty" valid:"-" schema:"-"`
+
+	// Projection and slice images of the density map in PNG format keyed by
+	// name. Saved to the job_image table on completion
+	Images map[string][]byte `db:"-" json:"-" valid:"-" schema:"-"`
+
+	// Whether the job has a thumbnail image. Only used in the job list
+	Thumbnail bool `db:"thumbnail" json:"-" valid:"-" schema:"-"`
+
+	// Optional atomic model the density map is aligned to
+	ModelData []byte `db:"model_data" json:"-" valid:"-" schema:"-"`
+
+	// Atomic model file type (pdb | cif)
+	ModelType string `db:"model_type" json:"-" valid:"-" schema:"-"`
+
+	// Density map aligned to the atomic model in CCP4 format
+	AlignedMap []byte `db:"aligned_map" json:"-" valid:"-" schema:"-"`
+
+	// Density computed from the atomic model in CCP4 format
+	ModelMap []byte `db:"model_map" json:"-" valid:"-" schema:"-"`
+
+	// Agreement of the aligned map and the model density in JSON format
+	ModelFitData []byte `db:"model_fit" json:"-" valid:"-" schema:"-"`
+
+	// Agreement of the aligned map and the model density. Only used in json
+	// and templates
+	ModelFit *ModelFit `db:"-" json:"model_fit,omitempty" valid:"-" schema:"-"`
+
+	// Fit of the intensity calculated from the density map to the
+	// experimental data in JSON format
+	FitData []byte `db:"data_fit" json:"-" valid:"-" schema:"-"`
+
+	// Fit of the intensity calculated from the density map to the
+	// experimental data. Only used in json and templates
+	DataFit *chart.Fit `db:"-" json:"data_fit,omitempty" valid:"-" schema:"-"`
+
+	// Maximum dimension of particle. See Params for the allowed range and
+	// default of this and the following parameters
+	Dmax float64 `db:"dmax" json:"-" valid:"-" schema:"-"`
 
 	// Number of samples. This represents the size of the grid in each
 	// dimension. The grid is 3D so NumSamples=31 would be 31 x 31 x 31. The
@@ -121,26 +222,32 @@ type Job struct {
 	// More samples means greater resolution. This is calculated by DENSS, it's
 	// not given to DENSS but we want to control the speed of calcuation so we
 	// use this parameter to determine the voxel size.
-	NumSamples int64 `db:"num_samples" json:"-" valid:"range(2|500)~Number of samples should be between 2 and 500" schema:"-"`
+	NumSamples int64 `db:"num_samples" json:"-" valid:"-" schema:"-"`
 
 	// Oversampling size
-	Oversampling float64 `db:"oversampling" json:"-" valid:"range(2|50)~Oversampling should be between 2 and 50" schema:"-"`
+	Oversampling float64 `db:"oversampling" json:"-" valid:"-" schema:"-"`
 
 	// Voxel Size
-	VoxelSize float64 `db:"voxel_size" json:"-" valid:"range(1.0|100.0)~Voxel Size should be between 1 and 100" schema:"-"`
+	VoxelSize float64 `db:"voxel_size" json:"-" valid:"-" schema:"-"`
 
 	// Number of electrons
-	Electrons int64 `db:"electrons" json:"-" valid:"range(1|100000000)~Electrons should be between 1 and 1e8" schema:"electrons"`
+	Electrons int64 `db:"electrons" json:"-" valid:"-" schema:"-"`
 
 	// Maximum number of steps
-	MaxSteps int64 `db:"max_steps" json:"-" valid:"range(100|10000)~Max Steps should be between 100 and 10000" schema:"-"`
+	MaxSteps int64 `db:"max_steps" json:"-" valid:"-" schema:"-"`
 
 	// Maximum number of times to run DENSS
-	MaxRuns int64 `db:"max_runs" json:"-" valid:"range(2|100)~Max Runs should be between 2 and 100" schema:"-"`
+	MaxRuns int64 `db:"max_runs" json:"-" valid:"-" schema:"-"`
 
 	// Params hack
 	Params string `db:"params" json:"-" valid:"-" schema:"-"`
 
+	// URL receiving a signed POST on each job event
+	Webhook string `db:"webhook_url" json:"-" valid:"-" schema:"-"`
+
+	// Secret used to sign the webhook requests of the job
+	WebhookSecret string `db:"webhook_secret" json:"-" valid:"-" schema:"-"`
+
 	// Time the job was submitted
 	Submitted *time.Time `db:"submitted" json:"-" valid:"-" schema:"-"`
 
@@ -152,18 +259,29 @@ type Job struct {
 
 	// Current running/wait time for the job. Only used in json
 	Time string `db:"-" json:"time" valid:"-" schema:"-"`
+
+	// Position of a pending job in the queue starting at 1 for the next job
+	QueuePosition int `db:"-" json:"queue_position,omitempty" valid:"-" schema:"-"`
+
+	// Expected completion time of a pending or running job
+	ETA *time.Time `db:"-" json:"eta,omitempty" valid:"-" schema:"-"`
 }
 
 func (j *Job) MarshallParams() error {
 	params := &ExtraParams{
-		Symmetry:      j.Symmetry,
-		SymmetryAxis:  j.SymmetryAxis,
-		SymmetrySteps: j.SymmetrySteps,
-		Fit:           j.Fit,
-		Enantiomer:    j.Enantiomer,
-		Mode:          j.Mode,
-		Units:         j.Units,
-		Method:        j.Method,
+		Symmetry:       j.Symmetry,
+		SymmetryAxis:   j.SymmetryAxis,
+		SymmetrySteps:  j.SymmetrySteps,
+		Fit:            j.Fit,
+		Enantiomer:     j.Enantiomer,
+		Mode:           j.Mode,
+		Units:          j.Units,
+		Method:         j.Method,
+		Preset:         j.Preset,
+		Notices:        j.Notices,
+		Notify:         j.Notify,
+		Digest:         j.Digest,
+		ExpiryNotified: j.ExpiryNotified,
 	}
 
 	jsonBytes, err := json.Marshal(params)
@@ -176,6 +294,17 @@ func (j *Job) MarshallParams() error {
 	return nil
 }
 
+// Save the JSON params of the job
+func UpdateParams(db *sqlx.DB, job *Job) error {
+	err := job.MarshallParams()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`update job set params = ? where id = ?`, job.Params, job.ID)
+	return err
+}
+
 func (j *Job) UnmarshallParams() error {
 	if j.Params == "" {
 		return nil
@@ -251,12 +380,19 @@ func FetchJob(db *sqlx.DB, token string) (*Job, error) {
             j.max_steps,
             j.max_runs,
             j.params,
+            j.map_stats,
+            coalesce(j.model_type, '') as model_type,
+            j.model_fit,
+            j.data_fit,
+            coalesce(j.webhook_url, '') as webhook_url,
+            coalesce(j.webhook_secret, '') as webhook_secret,
             j.submitted,
             j.started,
-            j.completed
+            j.completed,
+            exists(select 1 from job_image as i where i.job_id = j.id and i.name = ?) as thumbnail
         from job as j 
         join job_status s on s.id = j.status_id
-        where j.token = ?`, token)
+        where j.token = ?`, ThumbnailImage, token)
 	if err != nil {
 		return nil, err
 	}
@@ -266,6 +402,30 @@ func FetchJob(db *sqlx.DB, token string) (*Job, error) {
 		return nil, err
 	}
 
+	if len(job.MapStatsData) > 0 {
+		job.MapStats = &mrc.Stats{}
+		err = json.Unmarshal(job.MapStatsData, job.MapStats)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(job.ModelFitData) > 0 {
+		job.ModelFit = &ModelFit{}
+		err = json.Unmarshal(job.ModelFitData, job.ModelFit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(job.FitData) > 0 {
+		job.DataFit = &chart.Fit{}
+		err = json.Unmarshal(job.FitData, job.DataFit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &job, nil
 }
 
@@ -286,22 +446,7 @@ func QueueJob(db *sqlx.DB, job *Job) error {
 	job.LogMessage = ""
 	job.Token = randToken()
 
-	// Set default values for params
-	if job.Oversampling <= 0 {
-		job.Oversampling = 3.0
-	}
-	if job.Electrons <= 0 {
-		job.Electrons = 10000
-	}
-	if job.MaxSteps <= 0 {
-		job.MaxSteps = 3000
-	}
-	if job.MaxRuns <= 0 {
-		job.MaxRuns = 20
-	}
-	if job.MaxRuns > 20 {
-		job.MaxRuns = 20
-	}
+	SetDefaults(job, Params)
 
 	err = job.MarshallParams()
 	if err != nil {
@@ -315,6 +460,8 @@ func QueueJob(db *sqlx.DB, job *Job) error {
             percent_complete,
             log_message,
             input_data,
+            model_data,
+            model_type,
             name,
             token,
             email,
@@ -327,6 +474,8 @@ func QueueJob(db *sqlx.DB, job *Job) error {
             max_runs,
             params,
             voxel_size,
+            webhook_url,
+            webhook_secret,
             submitted
         ) values (
             :status_id,
@@ -334,6 +483,8 @@ func QueueJob(db *sqlx.DB, job *Job) error {
             :percent_complete,
             :log_message,
             :input_data,
+            :model_data,
+            :model_type,
             :name,
             :token,
             :email,
@@ -346,6 +497,8 @@ func QueueJob(db *sqlx.DB, job *Job) error {
             :max_runs,
             :params,
             :voxel_size,
+            :webhook_url,
+            :webhook_secret,
             :submitted)`, job)
 	if err != nil {
 		return err
@@ -367,13 +520,14 @@ func FetchNextPending(db *sqlx.DB) (*Job, error) {
 	}
 	defer tx.Commit()
 
-	job := Job{}
-	err = tx.Get(&job, `
+	query := `
 		select
 			j.id,
 			j.status_id,
 			s.status,
             j.input_data,
+            j.model_data,
+            coalesce(j.model_type, '') as model_type,
             j.name,
             j.token,
             j.email,
@@ -386,15 +540,24 @@ func FetchNextPending(db *sqlx.DB) (*Job, error) {
             j.max_runs,
             j.params,
             j.voxel_size,
+            coalesce(j.webhook_url, '') as webhook_url,
+            coalesce(j.webhook_secret, '') as webhook_secret,
             j.submitted,
             j.started,
             j.completed
         from job as j 
         join job_status s on s.id = j.status_id
         where j.status_id = ?
-        order by j.submitted asc
-        limit 1
-        for update`, StatusPending)
+        order by j.submitted asc, j.id asc
+        limit 1`
+
+	// sqlite3 does not support row locking
+	if db.DriverName() == "mysql" {
+		query += ` for update`
+	}
+
+	job := Job{}
+	err = tx.Get(&job, query, StatusPending)
 	if err != nil {
 		return nil, err
 	}
@@ -423,7 +586,7 @@ func FetchNextPending(db *sqlx.DB) (*Job, error) {
 func FetchAllJobs(db *sqlx.DB, status, limit, offset int) ([]*Job, error) {
 	jobs := []*Job{}
 
-	args := make([]interface{}, 0)
+	args := []interface{}{ThumbnailImage}
 	query := `
         select
             j.id,
@@ -442,7 +605,8 @@ func FetchAllJobs(db *sqlx.DB, status, limit, offset int) ([]*Job, error) {
             j.voxel_size,
             j.submitted,
             j.started,
-            j.completed
+            j.completed,
+            exists(select 1 from job_image as i where i.job_id = j.id and i.name = ?) as thumbnail
         from job as j 
         join job_status s on s.id = j.status_id`
 
@@ -453,7 +617,7 @@ func FetchAllJobs(db *sqlx.DB, status, limit, offset int) ([]*Job, error) {
 			query += ` order by j.completed desc`
 		} else if status == StatusRunning {
 			query += ` order by j.started desc`
-		} else if status == StatusError {
+		} else if status == StatusError || status == StatusCancelled {
 			query += ` order by j.completed desc`
 		} else {
 			query += ` order by j.submitted desc`
@@ -473,8 +637,15 @@ func FetchAllJobs(db *sqlx.DB, status, limit, offset int) ([]*Job, error) {
 	return jobs, nil
 }
 
-// Complete Job
+// Complete Job. Jobs completed successfully must have a valid density map
 func CompleteJob(db *sqlx.DB, job *Job, statusID int) error {
+	if statusID == StatusComplete {
+		_, err := mrc.Decode(job.DensityMap)
+		if err != nil {
+			return fmt.Errorf("Invalid density map: %s", err)
+		}
+	}
+
 	tx, err := db.Beginx()
 	if err != nil {
 		return err
@@ -492,12 +663,52 @@ func CompleteJob(db *sqlx.DB, job *Job, statusID int) error {
             fsc_chart = :fsc_chart,
             summary_chart = :summary_chart,
             raw_data = :raw_data,
+            fsc_data = :fsc_data,
+            stats_data = :stats_data,
+            map_stats = :map_stats,
+            aligned_map = :aligned_map,
+            model_map = :model_map,
+            model_fit = :model_fit,
+            data_fit = :data_fit,
             completed = :completed
         where id = :id`, job)
 	if err != nil {
 		return err
 	}
 
+	for name, img := range job.Images {
+		_, err = tx.Exec(`replace into job_image (job_id, name, image) values (?, ?, ?)`, job.ID, name, img)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Cancel a pending job. Returns sql.ErrNoRows if the job is no longer pending
+func CancelJob(db *sqlx.DB, job *Job) error {
+	now := time.Now()
+	res, err := db.Exec(`
+        update job set status_id = ?, task = ?, log_message = ?, completed = ?
+        where id = ? and status_id = ?`,
+		StatusCancelled, "Cancelled", "Job cancelled before it started running", now, job.ID, StatusPending)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	job.StatusID = StatusCancelled
+	job.Status = "Cancelled"
+	job.Completed = &now
+
 	return nil
 }
 
@@ -522,6 +733,47 @@ func FetchDensityMap(db *sqlx.DB, token string) (*Job, error) {
 	return &job, nil
 }
 
+// Fetch a projection or slice image of the job density map by token and
+// image name.
+func FetchJobImage(db *sqlx.DB, token, name string) ([]byte, error) {
+	var img []byte
+	err := db.Get(&img, `
+		select
+            i.image
+        from job_image as i
+        join job as j on j.id = i.job_id
+        where j.token = ? and i.name = ?`, token, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// Fetch job atomic model, aligned map and model density by token.
+func FetchModelFiles(db *sqlx.DB, token string) (*Job, error) {
+	job := Job{}
+	err := db.Get(&job, `
+		select
+			j.id,
+			j.status_id,
+            j.name,
+            j.model_data,
+            coalesce(j.model_type, '') as model_type,
+            j.aligned_map,
+            j.model_map,
+            j.submitted,
+            j.started,
+            j.completed
+        from job as j 
+        where j.token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
 // Fetch job fsc chart by token.
 func FetchFSCChart(db *sqlx.DB, token string) (*Job, error) {
 	job := Job{}
@@ -564,6 +816,69 @@ func FetchSummaryChart(db *sqlx.DB, token string) (*Job, error) {
 	return &job, nil
 }
 
+// Fetch job FSC curve data by token.
+func FetchFSCData(db *sqlx.DB, token string) (*Job, error) {
+	job := Job{}
+	err := db.Get(&job, `
+		select
+			j.id,
+			j.status_id,
+            j.name,
+            j.fsc_data,
+            j.submitted,
+            j.started,
+            j.completed
+        from job as j 
+        where j.token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Fetch job fit data by token.
+func FetchFitData(db *sqlx.DB, token string) (*Job, error) {
+	job := Job{}
+	err := db.Get(&job, `
+		select
+			j.id,
+			j.status_id,
+            j.name,
+            j.data_fit,
+            j.submitted,
+            j.started,
+            j.completed
+        from job as j 
+        where j.token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Fetch job statistics by step data by token.
+func FetchStatsData(db *sqlx.DB, token string) (*Job, error) {
+	job := Job{}
+	err := db.Get(&job, `
+		select
+			j.id,
+			j.status_id,
+            j.name,
+            j.stats_data,
+            j.submitted,
+            j.started,
+            j.completed
+        from job as j 
+        where j.token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
 // Fetch job raw data by token.
 func FetchRawData(db *sqlx.DB, token string) (*Job, error) {
 	job := Job{}
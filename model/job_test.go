@@ -20,6 +20,8 @@ package model
 import (
 	"database/sql"
 	"testing"
+
+	"github.com/ubccr/denssweb/mrc"
 )
 
 func TestJob(t *testing.T) {
@@ -77,8 +79,21 @@ func TestJob(t *testing.T) {
 	}
 
 	jobx.DensityMap = []byte("xxx")
+
+	err = CompleteJob(db, jobx, StatusComplete)
+	if err == nil {
+		t.Errorf("Invalid density map should not complete job")
+	}
+
+	jobx.DensityMap = mrc.New(4, 4, 4, 1.0).Encode()
+	jobx.MapStatsData = []byte(`{"rg":15.5}`)
 	jobx.FSCChart = []byte("yyy")
 	jobx.RawData = []byte("zzz")
+	jobx.FSCData = []byte(`{"frequency":[0,0.1],"correlation":[1,0.4]}`)
+	jobx.Images = map[string][]byte{ThumbnailImage: []byte("png")}
+	jobx.ModelMap = []byte("model")
+	jobx.ModelFitData = []byte(`{"correlation":0.8}`)
+	jobx.FitData = []byte(`{"q":[0.1],"intensity":[10],"error":[1],"calculated":[9],"chi2":1}`)
 
 	err = CompleteJob(db, jobx, StatusComplete)
 	if err != nil {
@@ -89,13 +104,75 @@ func TestJob(t *testing.T) {
 		t.Errorf("Incorrect job status: got %d should be %d", jobx.StatusID, StatusComplete)
 	}
 
+	jobx, err = FetchJob(db, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jobx.MapStats == nil || jobx.MapStats.Rg != 15.5 {
+		t.Errorf("Incorrect map stats: got %v", jobx.MapStats)
+	}
+
+	if jobx.ModelFit == nil || jobx.ModelFit.Correlation != 0.8 {
+		t.Errorf("Incorrect model fit: got %v", jobx.ModelFit)
+	}
+
+	if jobx.DataFit == nil || jobx.DataFit.Chi2 != 1 {
+		t.Errorf("Incorrect data fit: got %v", jobx.DataFit)
+	}
+
+	jobx, err = FetchModelFiles(db, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(jobx.ModelMap) != "model" || jobx.ModelType != "" {
+		t.Errorf("Incorrect model files: got %s %s", jobx.ModelMap, jobx.ModelType)
+	}
+
+	img, err := FetchJobImage(db, job.Token, ThumbnailImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(img) != "png" {
+		t.Errorf("Incorrect job image: got %s", img)
+	}
+
+	_, err = FetchJobImage(db, job.Token, "slice-x")
+	if err != sql.ErrNoRows {
+		t.Errorf("Missing job image should return sql.ErrNoRows: got %v", err)
+	}
+
+	jobx, err = FetchFSCData(db, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(jobx.FSCData) != `{"frequency":[0,0.1],"correlation":[1,0.4]}` {
+		t.Errorf("Incorrect FSC data: got %s", jobx.FSCData)
+	}
+
+	jobx, err = FetchStatsData(db, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobx.StatsData) != 0 {
+		t.Errorf("Stats data should be empty: got %s", jobx.StatsData)
+	}
+
 	jobs, err := FetchAllJobs(db, StatusComplete, 10, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	if len(jobs) != 1 {
-		t.Errorf("Incorrect number of jobs: got %d should be %d", len(jobs), 1)
+		t.Fatalf("Incorrect number of jobs: got %d should be %d", len(jobs), 1)
+	}
+
+	if !jobs[0].Thumbnail {
+		t.Errorf("Completed job should have a thumbnail")
 	}
 
 	jobs, err = FetchAllJobs(db, StatusError, 10, 0)
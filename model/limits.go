@@ -0,0 +1,223 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Configured default value and allowed range of a numeric parameter. Fields
+// not set keep the value of the parameter registry
+type Limit struct {
+	Default *float64 `mapstructure:"default" json:"default,omitempty"`
+	Min     *float64 `mapstructure:"min" json:"min,omitempty"`
+	Max     *float64 `mapstructure:"max" json:"max,omitempty"`
+}
+
+// Limits applied to jobs submitted with an email address in one of the
+// domains or in the list of emails
+type LimitOverride struct {
+	// Name used in logs
+	Name string `mapstructure:"name"`
+
+	// Email domains. Subdomains also match
+	Domains []string `mapstructure:"domains"`
+
+	// Email addresses
+	Emails []string `mapstructure:"emails"`
+
+	// Limits keyed by parameter name
+	Limits map[string]*Limit `mapstructure:"limits"`
+}
+
+// Check the limits refer to numeric parameters and have a valid range
+func checkLimits(limits map[string]*Limit) error {
+	for name, l := range limits {
+		p := LookupParam(name)
+		if p == nil {
+			return fmt.Errorf("Unknown parameter %s", name)
+		}
+		if p.Type != ParamFloat && p.Type != ParamInt {
+			return fmt.Errorf("Limits can only be set for numeric parameters: %s", name)
+		}
+		if l == nil {
+			continue
+		}
+
+		q := p.withLimit(l)
+		if q.Min > q.Max {
+			return fmt.Errorf("Minimum of %s is larger than the maximum", name)
+		}
+		if l.Default != nil && (*l.Default < q.Min || *l.Default > q.Max) {
+			return fmt.Errorf("Default of %s should be between %s and %s", name, formatLimit(q.Min), formatLimit(q.Max))
+		}
+	}
+
+	return nil
+}
+
+// Copy of the parameter with the limit applied
+func (p *Param) withLimit(l *Limit) *Param {
+	q := *p
+	if l.Min != nil {
+		q.Min = *l.Min
+	}
+	if l.Max != nil {
+		q.Max = *l.Max
+	}
+	if l.Default != nil {
+		if p.Type == ParamInt {
+			q.Default = int64(*l.Default)
+		} else {
+			q.Default = *l.Default
+		}
+	}
+
+	return &q
+}
+
+// Apply the configured limits to the parameter registry. Should be called
+// once on startup before any jobs are submitted
+func ConfigureParams(limits map[string]*Limit) error {
+	err := checkLimits(limits)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range Params {
+		if l := limits[p.Name]; l != nil {
+			Params[i] = p.withLimit(l)
+		}
+	}
+
+	return nil
+}
+
+// Check the override has a valid range for each parameter
+func (o *LimitOverride) Validate() error {
+	if len(o.Domains) == 0 && len(o.Emails) == 0 {
+		return fmt.Errorf("Limit override %s must have domains or emails", o.Name)
+	}
+
+	err := checkLimits(o.Limits)
+	if err != nil {
+		return fmt.Errorf("Limit override %s: %s", o.Name, err)
+	}
+
+	return nil
+}
+
+// Returns true if the override applies to the email address
+func (o *LimitOverride) Matches(email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	for _, e := range o.Emails {
+		if strings.ToLower(e) == email {
+			return true
+		}
+	}
+
+	domain := email[at+1:]
+	for _, d := range o.Domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "@"))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parameter registry with the limits of all overrides matching the email
+// address applied. Later overrides take precedence
+func ParamsFor(email string, overrides []*LimitOverride) []*Param {
+	params := make([]*Param, len(Params))
+	copy(params, Params)
+
+	for _, o := range overrides {
+		if !o.Matches(email) {
+			continue
+		}
+		for i, p := range params {
+			if l := o.Limits[p.Name]; l != nil {
+				params[i] = p.withLimit(l)
+			}
+		}
+	}
+
+	return params
+}
+
+// Clamp numeric parameters of the job to the limits of the overrides applied
+// to params, as returned by ParamsFor. Only parameters limited by an override
+// are clamped and values outside the range of the parameter registry are left
+// for ValidateParams to reject. Returns a message for each parameter changed
+func ClampParams(j *Job, params []*Param) []string {
+	var msgs []string
+	for i, p := range params {
+		base := Params[i]
+		if p == base || (p.Type != ParamFloat && p.Type != ParamInt) || !p.IsSet(j) {
+			continue
+		}
+
+		switch v := p.field(j).(type) {
+		case *float64:
+			if *v < base.Min || *v > base.Max {
+				continue
+			}
+			if c := clamp(*v, p.Min, p.Max); c != *v {
+				msgs = append(msgs, clampMessage(p, *v, c))
+				*v = c
+			}
+		case *int64:
+			if float64(*v) < base.Min || float64(*v) > base.Max {
+				continue
+			}
+			if c := int64(clamp(float64(*v), p.Min, p.Max)); c != *v {
+				msgs = append(msgs, clampMessage(p, float64(*v), float64(c)))
+				*v = c
+			}
+		}
+	}
+
+	return msgs
+}
+
+func clamp(f, min, max float64) float64 {
+	if f < min {
+		return min
+	}
+	if f > max {
+		return max
+	}
+	return f
+}
+
+func clampMessage(p *Param, from, to float64) string {
+	limit := "minimum"
+	if to < from {
+		limit = "maximum"
+	}
+
+	return fmt.Sprintf("%s changed from %s to %s, the %s allowed", p.Label, formatLimit(from), formatLimit(to), limit)
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"testing"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestLimitOverrideMatches(t *testing.T) {
+	o := &LimitOverride{Domains: []string{"example.edu"}, Emails: []string{"Guest@Example.org"}}
+
+	tests := map[string]bool{
+		"user@example.edu":      true,
+		"user@chem.example.edu": true,
+		"USER@EXAMPLE.EDU":      true,
+		"guest@example.org":     true,
+		"other@example.org":     false,
+		"user@badexample.edu":   false,
+		"example.edu":           false,
+		"":                      false,
+	}
+
+	for email, match := range tests {
+		if o.Matches(email) != match {
+			t.Errorf("Incorrect match for %q: expected %t", email, match)
+		}
+	}
+}
+
+func TestClampParams(t *testing.T) {
+	overrides := []*LimitOverride{
+		{Domains: []string{"example.edu"}, Limits: map[string]*Limit{"nmaps": {Max: float(50), Default: float(30)}}},
+		{Domains: []string{"example.org"}, Limits: map[string]*Limit{"nmaps": {Max: float(10)}}},
+	}
+
+	job := &Job{MaxRuns: 15, Dmax: 20000}
+	params := ParamsFor("user@example.org", overrides)
+	msgs := ClampParams(job, params)
+	if job.MaxRuns != 10 || len(msgs) != 1 {
+		t.Errorf("Override limit not clamped: runs=%d %v", job.MaxRuns, msgs)
+	}
+	if job.Dmax != 20000 {
+		t.Errorf("Parameters without an override should not be clamped: dmax=%f", job.Dmax)
+	}
+
+	job = &Job{MaxRuns: 40}
+	msgs = ClampParams(job, params)
+	if job.MaxRuns != 40 || len(msgs) != 0 {
+		t.Errorf("Values outside the parameter range should not be clamped: runs=%d %v", job.MaxRuns, msgs)
+	}
+	errs := make(FieldErrors)
+	ValidateParams(job, params, errs)
+	if _, ok := errs["nmaps"]; !ok {
+		t.Errorf("Values outside the parameter range should be rejected")
+	}
+
+	job = &Job{MaxRuns: 40}
+	params = ParamsFor("user@example.edu", overrides)
+	msgs = ClampParams(job, params)
+	if job.MaxRuns != 40 || len(msgs) != 0 {
+		t.Errorf("Override limit not applied: runs=%d %v", job.MaxRuns, msgs)
+	}
+
+	job = &Job{}
+	SetDefaults(job, params)
+	if job.MaxRuns != 30 {
+		t.Errorf("Override default not applied: got %d", job.MaxRuns)
+	}
+
+	if LookupParam("nmaps").Max != 20 {
+		t.Errorf("Overrides should not change the registry")
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	tests := []map[string]*Limit{
+		{"unknown": {Max: float(1)}},
+		{"mode": {Max: float(1)}},
+		{"nmaps": {Min: float(10), Max: float(5)}},
+		{"nmaps": {Default: float(30)}},
+	}
+
+	for _, limits := range tests {
+		if err := checkLimits(limits); err == nil {
+			t.Errorf("Invalid limits passed: %v", limits)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Job lifecycle events users can be notified of
+	EventQueued    = "queued"
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	EventCancelled = "cancelled"
+	EventExpiring  = "expiring"
+)
+
+// A job lifecycle event. The email templates for the event are named after
+// the lower case Status
+type Event struct {
+	Name   string
+	Label  string
+	Status string
+}
+
+var (
+	// Events in lifecycle order
+	Events = []*Event{
+		{Name: EventQueued, Label: "Queued", Status: "SUBMITTED"},
+		{Name: EventStarted, Label: "Started running", Status: "STARTED"},
+		{Name: EventCompleted, Label: "Completed", Status: "COMPLETED"},
+		{Name: EventFailed, Label: "Failed", Status: "FAILED"},
+		{Name: EventCancelled, Label: "Cancelled", Status: "CANCELLED"},
+		{Name: EventExpiring, Label: "Results expiring soon", Status: "EXPIRING"},
+	}
+
+	// Events notified for jobs submitted without notification preferences
+	DefaultNotify = []string{EventQueued, EventCompleted, EventFailed}
+)
+
+// Find an event by name. Returns nil if not found
+func FindEvent(name string) *Event {
+	for _, e := range Events {
+		if e.Name == name {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the submitter of the job wants to be notified of the event
+func (j *Job) Notifies(event string) bool {
+	notify := j.Notify
+	if notify == nil {
+		notify = DefaultNotify
+	}
+
+	for _, n := range notify {
+		if n == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse the notification preferences from the notify and digest form fields.
+// Jobs submitted without a notify field keep the default events. The submit
+// form always sends an empty notify field so unchecking every event turns off
+// notifications
+func ParseNotify(j *Job, form url.Values) {
+	j.Digest = form.Get("digest") != ""
+
+	values, ok := form["notify"]
+	if !ok {
+		return
+	}
+
+	j.Notify = []string{}
+	for _, e := range Events {
+		for _, v := range values {
+			if v == e.Name {
+				j.Notify = append(j.Notify, e.Name)
+				break
+			}
+		}
+	}
+}
+
+// A notification held back to be sent with the next digest to the recipient
+type DigestEntry struct {
+	// Unique ID for the entry
+	ID int64 `db:"id"`
+
+	// Job the notification is for
+	JobID int64 `db:"job_id"`
+
+	// Email address of the recipient
+	Recipient string `db:"recipient"`
+
+	// Event name
+	Event string `db:"event"`
+
+	// Time of the event
+	Created *time.Time `db:"created"`
+
+	// Name, token and current status of the job
+	JobName   string `db:"job_name"`
+	JobToken  string `db:"job_token"`
+	JobStatus string `db:"job_status"`
+}
+
+// URL of the job
+func (d *DigestEntry) URL() string {
+	return (&Job{Token: d.JobToken}).URL()
+}
+
+// Hold back a notification for the next digest
+func QueueDigest(db *sqlx.DB, d *DigestEntry) error {
+	now := time.Now()
+	d.Created = &now
+
+	res, err := db.NamedExec(`
+        insert into email_digest (job_id, recipient, event, created)
+        values (:job_id, :recipient, :event, :created)`, d)
+	if err != nil {
+		return err
+	}
+
+	d.ID, err = res.LastInsertId()
+	return err
+}
+
+// Fetch all held back notifications ordered by recipient and time
+func FetchDigests(db *sqlx.DB) ([]*DigestEntry, error) {
+	entries := []*DigestEntry{}
+	err := db.Select(&entries, `
+        select
+            d.id,
+            d.job_id,
+            d.recipient,
+            d.event,
+            d.created,
+            j.name as job_name,
+            j.token as job_token,
+            s.status as job_status
+        from email_digest as d
+        join job as j on j.id = d.job_id
+        join job_status s on s.id = j.status_id
+        order by d.recipient, d.created, d.id`)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Delete held back notifications after they were sent in a digest
+func DeleteDigests(db *sqlx.DB, entries []*DigestEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(entries))
+	for _, d := range entries {
+		ids = append(ids, d.ID)
+	}
+
+	query, args, err := sqlx.In(`delete from email_digest where id in (?)`, ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+// Delete the held back notifications of a job
+func DeleteJobDigests(db *sqlx.DB, jobID int64) error {
+	_, err := db.Exec(`delete from email_digest where job_id = ?`, jobID)
+	return err
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"database/sql"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseNotify(t *testing.T) {
+	tests := []struct {
+		form   url.Values
+		notify []string
+		digest bool
+	}{
+		{url.Values{}, nil, false},
+		{url.Values{"notify": {""}}, []string{}, false},
+		{url.Values{"notify": {"", "failed", "bogus", "started"}, "digest": {"true"}}, []string{EventStarted, EventFailed}, true},
+	}
+
+	for _, test := range tests {
+		j := &Job{}
+		ParseNotify(j, test.form)
+		if !reflect.DeepEqual(j.Notify, test.notify) || j.Digest != test.digest {
+			t.Errorf("Incorrect preferences for %v: got %#v %t", test.form, j.Notify, j.Digest)
+		}
+	}
+
+	j := &Job{}
+	if !j.Notifies(EventCompleted) || j.Notifies(EventStarted) {
+		t.Errorf("Jobs without preferences should notify the default events")
+	}
+
+	j.Notify = []string{}
+	if j.Notifies(EventCompleted) {
+		t.Errorf("Jobs with no events chosen should not notify")
+	}
+}
+
+func TestNotifyPreferencesSaved(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{Name: "test", InputData: []byte("test"), FileType: "dat"}
+	job.Notify = []string{EventStarted}
+	job.Digest = true
+	err = QueueJob(db, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := FetchJob(db, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Notify, job.Notify) || !saved.Digest {
+		t.Errorf("Incorrect saved preferences: %#v %t", saved.Notify, saved.Digest)
+	}
+
+	saved.Notify = []string{}
+	err = UpdateParams(db, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err = FetchJob(db, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Notify == nil || len(saved.Notify) != 0 {
+		t.Errorf("Unsubscribed job should not notify any events: %#v", saved.Notify)
+	}
+}
+
+func TestDigests(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := completedJob(t, db, "a")
+	b := completedJob(t, db, "b")
+
+	for _, d := range []*DigestEntry{
+		{JobID: b.ID, Recipient: "y@example.edu", Event: EventQueued},
+		{JobID: a.ID, Recipient: "x@example.edu", Event: EventQueued},
+		{JobID: a.ID, Recipient: "x@example.edu", Event: EventCompleted},
+	} {
+		err := QueueDigest(db, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := FetchDigests(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Incorrect number of digest entries: got %d should be 3", len(entries))
+	}
+	if entries[0].Recipient != "x@example.edu" || entries[1].Event != EventCompleted || entries[1].JobName != "a" || entries[1].JobStatus != "Complete" {
+		t.Errorf("Incorrect digest entry: %+v", entries[1])
+	}
+
+	err = DeleteDigests(db, entries[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DeleteJobDigests(db, b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = FetchDigests(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Digest entries should be deleted: got %d", len(entries))
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := make([]*Job, 2)
+	for i := range jobs {
+		jobs[i] = &Job{Name: "test", InputData: []byte("test"), FileType: "dat"}
+		err := QueueJob(db, jobs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	running, err := FetchNextPending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CancelJob(db, running)
+	if err != sql.ErrNoRows {
+		t.Errorf("Running jobs should not be cancelled: %v", err)
+	}
+
+	err = CancelJob(db, jobs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := FetchJob(db, jobs[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "Cancelled" || job.Completed == nil {
+		t.Errorf("Incorrect status of cancelled job: %s", job.Status)
+	}
+
+	_, err = FetchNextPending(db)
+	if err != sql.ErrNoRows {
+		t.Errorf("Cancelled jobs should not run")
+	}
+}
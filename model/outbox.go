@@ -0,0 +1,239 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Outbox message status
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// An email queued for delivery. Messages are delivered by a background sender
+// which retries failed attempts with exponential backoff
+type OutboxMessage struct {
+	// Unique ID for the message
+	ID int64 `db:"id" json:"id"`
+
+	// Job the notification is for. Zero if not related to a job
+	JobID int64 `db:"job_id" json:"job_id"`
+
+	// Email address of the recipient
+	Recipient string `db:"recipient" json:"recipient"`
+
+	// Subject of the email. Only used for display
+	Subject string `db:"subject" json:"subject"`
+
+	// Complete MIME message including headers
+	Message []byte `db:"message" json:"-"`
+
+	// Delivery status (pending | sent | failed)
+	Status string `db:"status" json:"status"`
+
+	// Number of delivery attempts
+	Attempts int `db:"attempts" json:"attempts"`
+
+	// Error of the last failed attempt
+	LastError string `db:"last_error" json:"last_error"`
+
+	// Time the message was queued
+	Created *time.Time `db:"created" json:"created"`
+
+	// Time of the next delivery attempt for pending messages
+	NextAttempt *time.Time `db:"next_attempt" json:"next_attempt"`
+
+	// Time the message was delivered
+	Sent *time.Time `db:"sent" json:"sent"`
+}
+
+// Queue an email for delivery as soon as possible
+func QueueEmail(db *sqlx.DB, m *OutboxMessage) error {
+	now := time.Now()
+	m.Status = OutboxPending
+	m.Attempts = 0
+	m.Created = &now
+	m.NextAttempt = &now
+
+	res, err := db.NamedExec(`
+        insert into email_outbox (
+            job_id,
+            recipient,
+            subject,
+            message,
+            status,
+            attempts,
+            last_error,
+            created,
+            next_attempt
+        ) values (
+            :job_id,
+            :recipient,
+            :subject,
+            :message,
+            :status,
+            :attempts,
+            :last_error,
+            :created,
+            :next_attempt
+        )`, m)
+	if err != nil {
+		return err
+	}
+
+	m.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Fetch pending messages due for a delivery attempt, oldest first
+func FetchDueEmails(db *sqlx.DB, limit int) ([]*OutboxMessage, error) {
+	messages := []*OutboxMessage{}
+	err := db.Select(&messages, `
+        select
+            id,
+            job_id,
+            recipient,
+            subject,
+            message,
+            status,
+            attempts,
+            last_error,
+            created,
+            next_attempt,
+            sent
+        from email_outbox
+        where status = ? and next_attempt <= ?
+        order by next_attempt asc
+        limit ?`, OutboxPending, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Fetch recent messages without the message content, newest first
+func FetchOutbox(db *sqlx.DB, limit, offset int) ([]*OutboxMessage, error) {
+	messages := []*OutboxMessage{}
+	err := db.Select(&messages, `
+        select
+            id,
+            job_id,
+            recipient,
+            subject,
+            status,
+            attempts,
+            last_error,
+            created,
+            next_attempt,
+            sent
+        from email_outbox
+        order by id desc
+        limit ? offset ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Number of messages keyed by status
+func FetchOutboxStats(db *sqlx.DB) (map[string]int, error) {
+	rows, err := db.Queryx(`select status, count(*) from email_outbox group by status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := map[string]int{
+		OutboxPending: 0,
+		OutboxSent:    0,
+		OutboxFailed:  0,
+	}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats[status] = count
+	}
+
+	return stats, rows.Err()
+}
+
+func (m *OutboxMessage) update(db *sqlx.DB) error {
+	_, err := db.NamedExec(`
+        update email_outbox set
+            status = :status,
+            attempts = :attempts,
+            last_error = :last_error,
+            next_attempt = :next_attempt,
+            sent = :sent
+        where id = :id`, m)
+	return err
+}
+
+// Mark the message as delivered
+func (m *OutboxMessage) MarkSent(db *sqlx.DB) error {
+	now := time.Now()
+	m.Status = OutboxSent
+	m.Attempts++
+	m.LastError = ""
+	m.NextAttempt = nil
+	m.Sent = &now
+
+	return m.update(db)
+}
+
+// Record a failed attempt and schedule the next attempt after delay
+func (m *OutboxMessage) MarkRetry(db *sqlx.DB, reason error, delay time.Duration) error {
+	next := time.Now().Add(delay)
+	m.Attempts++
+	m.LastError = reason.Error()
+	m.NextAttempt = &next
+
+	return m.update(db)
+}
+
+// Record a failed attempt and give up on delivering the message
+func (m *OutboxMessage) MarkFailed(db *sqlx.DB, reason error) error {
+	m.Status = OutboxFailed
+	m.Attempts++
+	m.LastError = reason.Error()
+	m.NextAttempt = nil
+
+	return m.update(db)
+}
+
+// Queue a failed message for delivery again
+func RetryEmail(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`
+        update email_outbox set status = ?, next_attempt = ?
+        where id = ? and status = ?`, OutboxPending, time.Now(), id, OutboxFailed)
+	return err
+}
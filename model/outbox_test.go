@@ -0,0 +1,96 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, to := range []string{"a@example.edu", "b@example.edu"} {
+		err := QueueEmail(db, &OutboxMessage{JobID: 1, Recipient: to, Subject: "test", Message: []byte("message")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, err := FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || string(due[0].Message) != "message" {
+		t.Fatalf("Queued emails should be due: got %d", len(due))
+	}
+
+	err = due[0].MarkSent(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = due[1].MarkRetry(db, errors.New("connection refused"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Sent and delayed emails should not be due: got %d", len(due))
+	}
+
+	outbox, err := FetchOutbox(db, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox) != 2 || outbox[0].Attempts != 1 || outbox[0].LastError != "connection refused" || outbox[0].Status != OutboxPending {
+		t.Fatalf("Incorrect outbox: %+v", outbox[0])
+	}
+
+	err = outbox[0].MarkFailed(db, errors.New("rejected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := FetchOutboxStats(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[OutboxSent] != 1 || stats[OutboxFailed] != 1 || stats[OutboxPending] != 0 {
+		t.Errorf("Incorrect outbox stats: %v", stats)
+	}
+
+	err = RetryEmail(db, outbox[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, err = FetchDueEmails(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != outbox[0].ID {
+		t.Errorf("Retried email should be due")
+	}
+}
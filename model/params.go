@@ -0,0 +1,561 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validation errors keyed by field name. Errors not tied to a single field use
+// the empty name
+type FieldErrors map[string][]string
+
+// Add an error message for field
+func (e FieldErrors) Add(field, msg string) {
+	e[field] = append(e[field], msg)
+}
+
+// All error messages separated by semicolons
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, 0, len(e))
+	for _, f := range fields {
+		msgs = append(msgs, e[f]...)
+	}
+
+	return strings.Join(msgs, ";")
+}
+
+// Methods used to run DENSS
+const (
+	MethodDenss      = "denss"
+	MethodDenssAll   = "denssall"
+	MethodSuperdenss = "superdenss"
+)
+
+// Parameter types
+const (
+	ParamFloat   = "float"
+	ParamInt     = "int"
+	ParamString  = "string"
+	ParamBool    = "bool"
+	ParamChoice  = "choice"
+	ParamIntList = "intlist"
+)
+
+// A possible value of a choice parameter
+type Choice struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// A DENSS job parameter. The registry of parameters drives parsing and
+// validation of submitted jobs, the submit form, the parameter schema served
+// as JSON and the command line flags passed to each method.
+type Param struct {
+	// Name used in forms and JSON
+	Name string `json:"name"`
+
+	// Label shown on the submit form
+	Label string `json:"label"`
+
+	// Parameter type
+	Type string `json:"type"`
+
+	// Allowed range for numeric parameters. Can be changed in the config
+	// with param_limits
+	Min float64 `json:"min,omitempty"`
+	Max float64 `json:"max,omitempty"`
+
+	// Default value. Used when the parameter is not set
+	Default interface{} `json:"default,omitempty"`
+
+	// Allowed values for choice parameters
+	Choices []*Choice `json:"choices,omitempty"`
+
+	// Help text shown on the submit form
+	Help string `json:"help"`
+
+	// Advanced parameters are hidden behind the advanced options on the
+	// submit form and fixed to their defaults with restrict_params
+	Advanced bool `json:"advanced"`
+
+	// Name of the parameter that must be set for this parameter to be used
+	Requires string `json:"requires,omitempty"`
+
+	// Methods that support the parameter
+	Methods []string `json:"methods"`
+
+	// Flag used to pass the parameter to each method. Methods not listed
+	// don't support the parameter and an empty flag means the method handles
+	// the parameter itself. Boolean flags are passed on their own when the
+	// value differs from the default.
+	Flags map[string]string `json:"-"`
+
+	// Pointer to the job field holding the parameter
+	field func(j *Job) interface{}
+
+	// Optional conversion of the value passed with the flag
+	arg func(v string) string
+}
+
+var methods = []string{MethodDenss, MethodDenssAll, MethodSuperdenss}
+
+// Flags for parameters passed the same way to all methods
+func allMethods(flag string) map[string]string {
+	flags := make(map[string]string)
+	for _, m := range methods {
+		flags[m] = flag
+	}
+	return flags
+}
+
+// Registry of DENSS job parameters in the order shown on the submit form
+var Params = []*Param{
+	{
+		Name:  "dmax",
+		Label: "Estimated maximum dimension",
+		Type:  ParamFloat,
+		Min:   1,
+		Max:   10000,
+		Help:  "This is optional and not required",
+		Flags: allMethods("-d"),
+		field: func(j *Job) interface{} { return &j.Dmax },
+	},
+	{
+		// superdenss assumes the data is in angstrom
+		Name:  "units",
+		Label: "Angular units",
+		Type:  ParamChoice,
+		Choices: []*Choice{
+			{Value: "a", Label: "angstrom"},
+			{Value: "nm", Label: "nanometer"},
+		},
+		Default: "a",
+		Flags: map[string]string{
+			MethodDenss:    "--units",
+			MethodDenssAll: "--units",
+		},
+		field: func(j *Job) interface{} { return &j.Units },
+	},
+	{
+		Name:  "mode",
+		Label: "Mode",
+		Type:  ParamChoice,
+		Choices: []*Choice{
+			{Value: "fast", Label: "Fast"},
+			{Value: "slow", Label: "Slow"},
+			{Value: "membrane", Label: "Membrane"},
+		},
+		Default: "slow",
+		Flags:   allMethods("--mode"),
+		field:   func(j *Job) interface{} { return &j.Mode },
+		arg:     strings.ToUpper,
+	},
+	{
+		Name:  "ncs",
+		Label: "Symmetry (N-Fold)",
+		Type:  ParamInt,
+		Min:   1,
+		Max:   500,
+		Help:  "Rotational symmetry",
+		Flags: allMethods("-ncs"),
+		field: func(j *Job) interface{} { return &j.Symmetry },
+	},
+	{
+		Name:  "ncs_axis",
+		Label: "Symmetry Axis",
+		Type:  ParamChoice,
+		Choices: []*Choice{
+			{Value: "1", Label: "Largest"},
+			{Value: "2", Label: "Middle"},
+			{Value: "3", Label: "Smallest"},
+		},
+		Default:  int64(1),
+		Requires: "ncs",
+		Flags:    allMethods("-ncs_axis"),
+		field:    func(j *Job) interface{} { return &j.SymmetryAxis },
+	},
+	{
+		Name:     "ncs_steps",
+		Label:    "Symmetry Steps",
+		Type:     ParamIntList,
+		Help:     "Space separated list",
+		Requires: "ncs",
+		Flags:    allMethods("-ncs_steps"),
+		field:    func(j *Job) interface{} { return &j.SymmetrySteps },
+	},
+	{
+		// Enantiomer selection is on by default in denss.all.py. A single
+		// denss.py run has no enantiomers to select from so connectivity
+		// enforcement is turned off instead. superdenss takes the enantiomer
+		// flag outside of the denss arguments
+		Name:    "enantiomer",
+		Label:   "Enantiomer Selection",
+		Type:    ParamBool,
+		Default: true,
+		Flags: map[string]string{
+			MethodDenss:      "--enforce_connectivity_off",
+			MethodDenssAll:   "-en_off",
+			MethodSuperdenss: "",
+		},
+		field: func(j *Job) interface{} { return &j.Enantiomer },
+	},
+	{
+		// Raw data is fitted with denss.fit_data.py before running DENSS
+		Name:    "fit",
+		Label:   "Fit Raw Data",
+		Type:    ParamBool,
+		Default: false,
+		Help:    "Fit a smooth curve to 3-column .dat files with denss.fit_data.py before the reconstruction",
+		Flags:   allMethods(""),
+		field:   func(j *Job) interface{} { return &j.Fit },
+	},
+	{
+		Name:     "oversampling",
+		Label:    "Oversampling",
+		Type:     ParamFloat,
+		Min:      2,
+		Max:      50,
+		Default:  3.0,
+		Help:     "Sampling ratio of the real space box to the particle size",
+		Advanced: true,
+		Flags:    allMethods("--oversampling"),
+		field:    func(j *Job) interface{} { return &j.Oversampling },
+	},
+	{
+		Name:     "voxel",
+		Label:    "Voxel Size",
+		Type:     ParamFloat,
+		Min:      1,
+		Max:      100,
+		Help:     "Size of each voxel in angstrom. Calculated by DENSS if not given",
+		Advanced: true,
+		Flags:    allMethods("--voxel"),
+		field:    func(j *Job) interface{} { return &j.VoxelSize },
+	},
+	{
+		Name:     "nsamples",
+		Label:    "Number of Samples",
+		Type:     ParamInt,
+		Min:      2,
+		Max:      500,
+		Help:     "Number of grid points along each dimension. Calculated by DENSS if not given",
+		Advanced: true,
+		Flags:    allMethods("--nsamples"),
+		field:    func(j *Job) interface{} { return &j.NumSamples },
+	},
+	{
+		Name:     "electrons",
+		Label:    "Number of Electrons",
+		Type:     ParamInt,
+		Min:      1,
+		Max:      100000000,
+		Default:  int64(10000),
+		Help:     "Number of electrons in the particle, used to scale the density",
+		Advanced: true,
+		Flags:    allMethods("--ne"),
+		field:    func(j *Job) interface{} { return &j.Electrons },
+	},
+	{
+		Name:     "steps",
+		Label:    "Maximum Steps",
+		Type:     ParamInt,
+		Min:      100,
+		Max:      10000,
+		Default:  int64(3000),
+		Help:     "Maximum number of iterations of each DENSS run",
+		Advanced: true,
+		Flags:    allMethods("--steps"),
+		field:    func(j *Job) interface{} { return &j.MaxSteps },
+	},
+	{
+		// denss.py runs once per map so runs are handled by runDenss and
+		// superdenss takes the run count outside of the denss arguments
+		Name:     "nmaps",
+		Label:    "Number of Runs",
+		Type:     ParamInt,
+		Min:      2,
+		Max:      20,
+		Default:  int64(20),
+		Help:     "Number of density maps reconstructed and averaged",
+		Advanced: true,
+		Flags: map[string]string{
+			MethodDenss:      "",
+			MethodDenssAll:   "--nmaps",
+			MethodSuperdenss: "",
+		},
+		field: func(j *Job) interface{} { return &j.MaxRuns },
+	},
+}
+
+func init() {
+	for _, p := range Params {
+		for _, m := range methods {
+			if _, ok := p.Flags[m]; ok {
+				p.Methods = append(p.Methods, m)
+			}
+		}
+	}
+}
+
+// Lookup parameter by name. Returns nil if not found
+func LookupParam(name string) *Param {
+	for _, p := range Params {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the parameter is set for the job. Numeric and string
+// parameters are set when non-zero, boolean parameters when they differ from
+// the default.
+func (p *Param) IsSet(j *Job) bool {
+	switch v := p.field(j).(type) {
+	case *float64:
+		return *v != 0
+	case *int64:
+		return *v != 0
+	case *string:
+		return *v != ""
+	case *bool:
+		def, _ := p.Default.(bool)
+		return *v != def
+	}
+
+	return false
+}
+
+// Value of the parameter for the job formatted as passed to DENSS
+func (p *Param) Value(j *Job) string {
+	switch v := p.field(j).(type) {
+	case *float64:
+		return fmt.Sprintf("%.4f", *v)
+	case *int64:
+		return fmt.Sprintf("%d", *v)
+	case *string:
+		return *v
+	case *bool:
+		return strconv.FormatBool(*v)
+	}
+
+	return ""
+}
+
+// Value of the parameter for the job formatted for display. Floats are shown
+// without trailing zeros
+func (p *Param) Display(j *Job) string {
+	if v, ok := p.field(j).(*float64); ok {
+		return formatLimit(*v)
+	}
+
+	return p.Value(j)
+}
+
+// Default value formatted for the submit form
+func (p *Param) DefaultString() string {
+	if p.Default == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", p.Default)
+}
+
+// Parse the value of the parameter from a string and set it on the job. An
+// empty string leaves numeric and string parameters unset.
+func (p *Param) Parse(j *Job, s string) error {
+	s = strings.TrimSpace(s)
+
+	switch v := p.field(j).(type) {
+	case *float64:
+		if s == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("Please provide a number for %s", p.Label)
+		}
+		*v = f
+	case *int64:
+		if s == "" {
+			return nil
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Please provide an integer for %s", p.Label)
+		}
+		*v = i
+	case *string:
+		*v = strings.Join(strings.Fields(s), " ")
+	case *bool:
+		if s == "" {
+			*v = false
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("Please provide true or false for %s", p.Label)
+		}
+		*v = b
+	}
+
+	return nil
+}
+
+// Validate the value of the parameter for the job. Unset parameters are valid
+func (p *Param) Validate(j *Job) error {
+	if !p.IsSet(j) {
+		return nil
+	}
+
+	switch p.Type {
+	case ParamFloat, ParamInt:
+		var f float64
+		switch v := p.field(j).(type) {
+		case *float64:
+			f = *v
+		case *int64:
+			f = float64(*v)
+		}
+		if f < p.Min || f > p.Max {
+			return fmt.Errorf("%s should be between %s and %s", p.Label, formatLimit(p.Min), formatLimit(p.Max))
+		}
+	case ParamChoice:
+		val := p.Value(j)
+		labels := make([]string, 0, len(p.Choices))
+		for _, c := range p.Choices {
+			if c.Value == val {
+				return nil
+			}
+			labels = append(labels, c.Label)
+		}
+		return fmt.Errorf("%s should be one of %s", p.Label, strings.Join(labels, ", "))
+	case ParamIntList:
+		for _, n := range strings.Fields(p.Value(j)) {
+			if _, err := strconv.Atoi(n); err != nil {
+				return fmt.Errorf("%s should be a number: %s", p.Label, n)
+			}
+		}
+	}
+
+	return nil
+}
+
+func formatLimit(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Returns true if method supports the parameter
+func (p *Param) Supports(method string) bool {
+	_, ok := p.Flags[method]
+	return ok
+}
+
+// Returns true if the parameter is used for the job: it is set and the
+// parameter it requires is set
+func (p *Param) IsUsed(j *Job) bool {
+	if !p.IsSet(j) {
+		return false
+	}
+
+	if p.Requires != "" {
+		if req := LookupParam(p.Requires); req != nil && !req.IsSet(j) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Command line arguments passing the parameter of the job to method. Returns
+// nil if the parameter is not used or the method handles it itself.
+func (p *Param) Args(j *Job, method string) []string {
+	flag := p.Flags[method]
+	if flag == "" || !p.IsUsed(j) {
+		return nil
+	}
+
+	if p.Type == ParamBool {
+		return []string{flag}
+	}
+
+	val := p.Value(j)
+	if p.arg != nil {
+		val = p.arg(val)
+	}
+
+	return append([]string{flag}, strings.Fields(val)...)
+}
+
+// Set the default value of the given parameters not set for the job. Boolean
+// parameters always have a value so their defaults only apply to the submit
+// form.
+func SetDefaults(j *Job, params []*Param) {
+	for _, p := range params {
+		if p.Default == nil || p.IsSet(j) {
+			continue
+		}
+
+		switch v := p.field(j).(type) {
+		case *float64:
+			*v = p.Default.(float64)
+		case *int64:
+			*v = p.Default.(int64)
+		case *string:
+			*v = p.Default.(string)
+		}
+	}
+}
+
+// Parse the given parameters from form values and set them on the job.
+// Errors are added to errs keyed by parameter name.
+func ParseParams(j *Job, form url.Values, params []*Param, errs FieldErrors) {
+	for _, p := range params {
+		err := p.Parse(j, form.Get(p.Name))
+		if err != nil {
+			errs.Add(p.Name, err.Error())
+		}
+	}
+}
+
+// Validate the given parameters of the job. Parameters that already have
+// errors in errs are skipped and new errors are added keyed by parameter name.
+func ValidateParams(j *Job, params []*Param, errs FieldErrors) {
+	for _, p := range params {
+		if _, ok := errs[p.Name]; ok {
+			continue
+		}
+
+		err := p.Validate(j)
+		if err != nil {
+			errs.Add(p.Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseParams(t *testing.T) {
+	form := url.Values{
+		"dmax":       {"50.5"},
+		"mode":       {"fast"},
+		"ncs":        {"2"},
+		"ncs_steps":  {" 3000  5000 "},
+		"enantiomer": {"1"},
+		"nmaps":      {"10"},
+	}
+
+	job := &Job{}
+	errs := make(FieldErrors)
+	basic := make([]*Param, 0)
+	for _, p := range Params {
+		if !p.Advanced {
+			basic = append(basic, p)
+		}
+	}
+	ParseParams(job, form, basic, errs)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	if job.Dmax != 50.5 || job.Mode != "fast" || job.Symmetry != 2 || job.SymmetrySteps != "3000 5000" || !job.Enantiomer {
+		t.Errorf("Incorrect params parsed: %+v", job.ExtraParams)
+	}
+
+	if job.MaxRuns != 0 {
+		t.Errorf("Params not given should be skipped: got %d", job.MaxRuns)
+	}
+
+	SetDefaults(job, Params)
+	if job.MaxRuns != 20 || job.Units != "a" || job.Mode != "fast" || job.SymmetryAxis != 1 {
+		t.Errorf("Incorrect defaults: runs=%d units=%s mode=%s axis=%d", job.MaxRuns, job.Units, job.Mode, job.SymmetryAxis)
+	}
+
+	ValidateParams(job, Params, errs)
+	if len(errs) != 0 {
+		t.Errorf("Valid params failed validation: %s", errs)
+	}
+
+	job = &Job{}
+	ParseParams(job, url.Values{"dmax": {"big"}, "ncs": {"x"}}, Params, errs)
+	ValidateParams(job, Params, errs)
+	if len(errs["dmax"]) != 1 || len(errs["ncs"]) != 1 {
+		t.Errorf("Invalid numbers should have one error each: got %v", errs)
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	tests := map[string]url.Values{
+		"dmax":      {"dmax": {"0.5"}},
+		"mode":      {"mode": {"medium"}},
+		"ncs_axis":  {"ncs_axis": {"4"}},
+		"ncs_steps": {"ncs_steps": {"3000 x"}},
+		"nmaps":     {"nmaps": {"30"}},
+	}
+
+	for name, form := range tests {
+		job := &Job{}
+		errs := make(FieldErrors)
+		ParseParams(job, form, Params, errs)
+		if len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		SetDefaults(job, Params)
+
+		ValidateParams(job, Params, errs)
+		if len(errs[name]) == 0 {
+			t.Errorf("Invalid %s passed validation", name)
+		}
+	}
+}
+
+func TestParamArgs(t *testing.T) {
+	job := &Job{}
+	SetDefaults(job, Params)
+
+	if args := LookupParam("ncs_axis").Args(job, MethodDenssAll); args != nil {
+		t.Errorf("Symmetry axis should require symmetry: got %v", args)
+	}
+
+	job.Symmetry = 2
+	job.SymmetrySteps = "3000 5000"
+	if args := LookupParam("ncs_steps").Args(job, MethodDenssAll); !reflect.DeepEqual(args, []string{"-ncs_steps", "3000", "5000"}) {
+		t.Errorf("Incorrect symmetry steps arguments: got %v", args)
+	}
+
+	if args := LookupParam("mode").Args(job, MethodDenss); !reflect.DeepEqual(args, []string{"--mode", "SLOW"}) {
+		t.Errorf("Incorrect mode arguments: got %v", args)
+	}
+
+	if args := LookupParam("enantiomer").Args(job, MethodDenssAll); !reflect.DeepEqual(args, []string{"-en_off"}) {
+		t.Errorf("Incorrect enantiomer arguments: got %v", args)
+	}
+
+	if args := LookupParam("nmaps").Args(job, MethodDenss); args != nil {
+		t.Errorf("Runs should be handled by denss method: got %v", args)
+	}
+
+	for _, p := range Params {
+		if len(p.Methods) == 0 {
+			t.Errorf("Parameter %s is not supported by any method", p.Name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A named set of parameter values defined in the config. With
+// restrict_params jobs can only be submitted with one of the presets
+type Preset struct {
+	// Name shown on the submit form and recorded on the job
+	Name string `mapstructure:"name" json:"name"`
+
+	// Optional description shown on the submit form
+	Description string `mapstructure:"description" json:"description"`
+
+	// Parameter values keyed by parameter name. Parameters not given use
+	// their defaults
+	Params map[string]interface{} `mapstructure:"params" json:"params"`
+}
+
+// Set the parameters of the preset on the job
+func (p *Preset) Apply(j *Job) error {
+	for name, val := range p.Params {
+		param := LookupParam(name)
+		if param == nil {
+			return fmt.Errorf("Unknown parameter %s in preset %s", name, p.Name)
+		}
+
+		err := param.Parse(j, presetValue(val))
+		if err != nil {
+			return fmt.Errorf("Preset %s: %s", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Format a value from the config as it would be entered on the submit form.
+// Lists are space separated
+func presetValue(val interface{}) string {
+	if list, ok := val.([]interface{}); ok {
+		parts := make([]string, 0, len(list))
+		for _, v := range list {
+			parts = append(parts, fmt.Sprintf("%v", v))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	return fmt.Sprintf("%v", val)
+}
+
+// Check the preset has a name and valid parameter values
+func (p *Preset) Validate() error {
+	if p.Name == "" {
+		return errors.New("Preset name is required")
+	}
+
+	j := &Job{}
+	err := p.Apply(j)
+	if err != nil {
+		return err
+	}
+
+	SetDefaults(j, Params)
+
+	errs := make(FieldErrors)
+	ValidateParams(j, Params, errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("Preset %s: %s", p.Name, errs)
+	}
+
+	return nil
+}
+
+// Find preset by name. Returns nil if not found
+func FindPreset(presets []*Preset, name string) *Preset {
+	for _, p := range presets {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
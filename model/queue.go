@@ -0,0 +1,259 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Number of completed jobs used to compute the expected runtime
+	HistoryJobs = 200
+)
+
+// Number of jobs waiting in the queue and running
+type QueueStats struct {
+	Pending int `db:"pending" json:"pending"`
+	Running int `db:"running" json:"running"`
+}
+
+// Runtimes in seconds of recently completed jobs keyed by mode and number of
+// runs
+type runtimeHistory struct {
+	byParams map[string][]float64
+	all      []float64
+}
+
+func historyKey(j *Job) string {
+	return fmt.Sprintf("%s/%d", j.Mode, j.MaxRuns)
+}
+
+// Fetch the number of pending and running jobs
+func FetchQueueStats(db *sqlx.DB) (*QueueStats, error) {
+	stats := &QueueStats{}
+	err := db.Get(stats, `
+        select
+            coalesce(sum(case when status_id = ? then 1 else 0 end), 0) as pending,
+            coalesce(sum(case when status_id = ? then 1 else 0 end), 0) as running
+        from job
+        where status_id in (?, ?)`, StatusPending, StatusRunning, StatusPending, StatusRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Number of jobs with a given status
+type StatusCount struct {
+	Status string `db:"status"`
+	Count  int    `db:"count"`
+}
+
+// Fetch the number of jobs with each status including statuses without any
+// jobs
+func FetchStatusCounts(db *sqlx.DB) ([]*StatusCount, error) {
+	counts := []*StatusCount{}
+	err := db.Select(&counts, `
+        select
+            s.status,
+            count(j.id) as count
+        from job_status as s
+        left join job as j on j.status_id = s.id
+        group by s.id, s.status
+        order by s.id`)
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// Fetch the submission time of the oldest pending job. Returns sql.ErrNoRows
+// if no jobs are pending
+func FetchOldestPending(db *sqlx.DB) (time.Time, error) {
+	var submitted time.Time
+	err := db.Get(&submitted, `
+        select submitted
+        from job
+        where status_id = ?
+        order by submitted asc
+        limit 1`, StatusPending)
+
+	return submitted, err
+}
+
+func fetchRuntimeHistory(db *sqlx.DB) (*runtimeHistory, error) {
+	jobs := []*Job{}
+	err := db.Select(&jobs, `
+        select
+            j.id,
+            j.max_runs,
+            j.params,
+            j.started,
+            j.completed
+        from job as j
+        where j.status_id = ? and j.started is not null and j.completed is not null
+        order by j.completed desc
+        limit ?`, StatusComplete, HistoryJobs)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &runtimeHistory{byParams: make(map[string][]float64)}
+	for _, j := range jobs {
+		if err := j.UnmarshallParams(); err != nil {
+			continue
+		}
+
+		seconds := j.Completed.Sub(*j.Started).Seconds()
+		if seconds <= 0 {
+			continue
+		}
+
+		key := historyKey(j)
+		h.byParams[key] = append(h.byParams[key], seconds)
+		h.all = append(h.all, seconds)
+	}
+
+	return h, nil
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// Expected runtime of the job. Uses the median runtime of completed jobs with
+// the same mode and number of runs or of all completed jobs if there are none.
+// Returns false without any completed jobs
+func (h *runtimeHistory) expected(j *Job) (time.Duration, bool) {
+	runtimes := h.byParams[historyKey(j)]
+	if len(runtimes) == 0 {
+		runtimes = h.all
+	}
+	if len(runtimes) == 0 {
+		return 0, false
+	}
+
+	return time.Duration(median(runtimes) * float64(time.Second)), true
+}
+
+// Time left of a running job. Jobs running longer than expected are assumed
+// to finish any moment
+func (h *runtimeHistory) remaining(j *Job, now time.Time) time.Duration {
+	d, ok := h.expected(j)
+	if !ok || j.Started == nil {
+		return 0
+	}
+
+	left := j.Started.Add(d).Sub(now)
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+// Set the queue position and ETA of a pending or running job. Pending jobs
+// are run in order of submission by the given number of workers. The ETA is
+// left unset if no jobs have completed yet
+func SetQueueInfo(db *sqlx.DB, job *Job, workers int) error {
+	if job.StatusID != StatusPending && job.StatusID != StatusRunning {
+		return nil
+	}
+
+	h, err := fetchRuntimeHistory(db)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expected, ok := h.expected(job)
+
+	if job.StatusID == StatusRunning {
+		if ok {
+			eta := now.Add(h.remaining(job, now))
+			job.ETA = &eta
+		}
+		return nil
+	}
+
+	// Same ordering as FetchNextPending with ties broken by id
+	ahead := []*Job{}
+	err = db.Select(&ahead, `
+        select
+            j.id,
+            j.max_runs,
+            j.params
+        from job as j
+        where j.status_id = ? and (j.submitted < ? or (j.submitted = ? and j.id < ?))`,
+		StatusPending, job.Submitted, job.Submitted, job.ID)
+	if err != nil {
+		return err
+	}
+
+	job.QueuePosition = len(ahead) + 1
+
+	if !ok {
+		return nil
+	}
+
+	running := []*Job{}
+	err = db.Select(&running, `
+        select
+            j.id,
+            j.max_runs,
+            j.params,
+            j.started
+        from job as j
+        where j.status_id = ?`, StatusRunning)
+	if err != nil {
+		return err
+	}
+
+	if workers < len(running) {
+		workers = len(running)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Jobs with invalid params use the runtime of all completed jobs
+	var wait time.Duration
+	for _, j := range running {
+		j.UnmarshallParams()
+		wait += h.remaining(j, now)
+	}
+	for _, j := range ahead {
+		j.UnmarshallParams()
+		d, _ := h.expected(j)
+		wait += d
+	}
+
+	eta := now.Add(wait/time.Duration(workers) + expected)
+	job.ETA = &eta
+
+	return nil
+}
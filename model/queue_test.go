@@ -0,0 +1,155 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestQueueInfo(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Completed jobs taking 10 minutes each
+	for i := 0; i < 3; i++ {
+		c := completedJob(t, db, "history")
+		_, err = db.Exec(db.Rebind(`update job set started = ? where id = ?`), time.Now().Add(-10*time.Minute), c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending := make([]*Job, 3)
+	for i := range pending {
+		pending[i] = &Job{Name: "pending", InputData: []byte("test"), FileType: "dat"}
+		err := QueueJob(db, pending[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	running, err := FetchNextPending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.ID != pending[0].ID {
+		t.Fatalf("Jobs should run in order of submission")
+	}
+
+	stats, err := FetchQueueStats(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending != 2 || stats.Running != 1 {
+		t.Errorf("Incorrect queue stats: %+v", stats)
+	}
+
+	last := pending[2]
+	err = SetQueueInfo(db, last, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.QueuePosition != 2 {
+		t.Errorf("Incorrect queue position: got %d should be 2", last.QueuePosition)
+	}
+
+	// Remaining 10 minutes of the running job, 10 minutes for the job ahead
+	// and 10 minutes for the job itself
+	if last.ETA == nil || last.ETA.Sub(time.Now()) < 29*time.Minute || last.ETA.Sub(time.Now()) > 31*time.Minute {
+		t.Errorf("Incorrect ETA: %v", last.ETA)
+	}
+
+	err = SetQueueInfo(db, running, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.QueuePosition != 0 || running.ETA == nil || running.ETA.Sub(time.Now()) > 11*time.Minute {
+		t.Errorf("Incorrect ETA for running job: %v", running.ETA)
+	}
+
+	// Jobs submitted at the same time run in the order of their queue
+	// position
+	_, err = db.Exec(db.Rebind(`update job set submitted = ? where id in (?, ?)`), time.Now(), pending[1].ID, pending[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetQueueInfo(db, pending[1], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := FetchNextPending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending[1].QueuePosition != 1 || next.ID != pending[1].ID {
+		t.Errorf("Job at queue position %d should run next: got job %d", pending[1].QueuePosition, next.ID)
+	}
+}
+
+func TestStatusCounts(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = FetchOldestPending(db)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected no pending jobs got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := QueueJob(db, &Job{Name: "pending", InputData: []byte("test"), FileType: "dat"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	completedJob(t, db, "done")
+
+	first, err := FetchNextPending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := FetchStatusCounts(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byStatus := make(map[string]int)
+	for _, c := range counts {
+		byStatus[c.Status] = c.Count
+	}
+	if byStatus["Pending"] != 1 || byStatus["Running"] != 1 || byStatus["Complete"] != 1 {
+		t.Errorf("Incorrect status counts: %v", byStatus)
+	}
+	if _, ok := byStatus["Error"]; !ok {
+		t.Errorf("Statuses without jobs should be included: %v", byStatus)
+	}
+
+	oldest, err := FetchOldestPending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldest.Before(*first.Submitted) {
+		t.Errorf("Oldest pending job should be submitted after the running job")
+	}
+}
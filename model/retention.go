@@ -0,0 +1,117 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Status of jobs that are no longer pending or running
+var finishedStatus = []int{StatusComplete, StatusError, StatusCancelled}
+
+// Fetch finished jobs completed between after and before whose submitter has
+// not been notified that the results are expiring
+func FetchExpiringJobs(db *sqlx.DB, after, before time.Time) ([]*Job, error) {
+	query, args, err := sqlx.In(`
+        select
+            j.id,
+            j.status_id,
+            s.status,
+            j.name,
+            j.token,
+            j.email,
+            j.params,
+            coalesce(j.webhook_url, '') as webhook_url,
+            coalesce(j.webhook_secret, '') as webhook_secret,
+            j.submitted,
+            j.started,
+            j.completed
+        from job as j
+        join job_status s on s.id = j.status_id
+        where j.status_id in (?) and j.completed > ? and j.completed <= ?
+        order by j.completed asc`, finishedStatus, after, before)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*Job{}
+	err = db.Select(&jobs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	expiring := make([]*Job, 0, len(jobs))
+	for _, j := range jobs {
+		err = j.UnmarshallParams()
+		if err != nil {
+			return nil, err
+		}
+		if !j.ExpiryNotified {
+			expiring = append(expiring, j)
+		}
+	}
+
+	return expiring, nil
+}
+
+// Delete finished jobs completed before the given time along with their
+// images, comparisons, held back notifications and webhook deliveries. Returns the number of
+// jobs deleted
+func ExpireJobs(db *sqlx.DB, before time.Time) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids []int64
+	query, args, err := sqlx.In(`select id from job where status_id in (?) and completed <= ?`, finishedStatus, before)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Select(&ids, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, q := range []string{
+		`delete from job_image where job_id in (?)`,
+		`delete from email_digest where job_id in (?)`,
+		`delete from webhook_delivery where job_id in (?)`,
+		`delete from comparison where job_a in (?)`,
+		`delete from comparison where job_b in (?)`,
+		`delete from job where id in (?)`,
+	} {
+		query, args, err := sqlx.In(q, ids)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(query, args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(ids)), tx.Commit()
+}
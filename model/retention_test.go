@@ -0,0 +1,104 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestExpireJobs(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := completedJob(t, db, "old")
+	expiring := completedJob(t, db, "expiring")
+	recent := completedJob(t, db, "recent")
+	for job, days := range map[*Job]int{old: 40, expiring: 28} {
+		_, err = db.Exec(`update job set completed = ? where id = ?`, now.AddDate(0, 0, -days), job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending := &Job{Name: "pending", InputData: []byte("test"), FileType: "dat"}
+	err = QueueJob(db, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = QueueComparison(db, old, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 30 day retention with notice 3 days before
+	cutoff := now.AddDate(0, 0, -30)
+	jobs, err := FetchExpiringJobs(db, cutoff, now.AddDate(0, 0, -27))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != expiring.ID {
+		t.Fatalf("Incorrect expiring jobs: got %d", len(jobs))
+	}
+
+	jobs[0].ExpiryNotified = true
+	err = UpdateParams(db, jobs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err = FetchExpiringJobs(db, cutoff, now.AddDate(0, 0, -27))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("Notified jobs should not be expiring again")
+	}
+
+	n, err := ExpireJobs(db, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Incorrect number of expired jobs: got %d should be 1", n)
+	}
+
+	_, err = FetchJob(db, old.Token)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expired job should be deleted")
+	}
+
+	var comparisons int
+	err = db.Get(&comparisons, `select count(*) from comparison`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparisons != 0 {
+		t.Errorf("Comparisons of expired jobs should be deleted")
+	}
+
+	for _, job := range []*Job{expiring, recent, pending} {
+		if _, err := FetchJob(db, job.Token); err != nil {
+			t.Errorf("Job %s should not be deleted: %s", job.Name, err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Webhook delivery status
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// A webhook from the config receiving the events of all jobs
+type Webhook struct {
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret"`
+}
+
+// Check the webhook has an http(s) URL and a signing secret
+func (w *Webhook) Validate() error {
+	err := ValidateWebhookURL(w.URL)
+	if err != nil {
+		return err
+	}
+
+	if w.Secret == "" {
+		return errors.New("Webhook " + w.URL + " is missing a secret")
+	}
+
+	return nil
+}
+
+var (
+	// Loopback, private, link-local, multicast and other special purpose
+	// networks job webhooks can't connect to
+	privateNets = parseCIDRs(
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16",
+		"198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8")
+
+	ErrPrivateWebhook = errors.New("Webhook URL must not point to a local or private network address")
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// Returns true if the address is a public unicast address. IPv4 addresses
+// mapped to IPv6 are checked as IPv4
+func PublicIP(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check the URL is an absolute http or https URL
+func ValidateWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Webhook URL must be an http or https URL")
+	}
+
+	return nil
+}
+
+// Check the host of the webhook URL isn't localhost or a non public address.
+// Host names can resolve to any address so the address is checked again when
+// connecting
+func ValidatePublicWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateWebhook
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return ErrPrivateWebhook
+	}
+
+	return nil
+}
+
+// A signed POST of a job event to a webhook. Deliveries are made by a
+// background sender which retries failed attempts with exponential backoff
+type WebhookDelivery struct {
+	// Unique ID for the delivery
+	ID int64 `db:"id" json:"id"`
+
+	// Job the event is for
+	JobID int64 `db:"job_id" json:"-"`
+
+	// URL of the webhook
+	URL string `db:"url" json:"url"`
+
+	// Event name
+	Event string `db:"event" json:"event"`
+
+	// JSON request body
+	Payload []byte `db:"payload" json:"-"`
+
+	// HMAC-SHA256 signature of the payload
+	Signature string `db:"signature" json:"-"`
+
+	// Whether the webhook is from the config. Only deliveries to the webhook
+	// of the job are shown to the submitter
+	Global bool `db:"global" json:"-"`
+
+	// Delivery status (pending | delivered | failed)
+	Status string `db:"status" json:"status"`
+
+	// Number of delivery attempts
+	Attempts int `db:"attempts" json:"attempts"`
+
+	// HTTP status code of the last attempt. Zero if no response was received
+	ResponseCode int `db:"response_code" json:"response_code"`
+
+	// Error of the last failed attempt
+	LastError string `db:"last_error" json:"last_error"`
+
+	// Time the delivery was queued
+	Created *time.Time `db:"created" json:"created"`
+
+	// Time of the next attempt for pending deliveries
+	NextAttempt *time.Time `db:"next_attempt" json:"next_attempt"`
+
+	// Time the webhook accepted the delivery
+	Delivered *time.Time `db:"delivered" json:"delivered"`
+}
+
+const webhookColumns = `
+            id,
+            job_id,
+            url,
+            event,
+            payload,
+            signature,
+            global,
+            status,
+            attempts,
+            response_code,
+            last_error,
+            created,
+            next_attempt,
+            delivered`
+
+// Queue a webhook delivery as soon as possible
+func QueueWebhook(db *sqlx.DB, d *WebhookDelivery) error {
+	now := time.Now()
+	d.Status = WebhookPending
+	d.Attempts = 0
+	d.Created = &now
+	d.NextAttempt = &now
+
+	res, err := db.NamedExec(`
+        insert into webhook_delivery (
+            job_id,
+            url,
+            event,
+            payload,
+            signature,
+            global,
+            status,
+            attempts,
+            response_code,
+            last_error,
+            created,
+            next_attempt
+        ) values (
+            :job_id,
+            :url,
+            :event,
+            :payload,
+            :signature,
+            :global,
+            :status,
+            :attempts,
+            :response_code,
+            :last_error,
+            :created,
+            :next_attempt
+        )`, d)
+	if err != nil {
+		return err
+	}
+
+	d.ID, err = res.LastInsertId()
+	return err
+}
+
+// Fetch pending deliveries due for an attempt, oldest first
+func FetchDueWebhooks(db *sqlx.DB, limit int) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+	err := db.Select(&deliveries, `select `+webhookColumns+`
+        from webhook_delivery
+        where status = ? and next_attempt <= ?
+        order by next_attempt asc
+        limit ?`, WebhookPending, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Fetch the deliveries to the webhook of the job, oldest first
+func FetchJobWebhooks(db *sqlx.DB, jobID int64) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+	err := db.Select(&deliveries, `select `+webhookColumns+`
+        from webhook_delivery
+        where job_id = ? and global = ?
+        order by id asc`, jobID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (d *WebhookDelivery) update(db *sqlx.DB) error {
+	_, err := db.NamedExec(`
+        update webhook_delivery set
+            status = :status,
+            attempts = :attempts,
+            response_code = :response_code,
+            last_error = :last_error,
+            next_attempt = :next_attempt,
+            delivered = :delivered
+        where id = :id`, d)
+	return err
+}
+
+// Mark the delivery as accepted by the webhook
+func (d *WebhookDelivery) MarkDelivered(db *sqlx.DB, code int) error {
+	now := time.Now()
+	d.Status = WebhookDelivered
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastError = ""
+	d.NextAttempt = nil
+	d.Delivered = &now
+
+	return d.update(db)
+}
+
+// Record a failed attempt and schedule the next attempt after delay
+func (d *WebhookDelivery) MarkRetry(db *sqlx.DB, code int, reason error, delay time.Duration) error {
+	next := time.Now().Add(delay)
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastError = reason.Error()
+	d.NextAttempt = &next
+
+	return d.update(db)
+}
+
+// Record a failed attempt and give up on the delivery
+func (d *WebhookDelivery) MarkFailed(db *sqlx.DB, code int, reason error) error {
+	d.Status = WebhookFailed
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastError = reason.Error()
+	d.NextAttempt = nil
+
+	return d.update(db)
+}
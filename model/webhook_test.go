@@ -0,0 +1,131 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	tests := map[string]bool{
+		"https://lims.example.edu/hook": true,
+		"http://localhost:8000":         true,
+		"ftp://example.edu":             false,
+		"/relative":                     false,
+		"not a url":                     false,
+	}
+
+	for u, ok := range tests {
+		err := (&Webhook{URL: u, Secret: "secret"}).Validate()
+		if (err == nil) != ok {
+			t.Errorf("Incorrect validation of %s: %v", u, err)
+		}
+	}
+
+	if err := (&Webhook{URL: "https://example.edu"}).Validate(); err == nil {
+		t.Errorf("Webhooks without a secret should be invalid")
+	}
+}
+
+func TestValidatePublicWebhookURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://lims.example.edu/hook":    true,
+		"http://93.184.216.34/hook":        true,
+		"http://[2606:2800:220:1::1]/hook": true,
+		"http://localhost:8000":            false,
+		"http://LOCALHOST./":               false,
+		"http://app.localhost/":            false,
+		"http://127.0.0.1:8080/":           false,
+		"http://169.254.169.254/latest/":   false,
+		"http://10.1.2.3/":                 false,
+		"http://172.20.0.1/":               false,
+		"http://192.168.1.1/":              false,
+		"http://0.0.0.0/":                  false,
+		"http://[::1]/":                    false,
+		"http://[::ffff:127.0.0.1]/":       false,
+		"http://[fd00::1]/":                false,
+		"http://[fe80::1]/":                false,
+	}
+
+	for u, ok := range tests {
+		err := ValidatePublicWebhookURL(u)
+		if (err == nil) != ok {
+			t.Errorf("Incorrect validation of %s: %v", u, err)
+		}
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []*WebhookDelivery{
+		{JobID: 1, URL: "https://global.example.edu", Event: EventQueued, Payload: []byte("{}"), Global: true},
+		{JobID: 1, URL: "https://job.example.edu", Event: EventQueued, Payload: []byte("{}"), Signature: "sha256=abc"},
+		{JobID: 2, URL: "https://job.example.edu", Event: EventQueued, Payload: []byte("{}")},
+	} {
+		err := QueueWebhook(db, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, err := FetchDueWebhooks(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 3 || due[1].Signature != "sha256=abc" || string(due[1].Payload) != "{}" {
+		t.Fatalf("Queued deliveries should be due: got %d", len(due))
+	}
+
+	err = due[0].MarkDelivered(db, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = due[1].MarkRetry(db, 503, errors.New("unavailable"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = due[2].MarkFailed(db, 404, errors.New("not found"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = FetchDueWebhooks(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("No deliveries should be due: got %d", len(due))
+	}
+
+	log, err := FetchJobWebhooks(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].URL != "https://job.example.edu" {
+		t.Fatalf("Only deliveries to the job webhook should be logged: got %d", len(log))
+	}
+	if log[0].Status != WebhookPending || log[0].Attempts != 1 || log[0].ResponseCode != 503 || log[0].NextAttempt == nil {
+		t.Errorf("Incorrect delivery: %+v", log[0])
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mrc
+
+import (
+	"math"
+	"sort"
+)
+
+// Position (Å) of voxel i, j, k in the map coordinate frame
+func (m *Map) coord(i, j, k int, voxel [3]float64) [3]float64 {
+	return [3]float64{
+		m.Origin[0] + float64(m.Start[0]+i)*voxel[0],
+		m.Origin[1] + float64(m.Start[1]+j)*voxel[1],
+		m.Origin[2] + float64(m.Start[2]+k)*voxel[2],
+	}
+}
+
+// Density at position p (Å) by trilinear interpolation. Zero outside the grid
+func (m *Map) Interpolate(p [3]float64) float64 {
+	voxel := m.VoxelSize()
+	var g [3]float64
+	for a := 0; a < 3; a++ {
+		g[a] = (p[a]-m.Origin[a])/voxel[a] - float64(m.Start[a])
+	}
+
+	i0, j0, k0 := int(math.Floor(g[0])), int(math.Floor(g[1])), int(math.Floor(g[2]))
+	fx, fy, fz := g[0]-float64(i0), g[1]-float64(j0), g[2]-float64(k0)
+
+	value := func(i, j, k int) float64 {
+		if i < 0 || j < 0 || k < 0 || i >= m.NX || j >= m.NY || k >= m.NZ {
+			return 0
+		}
+		return float64(m.At(i, j, k))
+	}
+
+	v := 0.0
+	for c := 0; c < 8; c++ {
+		di, dj, dk := c&1, (c>>1)&1, (c>>2)&1
+		w := 1.0
+		if di == 1 {
+			w *= fx
+		} else {
+			w *= 1 - fx
+		}
+		if dj == 1 {
+			w *= fy
+		} else {
+			w *= 1 - fy
+		}
+		if dk == 1 {
+			w *= fz
+		} else {
+			w *= 1 - fz
+		}
+		if w != 0 {
+			v += w * value(i0+di, j0+dj, k0+dk)
+		}
+	}
+
+	return v
+}
+
+// Center of mass and density weighted covariance of the positive density
+func (m *Map) inertia() ([3]float64, [3][3]float64) {
+	voxel := m.VoxelSize()
+	var com [3]float64
+	var cov [3][3]float64
+	total := 0.0
+
+	for k := 0; k < m.NZ; k++ {
+		for j := 0; j < m.NY; j++ {
+			for i := 0; i < m.NX; i++ {
+				v := float64(m.At(i, j, k))
+				if v <= 0 {
+					continue
+				}
+				p := m.coord(i, j, k, voxel)
+				for a := 0; a < 3; a++ {
+					com[a] += v * p[a]
+				}
+				total += v
+			}
+		}
+	}
+
+	if total == 0 {
+		return com, cov
+	}
+	for a := 0; a < 3; a++ {
+		com[a] /= total
+	}
+
+	for k := 0; k < m.NZ; k++ {
+		for j := 0; j < m.NY; j++ {
+			for i := 0; i < m.NX; i++ {
+				v := float64(m.At(i, j, k))
+				if v <= 0 {
+					continue
+				}
+				p := m.coord(i, j, k, voxel)
+				for a := 0; a < 3; a++ {
+					for b := 0; b < 3; b++ {
+						cov[a][b] += v * (p[a] - com[a]) * (p[b] - com[b])
+					}
+				}
+			}
+		}
+	}
+	for a := 0; a < 3; a++ {
+		for b := 0; b < 3; b++ {
+			cov[a][b] /= total
+		}
+	}
+
+	return com, cov
+}
+
+// Eigenvectors (as columns) of a symmetric 3x3 matrix by Jacobi rotations,
+// ordered by decreasing eigenvalue
+func eigen(s [3][3]float64) [3][3]float64 {
+	a := s
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-20 {
+			break
+		}
+
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				sn := t * c
+
+				// A' = Jᵀ A J
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - sn*akq
+					a[k][q] = sn*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - sn*aqk
+					a[q][k] = sn*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - sn*vkq
+					v[k][q] = sn*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	order := []int{0, 1, 2}
+	sort.Slice(order, func(i, j int) bool {
+		return a[order[i]][order[i]] > a[order[j]][order[j]]
+	})
+
+	var sorted [3][3]float64
+	for col, o := range order {
+		for row := 0; row < 3; row++ {
+			sorted[row][col] = v[row][o]
+		}
+	}
+
+	return sorted
+}
+
+// Resample m onto the grid of ref, mapping each position x on the ref grid
+// to center + rot·(x - refCenter) in m
+func (m *Map) transform(ref *Map, rot [3][3]float64, refCenter, center [3]float64) *Map {
+	out := ref.Empty()
+	voxel := ref.VoxelSize()
+	for k := 0; k < ref.NZ; k++ {
+		for j := 0; j < ref.NY; j++ {
+			for i := 0; i < ref.NX; i++ {
+				x := ref.coord(i, j, k, voxel)
+				var d, p [3]float64
+				for a := 0; a < 3; a++ {
+					d[a] = x[a] - refCenter[a]
+				}
+				for a := 0; a < 3; a++ {
+					p[a] = center[a] + rot[a][0]*d[0] + rot[a][1]*d[1] + rot[a][2]*d[2]
+				}
+				out.Data[out.Index(i, j, k)] = float32(m.Interpolate(p))
+			}
+		}
+	}
+
+	return out
+}
+
+// Resample m onto the grid of ref without moving it
+func (m *Map) Resample(ref *Map) *Map {
+	identity := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	return m.transform(ref, identity, [3]float64{}, [3]float64{})
+}
+
+// Pearson correlation of the densities of two maps on the same grid
+func Correlation(a, b *Map) float64 {
+	if len(a.Data) != len(b.Data) || len(a.Data) == 0 {
+		return 0
+	}
+
+	n := float64(len(a.Data))
+	var ma, mb float64
+	for i := range a.Data {
+		ma += float64(a.Data[i])
+		mb += float64(b.Data[i])
+	}
+	ma /= n
+	mb /= n
+
+	var sab, saa, sbb float64
+	for i := range a.Data {
+		da := float64(a.Data[i]) - ma
+		db := float64(b.Data[i]) - mb
+		sab += da * db
+		saa += da * da
+		sbb += db * db
+	}
+
+	if saa == 0 || sbb == 0 {
+		return 0
+	}
+
+	return sab / math.Sqrt(saa*sbb)
+}
+
+// Superimpose m onto ref by matching centers of mass and principal axes of
+// inertia. Every choice of axis directions is tried, including mirror images
+// since SAXS data can not distinguish enantiomers, and the one with the
+// highest correlation is kept. Returns m resampled on the grid of ref and the
+// correlation
+func (m *Map) Align(ref *Map) (*Map, float64) {
+	refCenter, refCov := ref.inertia()
+	center, cov := m.inertia()
+	refAxes := eigen(refCov)
+	axes := eigen(cov)
+
+	var best *Map
+	bestCorr := math.Inf(-1)
+	for signs := 0; signs < 8; signs++ {
+		var flip [3]float64
+		for a := 0; a < 3; a++ {
+			flip[a] = 1
+			if signs&(1<<uint(a)) != 0 {
+				flip[a] = -1
+			}
+		}
+
+		// rot = axes · diag(flip) · refAxesᵀ maps ref coordinates to m
+		var rot [3][3]float64
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				for a := 0; a < 3; a++ {
+					rot[r][c] += axes[r][a] * flip[a] * refAxes[c][a]
+				}
+			}
+		}
+
+		aligned := m.transform(ref, rot, refCenter, center)
+		corr := Correlation(ref, aligned)
+		if corr > bestCorr {
+			best, bestCorr = aligned, corr
+		}
+	}
+
+	return best, bestCorr
+}
+
+// Difference map a - s·b where b is scaled by the least squares factor s that
+// best matches a. Both maps must be on the same grid
+func Difference(a, b *Map) (*Map, error) {
+	if a.NX != b.NX || a.NY != b.NY || a.NZ != b.NZ {
+		return nil, ErrGridMismatch
+	}
+
+	var ab, bb float64
+	for i := range a.Data {
+		ab += float64(a.Data[i]) * float64(b.Data[i])
+		bb += float64(b.Data[i]) * float64(b.Data[i])
+	}
+
+	s := 1.0
+	if bb > 0 {
+		s = ab / bb
+	}
+
+	diff := a.Empty()
+	for i := range a.Data {
+		diff.Data[i] = float32(float64(a.Data[i]) - s*float64(b.Data[i]))
+	}
+
+	return diff, nil
+}
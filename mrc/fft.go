@@ -0,0 +1,148 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mrc
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// In place discrete Fourier transform of x. Lengths that are a power of two
+// use the radix-2 algorithm, other lengths use Bluestein's algorithm
+func fft(x []complex128) {
+	n := len(x)
+	if n <= 1 {
+		return
+	}
+
+	if n&(n-1) == 0 {
+		fft2(x)
+		return
+	}
+
+	// Bluestein: express the DFT as a convolution with a chirp, computed
+	// using power of two transforms
+	m := 1
+	for m < 2*n-1 {
+		m <<= 1
+	}
+
+	chirp := make([]complex128, n)
+	for k := 0; k < n; k++ {
+		// k² mod 2n avoids loss of precision for large k
+		kk := (k * k) % (2 * n)
+		chirp[k] = cmplx.Exp(complex(0, -math.Pi*float64(kk)/float64(n)))
+	}
+
+	a := make([]complex128, m)
+	b := make([]complex128, m)
+	for k := 0; k < n; k++ {
+		a[k] = x[k] * chirp[k]
+	}
+	b[0] = cmplx.Conj(chirp[0])
+	for k := 1; k < n; k++ {
+		b[k] = cmplx.Conj(chirp[k])
+		b[m-k] = b[k]
+	}
+
+	fft2(a)
+	fft2(b)
+	for i := range a {
+		a[i] *= b[i]
+	}
+	ifft2(a)
+
+	for k := 0; k < n; k++ {
+		x[k] = a[k] * chirp[k]
+	}
+}
+
+// Radix-2 transform. len(x) must be a power of two
+func fft2(x []complex128) {
+	n := len(x)
+
+	// Bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := x[start+k]
+				v := x[start+k+size/2] * wk
+				x[start+k] = u + v
+				x[start+k+size/2] = u - v
+				wk *= w
+			}
+		}
+	}
+}
+
+// Inverse radix-2 transform, scaled by 1/n
+func ifft2(x []complex128) {
+	for i := range x {
+		x[i] = cmplx.Conj(x[i])
+	}
+	fft2(x)
+	n := complex(float64(len(x)), 0)
+	for i := range x {
+		x[i] = cmplx.Conj(x[i]) / n
+	}
+}
+
+// Three dimensional Fourier transform of the map
+func (m *Map) fft3() []complex128 {
+	data := make([]complex128, len(m.Data))
+	for i, v := range m.Data {
+		data[i] = complex(float64(v), 0)
+	}
+
+	dims := [3]int{m.NX, m.NY, m.NZ}
+	strides := [3]int{1, m.NX, m.NX * m.NY}
+	for axis := 0; axis < 3; axis++ {
+		n := dims[axis]
+		stride := strides[axis]
+		line := make([]complex128, n)
+
+		// Visit the first element of every line along axis
+		for idx := range data {
+			if (idx/stride)%n != 0 {
+				continue
+			}
+			for i := 0; i < n; i++ {
+				line[i] = data[idx+i*stride]
+			}
+			fft(line)
+			for i := 0; i < n; i++ {
+				data[idx+i*stride] = line[i]
+			}
+		}
+	}
+
+	return data
+}
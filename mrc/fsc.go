@@ -0,0 +1,98 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mrc
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+)
+
+var (
+	ErrGridMismatch = errors.New("Maps must have the same grid dimensions")
+)
+
+// Signed frequency index of i in a transform of length n
+func freqIndex(i, n int) int {
+	if i > n/2 {
+		return i - n
+	}
+	return i
+}
+
+// Fourier Shell Correlation between two maps on the same grid. Returns the
+// spatial frequency (1/Å) of each shell and the correlation in the shell.
+// Shells are spaced by the smallest frequency step of the grid up to the
+// Nyquist frequency.
+func FSC(a, b *Map) ([]float64, []float64, error) {
+	if a.NX != b.NX || a.NY != b.NY || a.NZ != b.NZ {
+		return nil, nil, ErrGridMismatch
+	}
+
+	voxel := a.VoxelSize()
+	dims := [3]int{a.NX, a.NY, a.NZ}
+
+	// Shell width and number of shells up to the lowest Nyquist frequency
+	width := 0.0
+	nyquist := math.Inf(1)
+	for i := 0; i < 3; i++ {
+		if voxel[i] <= 0 {
+			return nil, nil, errors.New("Invalid voxel size")
+		}
+		width = math.Max(width, float64(dims[i])*voxel[i])
+		nyquist = math.Min(nyquist, 0.5/voxel[i])
+	}
+	width = 1 / width
+	nshells := int(nyquist/width) + 1
+
+	fa := a.fft3()
+	fb := b.fft3()
+
+	cross := make([]float64, nshells)
+	powa := make([]float64, nshells)
+	powb := make([]float64, nshells)
+	for k := 0; k < a.NZ; k++ {
+		qz := float64(freqIndex(k, a.NZ)) / (float64(a.NZ) * voxel[2])
+		for j := 0; j < a.NY; j++ {
+			qy := float64(freqIndex(j, a.NY)) / (float64(a.NY) * voxel[1])
+			for i := 0; i < a.NX; i++ {
+				qx := float64(freqIndex(i, a.NX)) / (float64(a.NX) * voxel[0])
+				shell := int(math.Round(math.Sqrt(qx*qx+qy*qy+qz*qz) / width))
+				if shell >= nshells {
+					continue
+				}
+
+				idx := a.Index(i, j, k)
+				cross[shell] += real(fa[idx] * cmplx.Conj(fb[idx]))
+				powa[shell] += real(fa[idx] * cmplx.Conj(fa[idx]))
+				powb[shell] += real(fb[idx] * cmplx.Conj(fb[idx]))
+			}
+		}
+	}
+
+	freq := make([]float64, nshells)
+	corr := make([]float64, nshells)
+	for s := range freq {
+		freq[s] = float64(s) * width
+		if d := math.Sqrt(powa[s] * powb[s]); d > 0 {
+			corr[s] = cross[s] / d
+		}
+	}
+
+	return freq, corr, nil
+}
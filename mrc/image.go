@@ -0,0 +1,152 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mrc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	AxisX = iota
+	AxisY
+	AxisZ
+)
+
+var axisNames = map[string]int{"x": AxisX, "y": AxisY, "z": AxisZ}
+
+// Parse axis name x, y or z
+func ParseAxis(name string) (int, error) {
+	axis, ok := axisNames[name]
+	if !ok {
+		return 0, fmt.Errorf("Invalid axis: %s", name)
+	}
+	return axis, nil
+}
+
+// Size of the grid along axis
+func (m *Map) Dim(axis int) int {
+	return [3]int{m.NX, m.NY, m.NZ}[axis]
+}
+
+// Grid axes shown horizontally and vertically in images viewed down axis
+func imageAxes(axis int) (int, int) {
+	switch axis {
+	case AxisX:
+		return AxisY, AxisZ
+	case AxisY:
+		return AxisX, AxisZ
+	}
+	return AxisX, AxisY
+}
+
+// Render a 2D array of values, indexed [row][col], as a grayscale image
+// scaled from min (black) to max (white). The first row is drawn at the
+// bottom of the image
+func grayImage(vals [][]float64, min, max float64) *image.Gray {
+	h := len(vals)
+	w := 0
+	if h > 0 {
+		w = len(vals[0])
+	}
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for r, row := range vals {
+		for c, v := range row {
+			g := 0.0
+			if max > min {
+				g = (v - min) / (max - min)
+			}
+			g = math.Max(0, math.Min(1, g))
+			img.SetGray(c, h-1-r, color.Gray{uint8(math.Round(g * 255))})
+		}
+	}
+
+	return img
+}
+
+// Value at position (u, v) on the plane at index along axis
+func (m *Map) planeAt(axis, index, u, v int) float64 {
+	var p [3]int
+	ua, va := imageAxes(axis)
+	p[axis] = index
+	p[ua] = u
+	p[va] = v
+	return float64(m.At(p[0], p[1], p[2]))
+}
+
+// Projection of the density summed along axis
+func (m *Map) Projection(axis int) *image.Gray {
+	ua, va := imageAxes(axis)
+	vals := make([][]float64, m.Dim(va))
+	min, max := math.Inf(1), math.Inf(-1)
+	for v := range vals {
+		vals[v] = make([]float64, m.Dim(ua))
+		for u := range vals[v] {
+			sum := 0.0
+			for i := 0; i < m.Dim(axis); i++ {
+				sum += m.planeAt(axis, i, u, v)
+			}
+			vals[v][u] = sum
+			min = math.Min(min, sum)
+			max = math.Max(max, sum)
+		}
+	}
+
+	return grayImage(vals, min, max)
+}
+
+// Slice through the map at index along axis. Densities are scaled by the
+// minimum and maximum of the whole map so slices can be compared
+func (m *Map) Slice(axis, index int) (*image.Gray, error) {
+	if index < 0 || index >= m.Dim(axis) {
+		return nil, fmt.Errorf("Slice index %d out of range [0, %d)", index, m.Dim(axis))
+	}
+
+	ua, va := imageAxes(axis)
+	vals := make([][]float64, m.Dim(va))
+	for v := range vals {
+		vals[v] = make([]float64, m.Dim(ua))
+		for u := range vals[v] {
+			vals[v][u] = m.planeAt(axis, index, u, v)
+		}
+	}
+
+	s := m.Summary()
+	return grayImage(vals, s.Min, s.Max), nil
+}
+
+// Scale img so its longest side is size pixels
+func Scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return img
+	}
+
+	f := float64(size) / math.Max(float64(b.Dx()), float64(b.Dy()))
+	w := int(math.Max(1, math.Round(float64(b.Dx())*f)))
+	h := int(math.Max(1, math.Round(float64(b.Dy())*f)))
+
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,307 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mrc reads and writes electron density maps in the MRC/CCP4 format.
+// See: https://www.ccpem.ac.uk/mrc_format/mrc2014.php
+package mrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	HeaderSize = 1024
+
+	ModeInt8    = 0
+	ModeInt16   = 1
+	ModeFloat32 = 2
+	ModeUint16  = 6
+)
+
+var (
+	ErrInvalidHeader = errors.New("Invalid MRC/CCP4 header")
+	ErrTruncated     = errors.New("MRC/CCP4 map data is truncated")
+)
+
+// An electron density map. The grid is stored in file order with columns
+// varying fastest, then rows, then sections.
+type Map struct {
+	// Number of columns, rows and sections
+	NX, NY, NZ int
+
+	// Data mode of the file the map was read from
+	Mode int
+
+	// Location of the first column, row and section in the unit cell
+	Start [3]int
+
+	// Number of intervals along X, Y, Z in the unit cell
+	Sampling [3]int
+
+	// Unit cell dimensions (Å)
+	Cell [3]float64
+
+	// Unit cell angles (degrees)
+	Angles [3]float64
+
+	// Axis (1=X, 2=Y, 3=Z) corresponding to columns, rows and sections
+	Axes [3]int
+
+	// Origin (Å)
+	Origin [3]float64
+
+	// Density values
+	Data []float32
+}
+
+func byteOrder(hdr []byte) (binary.ByteOrder, error) {
+	// Machine stamp is 0x44 0x41 for little endian, 0x11 0x11 for big
+	switch hdr[212] {
+	case 0x44:
+		return binary.LittleEndian, nil
+	case 0x11:
+		return binary.BigEndian, nil
+	}
+
+	// Older files may not set the machine stamp. Guess based on the mode
+	mode := binary.LittleEndian.Uint32(hdr[12:16])
+	if mode <= 16 {
+		return binary.LittleEndian, nil
+	}
+	mode = binary.BigEndian.Uint32(hdr[12:16])
+	if mode <= 16 {
+		return binary.BigEndian, nil
+	}
+
+	return nil, ErrInvalidHeader
+}
+
+// Parse an MRC/CCP4 map
+func Decode(data []byte) (*Map, error) {
+	if len(data) < HeaderSize {
+		return nil, ErrInvalidHeader
+	}
+
+	hdr := data[:HeaderSize]
+	order, err := byteOrder(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	word := func(i int) int32 {
+		return int32(order.Uint32(hdr[i*4 : i*4+4]))
+	}
+	float := func(i int) float64 {
+		return float64(math.Float32frombits(order.Uint32(hdr[i*4 : i*4+4])))
+	}
+
+	m := &Map{
+		NX:   int(word(0)),
+		NY:   int(word(1)),
+		NZ:   int(word(2)),
+		Mode: int(word(3)),
+	}
+
+	if m.NX <= 0 || m.NY <= 0 || m.NZ <= 0 {
+		return nil, fmt.Errorf("%s: invalid grid dimensions %d x %d x %d", ErrInvalidHeader, m.NX, m.NY, m.NZ)
+	}
+
+	for i := 0; i < 3; i++ {
+		m.Start[i] = int(word(4 + i))
+		m.Sampling[i] = int(word(7 + i))
+		m.Cell[i] = float(10 + i)
+		m.Angles[i] = float(13 + i)
+		m.Axes[i] = int(word(16 + i))
+		m.Origin[i] = float(49 + i)
+	}
+
+	// Older CCP4 files may leave these unset
+	for i := 0; i < 3; i++ {
+		if m.Axes[i] < 1 || m.Axes[i] > 3 {
+			m.Axes = [3]int{1, 2, 3}
+			break
+		}
+	}
+	dims := [3]int{m.NX, m.NY, m.NZ}
+	for i := 0; i < 3; i++ {
+		if m.Sampling[m.Axes[i]-1] <= 0 {
+			m.Sampling[m.Axes[i]-1] = dims[i]
+		}
+	}
+
+	var size int
+	switch m.Mode {
+	case ModeInt8:
+		size = 1
+	case ModeInt16, ModeUint16:
+		size = 2
+	case ModeFloat32:
+		size = 4
+	default:
+		return nil, fmt.Errorf("Unsupported MRC/CCP4 data mode: %d", m.Mode)
+	}
+
+	nsymbt := int(word(23))
+	if nsymbt < 0 {
+		return nil, fmt.Errorf("%s: invalid extended header size %d", ErrInvalidHeader, nsymbt)
+	}
+
+	// Check the grid fits in the remaining data one dimension at a time so
+	// the number of voxels can't overflow before it is compared
+	offset := HeaderSize + nsymbt
+	if len(data) < offset {
+		return nil, ErrTruncated
+	}
+	avail := (len(data) - offset) / size
+	n := 1
+	for _, d := range dims {
+		if d > avail/n {
+			return nil, ErrTruncated
+		}
+		n *= d
+	}
+
+	raw := data[offset : offset+n*size]
+	m.Data = make([]float32, n)
+	for i := range m.Data {
+		switch m.Mode {
+		case ModeInt8:
+			m.Data[i] = float32(int8(raw[i]))
+		case ModeInt16:
+			m.Data[i] = float32(int16(order.Uint16(raw[i*2:])))
+		case ModeUint16:
+			m.Data[i] = float32(order.Uint16(raw[i*2:]))
+		case ModeFloat32:
+			m.Data[i] = math.Float32frombits(order.Uint32(raw[i*4:]))
+		}
+	}
+
+	return m, nil
+}
+
+// Read an MRC/CCP4 map from r
+func Read(r io.Reader) (*Map, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(buf.Bytes())
+}
+
+// Create a new map with the given grid dimensions and cubic voxels of size
+// voxel (Å)
+func New(nx, ny, nz int, voxel float64) *Map {
+	return &Map{
+		NX:       nx,
+		NY:       ny,
+		NZ:       nz,
+		Mode:     ModeFloat32,
+		Sampling: [3]int{nx, ny, nz},
+		Cell:     [3]float64{float64(nx) * voxel, float64(ny) * voxel, float64(nz) * voxel},
+		Angles:   [3]float64{90, 90, 90},
+		Axes:     [3]int{1, 2, 3},
+		Data:     make([]float32, nx*ny*nz),
+	}
+}
+
+// Create a new map on the same grid as m with all densities set to zero
+func (m *Map) Empty() *Map {
+	c := *m
+	c.Mode = ModeFloat32
+	c.Data = make([]float32, len(m.Data))
+	return &c
+}
+
+// Index into Data for column i, row j and section k
+func (m *Map) Index(i, j, k int) int {
+	return (k*m.NY+j)*m.NX + i
+}
+
+// Density at column i, row j and section k
+func (m *Map) At(i, j, k int) float32 {
+	return m.Data[m.Index(i, j, k)]
+}
+
+// Voxel size (Å) along columns, rows and sections
+func (m *Map) VoxelSize() [3]float64 {
+	var v [3]float64
+	for i := 0; i < 3; i++ {
+		axis := m.Axes[i] - 1
+		if m.Sampling[axis] > 0 {
+			v[i] = m.Cell[axis] / float64(m.Sampling[axis])
+		}
+	}
+	return v
+}
+
+// Encode the map in MRC2014 format using 32-bit floats
+func (m *Map) Encode() []byte {
+	hdr := make([]byte, HeaderSize)
+	order := binary.LittleEndian
+
+	putWord := func(i int, v int32) {
+		order.PutUint32(hdr[i*4:], uint32(v))
+	}
+	putFloat := func(i int, v float64) {
+		order.PutUint32(hdr[i*4:], math.Float32bits(float32(v)))
+	}
+
+	stats := m.Summary()
+
+	putWord(0, int32(m.NX))
+	putWord(1, int32(m.NY))
+	putWord(2, int32(m.NZ))
+	putWord(3, ModeFloat32)
+	for i := 0; i < 3; i++ {
+		putWord(4+i, int32(m.Start[i]))
+		putWord(7+i, int32(m.Sampling[i]))
+		putFloat(10+i, m.Cell[i])
+		putFloat(13+i, m.Angles[i])
+		putWord(16+i, int32(m.Axes[i]))
+		putFloat(49+i, m.Origin[i])
+	}
+	putFloat(19, stats.Min)
+	putFloat(20, stats.Max)
+	putFloat(21, stats.Mean)
+	putWord(22, 1)
+	putWord(27, 20140)
+	copy(hdr[52*4:], "MAP ")
+	hdr[212] = 0x44
+	hdr[213] = 0x41
+	putFloat(54, stats.RMS)
+
+	buf := make([]byte, HeaderSize+4*len(m.Data))
+	copy(buf, hdr)
+	for i, v := range m.Data {
+		order.PutUint32(buf[HeaderSize+i*4:], math.Float32bits(v))
+	}
+
+	return buf
+}
+
+// Write the map to w in MRC2014 format
+func (m *Map) Write(w io.Writer) error {
+	_, err := w.Write(m.Encode())
+	return err
+}
@@ -0,0 +1,288 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mrc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// Uniform sphere of density 1 with radius r voxels centered in an n^3 grid
+func sphere(n int, r, voxel float64) *Map {
+	m := New(n, n, n, voxel)
+	c := float64(n-1) / 2
+	for k := 0; k < n; k++ {
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				d := math.Sqrt((float64(i)-c)*(float64(i)-c) + (float64(j)-c)*(float64(j)-c) + (float64(k)-c)*(float64(k)-c))
+				if d <= r {
+					m.Data[m.Index(i, j, k)] = 1
+				}
+			}
+		}
+	}
+	return m
+}
+
+func TestEncodeDecode(t *testing.T) {
+	m := sphere(16, 5, 2.5)
+	m.Origin = [3]float64{-20, -20, -20}
+
+	data := m.Encode()
+
+	m2, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m2.NX != 16 || m2.NY != 16 || m2.NZ != 16 {
+		t.Errorf("Incorrect grid: got %d x %d x %d should be 16 x 16 x 16", m2.NX, m2.NY, m2.NZ)
+	}
+
+	if v := m2.VoxelSize(); v[0] != 2.5 || v[1] != 2.5 || v[2] != 2.5 {
+		t.Errorf("Incorrect voxel size: got %v should be 2.5", v)
+	}
+
+	if m2.Origin != m.Origin {
+		t.Errorf("Incorrect origin: got %v should be %v", m2.Origin, m.Origin)
+	}
+
+	for i := range m.Data {
+		if m.Data[i] != m2.Data[i] {
+			t.Fatalf("Incorrect density at %d: got %f should be %f", i, m2.Data[i], m.Data[i])
+		}
+	}
+
+	_, err = Decode(data[:HeaderSize+100])
+	if err != ErrTruncated {
+		t.Errorf("Truncated map should fail: got %v", err)
+	}
+
+	// Grid dimensions whose product overflows must not pass the size check
+	huge := make([]byte, len(data))
+	copy(huge, data)
+	for i := 0; i < 3; i++ {
+		binary.LittleEndian.PutUint32(huge[i*4:], math.MaxInt32)
+	}
+	_, err = Decode(huge)
+	if err != ErrTruncated {
+		t.Errorf("Overflowing grid should fail: got %v", err)
+	}
+
+	binary.LittleEndian.PutUint32(huge[0:], 0)
+	_, err = Decode(huge)
+	if err == nil {
+		t.Errorf("Empty grid should fail: got %v", err)
+	}
+
+	_, err = Decode([]byte("not a map"))
+	if err != ErrInvalidHeader {
+		t.Errorf("Invalid map should fail: got %v", err)
+	}
+
+	bad := make([]byte, len(data))
+	copy(bad, data)
+	binary.LittleEndian.PutUint32(bad[12:], 12)
+	_, err = Decode(bad)
+	if err == nil {
+		t.Errorf("Unsupported mode should fail")
+	}
+}
+
+func TestStats(t *testing.T) {
+	voxel := 2.0
+	r := 10.0
+	m := sphere(32, r, voxel)
+
+	stats := m.Stats(0.5)
+
+	if stats.Max != 1 || stats.Min != 0 {
+		t.Errorf("Incorrect min/max: got %f/%f should be 0/1", stats.Min, stats.Max)
+	}
+
+	rg := math.Sqrt(3.0/5.0) * r * voxel
+	if math.Abs(stats.Rg-rg) > 0.5 {
+		t.Errorf("Incorrect Rg: got %.3f should be %.3f", stats.Rg, rg)
+	}
+
+	vol := 4.0 / 3.0 * math.Pi * math.Pow(r*voxel, 3)
+	if math.Abs(stats.Volume-vol)/vol > 0.05 {
+		t.Errorf("Incorrect volume: got %.3f should be %.3f", stats.Volume, vol)
+	}
+
+	dmax := 2 * r * voxel
+	if math.Abs(stats.Dmax-dmax) > 2*voxel {
+		t.Errorf("Incorrect Dmax: got %.3f should be %.3f", stats.Dmax, dmax)
+	}
+
+	// NaN and infinite voxels are skipped so the statistics can be encoded
+	m.Data[0] = float32(math.NaN())
+	m.Data[1] = float32(math.Inf(1))
+	bad := m.Stats(0.5)
+	if _, err := json.Marshal(bad); err != nil {
+		t.Fatalf("Failed to encode statistics of map with NaN voxel: %s", err)
+	}
+	if bad.Max != 1 || math.Abs(bad.Rg-stats.Rg) > 1e-6 || bad.Volume != stats.Volume {
+		t.Errorf("NaN voxels should be skipped: got %+v", bad)
+	}
+}
+
+func TestImages(t *testing.T) {
+	m := New(4, 5, 6, 1.0)
+	m.Data[m.Index(1, 2, 3)] = 2
+
+	img := m.Projection(AxisZ)
+	if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 5 {
+		t.Fatalf("Incorrect projection size: got %v", img.Bounds())
+	}
+	// First row is drawn at the bottom
+	if img.GrayAt(1, 2).Y != 255 || img.GrayAt(0, 0).Y != 0 {
+		t.Errorf("Incorrect projection along z")
+	}
+
+	img = m.Projection(AxisX)
+	if img.Bounds().Dx() != 5 || img.Bounds().Dy() != 6 || img.GrayAt(2, 2).Y != 255 {
+		t.Errorf("Incorrect projection along x")
+	}
+
+	img, err := m.Slice(AxisY, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 6 || img.GrayAt(1, 2).Y != 255 {
+		t.Errorf("Incorrect slice along y")
+	}
+
+	img, err = m.Slice(AxisY, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.GrayAt(1, 2).Y != 0 {
+		t.Errorf("Incorrect empty slice along y")
+	}
+
+	_, err = m.Slice(AxisZ, 6)
+	if err == nil {
+		t.Errorf("Slice index out of range should return an error")
+	}
+
+	if _, err := ParseAxis("w"); err == nil {
+		t.Errorf("Invalid axis should return an error")
+	}
+
+	scaled := Scale(m.Projection(AxisY), 128)
+	if scaled.Bounds().Dx() != 85 || scaled.Bounds().Dy() != 128 {
+		t.Errorf("Incorrect scaled size: got %v", scaled.Bounds())
+	}
+}
+
+func TestFFT(t *testing.T) {
+	for _, n := range []int{1, 5, 8, 12, 31} {
+		x := make([]complex128, n)
+		for i := range x {
+			x[i] = complex(math.Sin(float64(i)*1.3)+float64(i%3), math.Cos(float64(i)))
+		}
+
+		expected := make([]complex128, n)
+		for k := range expected {
+			for j := range x {
+				expected[k] += x[j] * cmplx.Exp(complex(0, -2*math.Pi*float64(j*k)/float64(n)))
+			}
+		}
+
+		fft(x)
+		for k := range x {
+			if cmplx.Abs(x[k]-expected[k]) > 1e-9 {
+				t.Fatalf("Incorrect FFT for n=%d at %d: got %v should be %v", n, k, x[k], expected[k])
+			}
+		}
+	}
+}
+
+// Gaussian ellipsoid with widths sx, sy, sz (voxels) centered at c
+func ellipsoid(n int, c, s [3]float64) *Map {
+	m := New(n, n, n, 2.0)
+	for k := 0; k < n; k++ {
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				d := 0.0
+				for a, p := range []int{i, j, k} {
+					d += (float64(p) - c[a]) * (float64(p) - c[a]) / (s[a] * s[a])
+				}
+				m.Data[m.Index(i, j, k)] = float32(math.Exp(-d / 2))
+			}
+		}
+	}
+	return m
+}
+
+func TestFSC(t *testing.T) {
+	a := ellipsoid(16, [3]float64{8, 8, 8}, [3]float64{4, 2, 1.5})
+
+	freq, corr, err := FSC(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(freq) != 9 || freq[1] != 1.0/32 {
+		t.Errorf("Incorrect FSC shells: got %v", freq)
+	}
+	for s, c := range corr {
+		if math.Abs(c-1) > 1e-9 {
+			t.Errorf("FSC of map with itself should be 1: got %g in shell %d", c, s)
+		}
+	}
+
+	_, _, err = FSC(a, New(8, 8, 8, 2.0))
+	if err != ErrGridMismatch {
+		t.Errorf("Maps on different grids should return ErrGridMismatch")
+	}
+}
+
+func TestAlign(t *testing.T) {
+	ref := ellipsoid(24, [3]float64{12, 12, 12}, [3]float64{5, 3, 1.5})
+
+	// Same shape rotated 90° about z and shifted
+	moving := ellipsoid(24, [3]float64{9, 15, 10}, [3]float64{3, 5, 1.5})
+	if c := Correlation(ref, moving); c > 0.5 {
+		t.Fatalf("Maps should not be aligned initially: correlation %g", c)
+	}
+
+	aligned, corr := moving.Align(ref)
+	if corr < 0.95 {
+		t.Errorf("Incorrect alignment: correlation %g", corr)
+	}
+	if c := Correlation(ref, aligned); c != corr {
+		t.Errorf("Incorrect correlation: got %g should be %g", c, corr)
+	}
+
+	half := ref.Empty()
+	for i, v := range ref.Data {
+		half.Data[i] = v / 2
+	}
+	diff, err := Difference(ref, half)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := diff.Summary(); math.Abs(s.Min) > 1e-6 || math.Abs(s.Max) > 1e-6 {
+		t.Errorf("Difference of scaled maps should be zero: got %v", s)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package mrc
+
+import (
+	"math"
+)
+
+const (
+	// Maximum number of surface voxels compared when computing the maximum
+	// dimension. Larger surfaces are sampled evenly
+	maxSurfaceVoxels = 8000
+)
+
+// Density statistics of a map
+type Summary struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+
+	// Root mean square deviation from the mean
+	RMS float64 `json:"rms"`
+}
+
+// Statistics and shape measurements of a map
+type Stats struct {
+	Summary
+
+	// Grid dimensions (columns, rows, sections)
+	Grid [3]int `json:"grid"`
+
+	// Voxel size (Å)
+	VoxelSize [3]float64 `json:"voxel_size"`
+
+	// Origin (Å)
+	Origin [3]float64 `json:"origin"`
+
+	// Density threshold used for the volume and maximum dimension
+	Threshold float64 `json:"threshold"`
+
+	// Radius of gyration (Å) of the positive density
+	Rg float64 `json:"rg"`
+
+	// Volume (Å³) enclosed by the threshold
+	Volume float64 `json:"volume"`
+
+	// Maximum dimension (Å) of the density enclosed by the threshold
+	Dmax float64 `json:"dmax"`
+}
+
+// Returns true if the density is neither NaN nor infinite
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Compute min, max, mean and RMS deviation of the density values. NaN and
+// infinite values are skipped
+func (m *Map) Summary() Summary {
+	s := Summary{}
+
+	min := math.Inf(1)
+	max := math.Inf(-1)
+	sum := 0.0
+	n := 0
+	for _, v := range m.Data {
+		f := float64(v)
+		if !finite(f) {
+			continue
+		}
+		min = math.Min(min, f)
+		max = math.Max(max, f)
+		sum += f
+		n++
+	}
+	if n == 0 {
+		return s
+	}
+	s.Min, s.Max = min, max
+	s.Mean = sum / float64(n)
+
+	ss := 0.0
+	for _, v := range m.Data {
+		f := float64(v)
+		if !finite(f) {
+			continue
+		}
+		d := f - s.Mean
+		ss += d * d
+	}
+	s.RMS = math.Sqrt(ss / float64(n))
+
+	return s
+}
+
+// Density level n standard deviations above the mean
+func (s Summary) Sigma(n float64) float64 {
+	return s.Mean + n*s.RMS
+}
+
+// Position (Å) of the center of voxel i, j, k relative to the grid origin
+func (m *Map) position(i, j, k int, voxel [3]float64) [3]float64 {
+	return [3]float64{float64(i) * voxel[0], float64(j) * voxel[1], float64(k) * voxel[2]}
+}
+
+// Compute map statistics. Volume and maximum dimension are measured from the
+// voxels with density above threshold. NaN and infinite voxels are skipped.
+func (m *Map) Stats(threshold float64) *Stats {
+	voxel := m.VoxelSize()
+	stats := &Stats{
+		Summary:   m.Summary(),
+		Grid:      [3]int{m.NX, m.NY, m.NZ},
+		VoxelSize: voxel,
+		Origin:    m.Origin,
+		Threshold: threshold,
+	}
+
+	// Density weighted center of mass
+	var com [3]float64
+	total := 0.0
+	count := 0
+	for k := 0; k < m.NZ; k++ {
+		for j := 0; j < m.NY; j++ {
+			for i := 0; i < m.NX; i++ {
+				v := float64(m.At(i, j, k))
+				if !finite(v) {
+					continue
+				}
+				if v > threshold {
+					count++
+				}
+				if v <= 0 {
+					continue
+				}
+				p := m.position(i, j, k, voxel)
+				for a := 0; a < 3; a++ {
+					com[a] += v * p[a]
+				}
+				total += v
+			}
+		}
+	}
+
+	stats.Volume = float64(count) * voxel[0] * voxel[1] * voxel[2]
+
+	if total > 0 {
+		for a := 0; a < 3; a++ {
+			com[a] /= total
+		}
+
+		rg := 0.0
+		for k := 0; k < m.NZ; k++ {
+			for j := 0; j < m.NY; j++ {
+				for i := 0; i < m.NX; i++ {
+					v := float64(m.At(i, j, k))
+					if v <= 0 || !finite(v) {
+						continue
+					}
+					p := m.position(i, j, k, voxel)
+					for a := 0; a < 3; a++ {
+						rg += v * (p[a] - com[a]) * (p[a] - com[a])
+					}
+				}
+			}
+		}
+		stats.Rg = math.Sqrt(rg / total)
+	}
+
+	stats.Dmax = m.maxDimension(threshold, voxel)
+
+	return stats
+}
+
+// Maximum distance between any two voxels on the surface of the density
+// enclosed by threshold
+func (m *Map) maxDimension(threshold float64, voxel [3]float64) float64 {
+	inside := func(i, j, k int) bool {
+		if i < 0 || j < 0 || k < 0 || i >= m.NX || j >= m.NY || k >= m.NZ {
+			return false
+		}
+		v := float64(m.At(i, j, k))
+		return finite(v) && v > threshold
+	}
+
+	surface := make([][3]float64, 0)
+	for k := 0; k < m.NZ; k++ {
+		for j := 0; j < m.NY; j++ {
+			for i := 0; i < m.NX; i++ {
+				if !inside(i, j, k) {
+					continue
+				}
+				if inside(i-1, j, k) && inside(i+1, j, k) &&
+					inside(i, j-1, k) && inside(i, j+1, k) &&
+					inside(i, j, k-1) && inside(i, j, k+1) {
+					continue
+				}
+				surface = append(surface, m.position(i, j, k, voxel))
+			}
+		}
+	}
+
+	if len(surface) > maxSurfaceVoxels {
+		stride := float64(len(surface)) / maxSurfaceVoxels
+		sampled := make([][3]float64, 0, maxSurfaceVoxels)
+		for f := 0.0; int(f) < len(surface); f += stride {
+			sampled = append(sampled, surface[int(f)])
+		}
+		surface = sampled
+	}
+
+	max := 0.0
+	for a := 0; a < len(surface); a++ {
+		for b := a + 1; b < len(surface); b++ {
+			dx := surface[a][0] - surface[b][0]
+			dy := surface[a][1] - surface[b][1]
+			dz := surface[a][2] - surface[b][2]
+			d := dx*dx + dy*dy + dz*dz
+			if d > max {
+				max = d
+			}
+		}
+	}
+
+	return math.Sqrt(max)
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Require HTTP basic auth with admin_user and admin_password
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(viper.GetString("admin_user"))) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(viper.GetString("admin_password"))) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="DENSSWeb Admin"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func AdminHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.FormValue("offset"))
+		if offset <= 0 {
+			offset = 0
+		}
+
+		prev := offset - 50
+		if prev <= 0 {
+			prev = 0
+		}
+		next := offset + 50
+
+		stats, err := model.FetchOutboxStats(ctx.DB)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"err": err,
+			}).Error("Failed to fetch outbox stats from db")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		outbox, err := model.FetchOutbox(ctx.DB, 50, offset)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"err": err,
+			}).Error("Failed to fetch outbox from db")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		vars := map[string]interface{}{
+			"notifications": viper.GetBool("enable_notifications"),
+			"outboxStats":   stats,
+			"outbox":        outbox,
+			"prev":          prev,
+			"next":          next}
+		ctx.RenderTemplate(w, "admin.html", vars)
+	})
+}
+
+// Queue a failed email for delivery again
+func AdminRetryEmailHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		err := model.RetryEmail(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"err":      err,
+				"email_id": id,
+			}).Error("Failed to retry email")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	})
+}
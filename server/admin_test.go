@@ -0,0 +1,59 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+)
+
+func TestAdminAuth(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	viper.Set("admin_password", "secret")
+	defer viper.Set("admin_password", nil)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := adminAuth(AdminHandler(ctx))
+
+	tests := map[string]int{
+		"":       http.StatusUnauthorized,
+		"wrong":  http.StatusUnauthorized,
+		"secret": http.StatusOK,
+	}
+
+	for pass, code := range tests {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		if pass != "" {
+			r.SetBasicAuth("admin", pass)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("Incorrect status with password %q: got %d should be %d", pass, w.Code, code)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+var (
+	tokenRegexp = regexp.MustCompile(`^` + TokenPattern + `$`)
+)
+
+// Job token from a token or a job URL
+func parseJobToken(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "/")
+	return path.Base(s)
+}
+
+// Problem with a job entered on the compare form. Shown to the user
+type compareError string
+
+func (e compareError) Error() string {
+	return string(e)
+}
+
+// Fetch a completed job to compare by token or URL. Returns a compareError if
+// the job can't be compared
+func fetchCompareJob(ctx *app.AppContext, s, label string) (*model.Job, error) {
+	token := parseJobToken(s)
+	if token == "" || !tokenRegexp.MatchString(token) {
+		return nil, compareError(fmt.Sprintf("Please enter the URL or ID of job %s", label))
+	}
+
+	job, err := model.FetchJob(ctx.DB, token)
+	if err == sql.ErrNoRows {
+		return nil, compareError(fmt.Sprintf("Job %s not found", label))
+	} else if err != nil {
+		return nil, err
+	}
+
+	if job.StatusID != model.StatusComplete {
+		return nil, compareError(fmt.Sprintf("Job %s has not completed", label))
+	}
+
+	return job, nil
+}
+
+// Queue the comparison of the jobs entered on the compare form
+func queueComparison(ctx *app.AppContext, a, b string) (*model.Comparison, error) {
+	jobA, err := fetchCompareJob(ctx, a, "A")
+	if err != nil {
+		return nil, err
+	}
+
+	jobB, err := fetchCompareJob(ctx, b, "B")
+	if err != nil {
+		return nil, err
+	}
+
+	comp, err := model.QueueComparison(ctx.DB, jobA, jobB)
+	if err == model.ErrCompareIncomplete || err == model.ErrCompareSelf {
+		return nil, compareError(err.Error())
+	}
+
+	return comp, err
+}
+
+// Render the compare form on GET, with jobs A and B filled in from the query
+// string, and queue the comparison on POST. Comparisons are only queued by
+// POST so link prefetchers don't start worker jobs
+func CompareHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := r.FormValue("a")
+		b := r.FormValue("b")
+		vars := map[string]interface{}{
+			"a": a,
+			"b": b,
+		}
+
+		if r.Method != "POST" {
+			ctx.RenderTemplate(w, "compare.html", vars)
+			return
+		}
+
+		comp, err := queueComparison(ctx, a, b)
+		if err == nil {
+			http.Redirect(w, r, "/comparison/"+comp.Token, http.StatusFound)
+			return
+		}
+
+		var cerr compareError
+		if !errors.As(err, &cerr) {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"a":     a,
+				"b":     b,
+			}).Error("Failed to queue comparison")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		vars["message"] = cerr.Error()
+		ctx.RenderTemplate(w, "compare.html", vars)
+	})
+}
+
+func fetchComparison(ctx *app.AppContext, w http.ResponseWriter, r *http.Request, files bool) *model.Comparison {
+	id := mux.Vars(r)["id"]
+
+	var comp *model.Comparison
+	var err error
+	if files {
+		comp, err = model.FetchComparisonFiles(ctx.DB, id)
+	} else {
+		comp, err = model.FetchComparison(ctx.DB, id)
+	}
+	if err != nil {
+		requestLog(r).WithFields(log.Fields{
+			"error": err.Error(),
+			"token": id,
+		}).Error("Failed to fetch comparison from database")
+
+		if err == sql.ErrNoRows {
+			ctx.RenderNotFound(w)
+		} else {
+			ctx.RenderError(w, http.StatusInternalServerError)
+		}
+
+		return nil
+	}
+
+	return comp
+}
+
+func ComparisonHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comp := fetchComparison(ctx, w, r, false)
+		if comp == nil {
+			return
+		}
+
+		vars := map[string]interface{}{
+			"comparison": comp,
+		}
+		ctx.RenderTemplate(w, "comparison.html", vars)
+	})
+}
+
+func ComparisonStatusHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comp := fetchComparison(ctx, w, r, false)
+		if comp == nil {
+			return
+		}
+
+		writeJSON(ctx, w, r, comp)
+	})
+}
+
+// Serve one of the files produced by a comparison
+func ComparisonFileHandler(ctx *app.AppContext, file string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comp := fetchComparison(ctx, w, r, true)
+		if comp == nil {
+			return
+		}
+
+		var data []byte
+		contentType := "application/octet-stream"
+		switch file {
+		case "aligned":
+			data = comp.AlignedMap
+		case "difference":
+			data = comp.DifferenceMap
+		case "overlay":
+			data = comp.OverlayChart
+			contentType = "image/png"
+		}
+
+		if len(data) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	})
+}
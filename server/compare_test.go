@@ -0,0 +1,116 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+func TestParseJobToken(t *testing.T) {
+	tests := map[string]string{
+		"C0YfN48ruj10":   "C0YfN48ruj10",
+		" C0YfN48ruj10 ": "C0YfN48ruj10",
+		"https://denss.ccr.buffalo.edu/job/C0YfN48ruj10":  "C0YfN48ruj10",
+		"https://denss.ccr.buffalo.edu/job/C0YfN48ruj10/": "C0YfN48ruj10",
+	}
+
+	for in, expected := range tests {
+		if token := parseJobToken(in); token != expected {
+			t.Errorf("Incorrect token for %q: got %s should be %s", in, token, expected)
+		}
+	}
+}
+
+func TestCompareHandler(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	defer viper.Set("templates", nil)
+	defer viper.Set("dsn", nil)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := make([]*model.Job, 2)
+	for i := range jobs {
+		jobs[i] = &model.Job{Name: "compare", InputData: []byte("test"), FileType: "dat"}
+		err := model.QueueJob(ctx.DB, jobs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs[i].DensityMap = mrc.New(4, 4, 4, 1.0).Encode()
+		err = model.CompleteJob(ctx.DB, jobs[i], model.StatusComplete)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	form := url.Values{"a": {jobs[0].Token}, "b": {jobs[1].Token}}
+
+	w := httptest.NewRecorder()
+	CompareHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/compare?"+form.Encode(), nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="`+jobs[1].Token+`"`) {
+		t.Errorf("GET should render the form with the jobs filled in: got %d", w.Code)
+	}
+	if _, err := model.FetchNextPendingComparison(ctx.DB); err != sql.ErrNoRows {
+		t.Errorf("GET should not queue a comparison: %v", err)
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/compare", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		CompareHandler(ctx).ServeHTTP(w, r)
+		return w
+	}
+
+	w = post(form)
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "/comparison/") {
+		t.Errorf("POST should queue the comparison: got %d", w.Code)
+	}
+	if _, err := model.FetchNextPendingComparison(ctx.DB); err != nil {
+		t.Errorf("Comparison not queued: %v", err)
+	}
+
+	w = post(url.Values{"a": {jobs[0].Token}, "b": {jobs[0].Token}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), model.ErrCompareSelf.Error()) {
+		t.Errorf("Comparing a job with itself should show an error: got %d", w.Code)
+	}
+
+	// Database errors are not shown to the user
+	_, err = ctx.DB.Exec(`drop table comparison`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = post(form)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "comparison") {
+		t.Errorf("Database error should render an error page: got %d", w.Code)
+	}
+}
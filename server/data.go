@@ -0,0 +1,251 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/chart"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Job parameter schema served as JSON
+type paramsResponse struct {
+	// Jobs must use one of the presets and only Params can be set
+	Restricted bool            `json:"restricted"`
+	Presets    []*model.Preset `json:"presets,omitempty"`
+	Params     []*model.Param  `json:"params"`
+}
+
+// FSC curve data served as JSON
+type fscResponse struct {
+	Resolution  float64   `json:"resolution"`
+	Frequency   []float64 `json:"frequency"`
+	Correlation []float64 `json:"correlation"`
+}
+
+// Statistics by step data served as JSON
+type statsResponse struct {
+	Runs []*chart.StepStats `json:"runs"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeFSCCSV(w io.Writer, fsc *chart.FSC) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"frequency", "correlation"})
+	for i := range fsc.Frequency {
+		cw.Write([]string{formatFloat(fsc.Frequency[i]), formatFloat(fsc.Correlation[i])})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func writeStatsCSV(w io.Writer, runs []*chart.StepStats) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"run", "step", "chi2", "rg", "support_volume"})
+	for r, stats := range runs {
+		for i := range stats.Chi2 {
+			cw.Write([]string{
+				strconv.Itoa(r),
+				strconv.Itoa(i),
+				formatFloat(stats.Chi2[i]),
+				formatFloat(stats.Rg[i]),
+				formatFloat(stats.SupportVolume[i]),
+			})
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func writeJSON(ctx *app.AppContext, w http.ResponseWriter, r *http.Request, data interface{}) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		requestLog(r).WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Error encoding data as json")
+		ctx.RenderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func FSCDataHandler(ctx *app.AppContext, format string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		job, err := model.FetchFSCData(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+			}).Error("Failed to fetch job from database")
+
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+			} else {
+				ctx.RenderError(w, http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		if len(job.FSCData) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		var fsc chart.FSC
+		err = json.Unmarshal(job.FSCData, &fsc)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Failed to decode FSC data")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=denss%d-%s-fsc.csv", job.ID, job.Name))
+			writeFSCCSV(w, &fsc)
+			return
+		}
+
+		writeJSON(ctx, w, r, &fscResponse{
+			Resolution:  fsc.Resolution(),
+			Frequency:   fsc.Frequency,
+			Correlation: fsc.Correlation,
+		})
+	})
+}
+
+func StatsDataHandler(ctx *app.AppContext, format string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		job, err := model.FetchStatsData(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+			}).Error("Failed to fetch job from database")
+
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+			} else {
+				ctx.RenderError(w, http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		if len(job.StatsData) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		var runs []*chart.StepStats
+		err = json.Unmarshal(job.StatsData, &runs)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Failed to decode statistics by step data")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=denss%d-%s-stats.csv", job.ID, job.Name))
+			writeStatsCSV(w, runs)
+			return
+		}
+
+		writeJSON(ctx, w, r, &statsResponse{Runs: runs})
+	})
+}
+
+// Render the fit of the density map to the experimental data with normalized
+// residuals as a PNG
+func FitChartHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		job, err := model.FetchFitData(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+			}).Error("Failed to fetch job from database")
+
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+			} else {
+				ctx.RenderError(w, http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		if len(job.FitData) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		var fit chart.Fit
+		err = json.Unmarshal(job.FitData, &fit)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Failed to decode fit data")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		chart.FitChart(&fit).WritePNG(w)
+	})
+}
+
+// Serve the job parameter schema as JSON
+func ParamsHandler(ctx *app.AppContext, opts *submitOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(ctx, w, r, &paramsResponse{
+			Restricted: opts.restrict,
+			Presets:    opts.presets,
+			Params:     opts.params,
+		})
+	})
+}
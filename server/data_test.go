@@ -0,0 +1,60 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ubccr/denssweb/chart"
+)
+
+func TestDataCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	fsc := &chart.FSC{
+		Frequency:   []float64{0, 0.05, 0.1},
+		Correlation: []float64{1, 0.75, 0.125},
+	}
+
+	err := writeFSCCSV(&buf, fsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "frequency,correlation\n0,1\n0.05,0.75\n0.1,0.125\n"
+	if buf.String() != expected {
+		t.Errorf("Incorrect FSC csv: got \n%s\n should be \n%s\n", buf.String(), expected)
+	}
+
+	buf.Reset()
+	runs := []*chart.StepStats{
+		{Chi2: []float64{10, 1.5}, Rg: []float64{15, 14.2}, SupportVolume: []float64{50000, 32000}},
+		{Chi2: []float64{9}, Rg: []float64{15.1}, SupportVolume: []float64{48000}},
+	}
+
+	err = writeStatsCSV(&buf, runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "run,step,chi2,rg,support_volume\n0,0,10,15,50000\n0,1,1.5,14.2,32000\n1,0,9,15.1,48000\n"
+	if buf.String() != expected {
+		t.Errorf("Incorrect stats csv: got \n%s\n should be \n%s\n", buf.String(), expected)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum number of drafts kept in memory. The oldest draft is dropped
+	// when a new one is added past the limit
+	MaxDrafts = 100
+
+	// Maximum total size of the files of the drafts kept in memory. Oldest
+	// drafts are dropped until the drafts fit
+	MaxDraftBytes = 32 << (10 * 2) // 32MB
+)
+
+// Files uploaded with a submission that failed validation. Kept server side so
+// they don't need to be uploaded again when the form is corrected
+type draft struct {
+	id        string
+	inputData []byte
+	inputName string
+	modelData []byte
+	modelName string
+	created   time.Time
+}
+
+// Size of the files of the draft in bytes
+func (d *draft) size() int {
+	return len(d.inputData) + len(d.modelData)
+}
+
+// Short-lived drafts keyed by random ID
+type draftStore struct {
+	sync.Mutex
+	ttl      time.Duration
+	maxBytes int
+	drafts   map[string]*draft
+}
+
+func newDraftStore(ttl time.Duration) *draftStore {
+	return &draftStore{
+		ttl:      ttl,
+		maxBytes: MaxDraftBytes,
+		drafts:   make(map[string]*draft),
+	}
+}
+
+func newDraftID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Remove expired drafts. Must be called with the lock held
+func (s *draftStore) expire() {
+	now := time.Now()
+	for id, d := range s.drafts {
+		if now.Sub(d.created) > s.ttl {
+			delete(s.drafts, id)
+		}
+	}
+}
+
+// Get a copy of the draft by ID. Returns nil if the draft doesn't exist or has
+// expired. The copy can be changed and saved again without holding the lock
+func (s *draftStore) Get(id string) *draft {
+	if id == "" {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.expire()
+
+	d, ok := s.drafts[id]
+	if !ok {
+		return nil
+	}
+
+	c := *d
+	return &c
+}
+
+// Save a copy of the draft, assigning a new ID if it doesn't have one. The
+// expiry time is reset on every save
+func (s *draftStore) Save(d *draft) {
+	if s.ttl <= 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.expire()
+
+	if d.id == "" {
+		d.id = newDraftID()
+	}
+	d.created = time.Now()
+	c := *d
+	s.drafts[d.id] = &c
+
+	for len(s.drafts) > MaxDrafts || s.size() > s.maxBytes {
+		var oldest *draft
+		for _, o := range s.drafts {
+			if oldest == nil || o.created.Before(oldest.created) {
+				oldest = o
+			}
+		}
+		delete(s.drafts, oldest.id)
+	}
+}
+
+// Total size of the files of the drafts. Must be called with the lock held
+func (s *draftStore) size() int {
+	n := 0
+	for _, d := range s.drafts {
+		n += d.size()
+	}
+	return n
+}
+
+// Remove draft by ID
+func (s *draftStore) Delete(id string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.drafts, id)
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Estimated resources of a job and the limits of the server
+type estimateResponse struct {
+	*model.Estimate
+
+	// Human readable memory and runtime
+	MemoryText  string `json:"memory_text,omitempty"`
+	RuntimeText string `json:"runtime_text,omitempty"`
+
+	// Maximum memory in bytes and runtime in seconds. Zero if unlimited
+	MaxMemory  int64 `json:"max_memory"`
+	MaxSeconds int64 `json:"max_seconds"`
+
+	// Invalid parameters and exceeded limits
+	Errors model.FieldErrors `json:"errors,omitempty"`
+}
+
+// Estimate the resources of the job, calibrating the runtime from completed
+// jobs if the last calibration is older than estimate_calibration seconds
+func estimate(ctx *app.AppContext, opts *submitOptions, job *model.Job) (*model.Estimate, error) {
+	maxAge := time.Duration(viper.GetInt("estimate_calibration")) * time.Second
+	if time.Since(opts.estimator.Calibrated()) > maxAge {
+		err := opts.estimator.Calibrate(ctx.DB)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Warn("Failed to calibrate runtime estimate")
+		}
+	}
+
+	return opts.estimator.Estimate(job)
+}
+
+func formatRuntime(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Minute {
+		return "less than a minute"
+	}
+
+	return d.Round(time.Minute).String()
+}
+
+// Estimate the resources of the job and add an error if it exceeds the
+// max_job_memory or max_job_seconds limits or the grid is too large. Other
+// jobs that can't be estimated are not limited
+func checkEstimate(ctx *app.AppContext, opts *submitOptions, job *model.Job, errs model.FieldErrors) *model.Estimate {
+	est, err := estimate(ctx, opts, job)
+	if errors.Is(err, model.ErrGridTooLarge) {
+		errs.Add("", fmt.Sprintf("The grid exceeds the maximum of %d samples per side. Please use a smaller Dmax or oversampling or a larger voxel size",
+			model.MaxGridSize))
+		return nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Info("Unable to estimate job resources")
+		return nil
+	}
+
+	maxMemory := viper.GetInt64("max_job_memory") * 1024 * 1024
+	if maxMemory > 0 && est.Memory > maxMemory {
+		errs.Add("", fmt.Sprintf("The estimated memory of %s exceeds the limit of %s. Please use a smaller Dmax, oversampling or number of samples",
+			humanize.IBytes(uint64(est.Memory)), humanize.IBytes(uint64(maxMemory))))
+	}
+
+	maxSeconds := viper.GetInt64("max_job_seconds")
+	if maxSeconds > 0 && est.Runtime > float64(maxSeconds) {
+		errs.Add("", fmt.Sprintf("The estimated runtime of %s exceeds the limit of %s. Please use a smaller grid or fewer steps or maps",
+			formatRuntime(est.Runtime), formatRuntime(float64(maxSeconds))))
+	}
+
+	return est
+}
+
+// Estimate the resources of a job from the parameters given in the query
+// string. Used to show the estimate live on the submit form
+func EstimateHandler(ctx *app.AppContext, opts *submitOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs := make(model.FieldErrors)
+		job := &model.Job{Email: r.FormValue("email")}
+		setParams(job, opts, r.URL.Query(), errs)
+
+		res := &estimateResponse{
+			MaxMemory:  viper.GetInt64("max_job_memory") * 1024 * 1024,
+			MaxSeconds: viper.GetInt64("max_job_seconds"),
+		}
+
+		if len(errs) == 0 {
+			res.Estimate = checkEstimate(ctx, opts, job, errs)
+		}
+		if res.Estimate != nil {
+			res.MemoryText = humanize.IBytes(uint64(res.Estimate.Memory))
+			res.RuntimeText = formatRuntime(res.Estimate.Runtime)
+		}
+		if len(errs) > 0 {
+			res.Errors = errs
+		}
+
+		writeJSON(ctx, w, r, res)
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+)
+
+func TestEstimateHandler(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	viper.Set("max_job_memory", 100)
+	defer viper.Set("max_job_memory", 0)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := newSubmitOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := EstimateHandler(ctx, opts)
+
+	tests := map[string]bool{
+		"mode=fast":                  true,
+		"dmax=100&voxel=1&mode=slow": false,
+		"dmax=big":                   false,
+	}
+
+	for query, ok := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/estimate?"+query, nil))
+
+		var res estimateResponse
+		err := json.Unmarshal(w.Body.Bytes(), &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != (len(res.Errors) == 0) {
+			t.Errorf("Incorrect estimate for %s: %s", query, w.Body.String())
+		}
+	}
+
+	// Jobs over the memory limit are rejected on submit
+	submit := SubmitHandler(ctx, newDraftStore(time.Minute), opts)
+	w := httptest.NewRecorder()
+	submit.ServeHTTP(w, submitForm(t, map[string]string{"name": "large", "dmax": "100", "voxel": "1"}, testDAT()))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "exceeds the limit of 100 MiB") {
+		t.Errorf("Job over the memory limit should be rejected: got %d", w.Code)
+	}
+
+	// Grids too large to estimate are rejected without a memory limit
+	viper.Set("max_job_memory", 0)
+	w = httptest.NewRecorder()
+	submit.ServeHTTP(w, submitForm(t, map[string]string{"name": "huge", "dmax": "8000", "oversampling": "50", "voxel": "1"}, testDAT()))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "exceeds the maximum of 1024 samples") {
+		t.Errorf("Job with a grid too large should be rejected: got %d", w.Code)
+	}
+}
@@ -24,9 +24,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
-	"strings"
 
 	valid "github.com/asaskevich/govalidator"
 	"github.com/dchest/captcha"
@@ -39,7 +39,8 @@ import (
 )
 
 const (
-	MaxFileSize = 1 << (10 * 2) // 1MB
+	MaxFileSize  = 1 << (10 * 2)  // 1MB
+	MaxModelSize = 10 << (10 * 2) // 10MB
 )
 
 var (
@@ -48,10 +49,31 @@ var (
 
 func IndexHandler(ctx *app.AppContext) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx.RenderTemplate(w, "index.html", nil)
+		stats, err := model.FetchQueueStats(ctx.DB)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Failed to fetch queue stats from database")
+		}
+
+		vars := map[string]interface{}{
+			"queue": stats}
+		ctx.RenderTemplate(w, "index.html", vars)
 	})
 }
 
+// Set the queue position and ETA of the job. Errors are logged and the job
+// is shown without them
+func setQueueInfo(ctx *app.AppContext, job *model.Job) {
+	err := model.SetQueueInfo(ctx.DB, job, viper.GetInt("queue_workers"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"job_id": job.ID,
+		}).Error("Failed to fetch queue position")
+	}
+}
+
 func AboutHandler(ctx *app.AppContext) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx.RenderTemplate(w, "about.html", nil)
@@ -80,7 +102,7 @@ func JobListHandler(ctx *app.AppContext) http.Handler {
 
 		jobs, err := model.FetchAllJobs(ctx.DB, status, 20, offset)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"err": err,
 			}).Error("Failed to fetch jobs from db")
 			ctx.RenderError(w, http.StatusInternalServerError)
@@ -102,9 +124,9 @@ func JobHandler(ctx *app.AppContext) http.Handler {
 		id := mux.Vars(r)["id"]
 		job, err := model.FetchJob(ctx.DB, id)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"error": err.Error(),
-				"id":    id,
+				"token": id,
 			}).Error("Failed to fetch job from database")
 
 			if err == sql.ErrNoRows {
@@ -116,191 +138,318 @@ func JobHandler(ctx *app.AppContext) http.Handler {
 			return
 		}
 
+		setQueueInfo(ctx, job)
+
 		vars := map[string]interface{}{
-			"job": job}
+			"job":      job,
+			"webhooks": jobWebhooks(ctx, job)}
 		ctx.RenderTemplate(w, "job.html", vars)
 	})
 }
 
-func SubmitHandler(ctx *app.AppContext) http.Handler {
+// A job parameter on the submit form with the value entered by the user and
+// any validation errors
+type paramField struct {
+	*model.Param
+	Value   string
+	Checked bool
+	Errors  []string
+}
+
+// Build the parameter fields of the submit form. Without submitted values the
+// choices and checkboxes are set to their defaults
+func paramFields(params []*model.Param, values url.Values, errs model.FieldErrors) []*paramField {
+	fields := make([]*paramField, 0, len(params))
+	for _, p := range params {
+		f := &paramField{Param: p, Errors: errs[p.Name]}
+		if values == nil {
+			if p.Type == model.ParamChoice {
+				f.Value = p.DefaultString()
+			}
+			f.Checked = p.DefaultString() == "true"
+		} else {
+			f.Value = values.Get(p.Name)
+			f.Checked, _ = strconv.ParseBool(f.Value)
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+func SubmitHandler(ctx *app.AppContext, drafts *draftStore, opts *submitOptions) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		message := ""
+		errs := make(model.FieldErrors)
+		var values url.Values
+		d := &draft{}
 
 		if r.Method == "POST" {
-			r.Body = http.MaxBytesReader(w, r.Body, MaxFileSize)
-			err := r.ParseMultipartForm(MaxFileSize)
+			r.Body = http.MaxBytesReader(w, r.Body, MaxFileSize+MaxModelSize)
+			err := r.ParseMultipartForm(MaxFileSize + MaxModelSize)
 			if err != nil {
-				log.WithFields(log.Fields{
+				requestLog(r).WithFields(log.Fields{
 					"err": err,
 				}).Error("Failed to parse multipart form")
 				ctx.RenderError(w, http.StatusInternalServerError)
 				return
 			}
-			files := r.MultipartForm.File["inputFile"]
-			inputData := []byte("")
-			if len(files) > 0 {
-				// Only use first file
-				file, err := files[0].Open()
-				defer file.Close()
-				if err != nil {
-					log.WithFields(log.Fields{
-						"err": err,
-					}).Error("Failed to open input data file")
-					ctx.RenderError(w, http.StatusInternalServerError)
-					return
-				}
-				inputData, err = ioutil.ReadAll(file)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"err": err,
-					}).Error("Failed to read input data file")
-					ctx.RenderError(w, http.StatusInternalServerError)
-					return
-				}
+			values = r.PostForm
+
+			// Files uploaded with a previous attempt are used unless new
+			// files are uploaded
+			if saved := drafts.Get(r.FormValue("draft")); saved != nil {
+				d = saved
 			}
 
-			job, err := submitJob(ctx, inputData, r)
+			inputData, inputName, err := readFormFile(r, "inputFile")
+			if err != nil {
+				requestLog(r).WithFields(log.Fields{
+					"err": err,
+				}).Error("Failed to read input data file")
+				ctx.RenderError(w, http.StatusInternalServerError)
+				return
+			}
+			if inputData != nil {
+				d.inputData, d.inputName = inputData, inputName
+			}
 
-			if err == nil {
+			modelData, modelName, err := readFormFile(r, "modelFile")
+			if err != nil {
+				requestLog(r).WithFields(log.Fields{
+					"err": err,
+				}).Error("Failed to read atomic model file")
+				ctx.RenderError(w, http.StatusInternalServerError)
+				return
+			}
+			if modelData != nil {
+				d.modelData, d.modelName = modelData, modelName
+			}
+
+			var job *model.Job
+			job, errs = submitJob(ctx, opts, d.inputData, d.modelData, r)
+			if len(errs) == 0 {
+				if d.id != "" {
+					drafts.Delete(d.id)
+				}
 				http.Redirect(w, r, job.URL(), 302)
 				return
 			}
 
-			message = err.Error()
+			// Invalid files are not kept
+			if _, ok := errs["inputFile"]; ok {
+				d.inputData, d.inputName = nil, ""
+			}
+			if _, ok := errs["modelFile"]; ok {
+				d.modelData, d.modelName = nil, ""
+			}
+			if len(d.inputData) > 0 || len(d.modelData) > 0 {
+				drafts.Save(d)
+			}
 		}
 
-		vars := map[string]interface{}{
-			"emailEnabled": viper.GetBool("enable_notifications"),
-			"message":      message,
-		}
+		renderSubmit(ctx, w, opts, values, errs, d)
+	})
+}
+
+// Render the submit form with the submitted values and errors
+func renderSubmit(ctx *app.AppContext, w http.ResponseWriter, opts *submitOptions, values url.Values, errs model.FieldErrors, d *draft) {
+	fields := paramFields(opts.params, values, errs)
+	if values == nil {
+		values = url.Values{}
+	}
 
-		if viper.GetBool("enable_captcha") {
-			vars["captchaID"] = captcha.New()
+	// Show the advanced parameters if any of them have errors
+	advanced, advancedErrors := false, false
+	for _, f := range fields {
+		if f.Advanced {
+			advanced = true
+			if len(f.Errors) > 0 {
+				advancedErrors = true
+			}
 		}
+	}
 
-		ctx.RenderTemplate(w, "submit.html", vars)
-	})
+	vars := map[string]interface{}{
+		"emailEnabled":   viper.GetBool("enable_notifications"),
+		"webhooks":       viper.GetBool("job_webhooks"),
+		"errors":         errs,
+		"values":         values,
+		"params":         fields,
+		"advanced":       advanced,
+		"presets":        opts.presets,
+		"notify":         notifyFields(values),
+		"digest":         values.Get("digest") != "",
+		"advancedErrors": advancedErrors,
+		"draftID":        d.id,
+		"inputName":      d.inputName,
+		"modelName":      d.modelName,
+	}
+
+	if viper.GetBool("enable_captcha") {
+		vars["captchaID"] = captcha.New()
+	}
+
+	ctx.RenderTemplate(w, "submit.html", vars)
 }
 
-func submitJob(ctx *app.AppContext, data []byte, r *http.Request) (*model.Job, error) {
+// Read the first file uploaded with the form field name. Returns nil data if
+// no file was uploaded
+func readFormFile(r *http.Request, name string) ([]byte, string, error) {
+	files := r.MultipartForm.File[name]
+	if len(files) == 0 {
+		return nil, "", nil
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, files[0].Filename, nil
+}
+
+// Detect the type of the input data file (out | fit | dat)
+func inputFileType(data []byte) (string, error) {
 	if len(data) == 0 {
-		return nil, errors.New("Please provide an input data file")
+		return "", errors.New("Please provide an input data file")
+	}
+
+	if len(data) > MaxFileSize {
+		return "", errors.New("Input data file must be less than 1MB")
 	}
 
-	fileType := "out"
 	if version, err := parseGNOMHeader(data); err == nil {
 		log.WithFields(log.Fields{
 			"version": version,
 		}).Info("Input data appears to be GNOM")
+		return "out", nil
+	}
+
+	// Check N-column DAT file
+	cols, err := validateDAT(data)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Input data appears to be %d-column DAT file", cols)
+
+	if cols == 4 {
+		return "fit", nil
+	}
+
+	return "dat", nil
+}
+
+// Set the parameters from the form on the job. Values outside the limits set
+// for the submitter by an override are clamped and reported on the job page.
+// Values outside the range of the parameter are form errors
+func setParams(job *model.Job, opts *submitOptions, form url.Values, errs model.FieldErrors) {
+	if opts.restrict {
+		applyPreset(job, opts, form, errs)
 	} else {
-		// Check N-column DAT file
-		cols, err := validateDAT(data)
-		if err != nil {
-			return nil, err
-		}
-		log.Infof("Input data appears to be %d-column DAT file", cols)
+		model.ParseParams(job, form, opts.params, errs)
+	}
 
-		if cols == 4 {
-			fileType = "fit"
-		} else {
-			fileType = "dat"
-		}
+	params := model.ParamsFor(job.Email, opts.overrides)
+	model.SetDefaults(job, params)
+	job.Notices = model.ClampParams(job, params)
+	model.ValidateParams(job, params, errs)
+}
+
+// Validate the submitted form and queue the job. Returns the errors of each
+// form field if the submission is invalid
+func submitJob(ctx *app.AppContext, opts *submitOptions, data, modelData []byte, r *http.Request) (*model.Job, model.FieldErrors) {
+	errs := make(model.FieldErrors)
+
+	fileType, err := inputFileType(data)
+	if err != nil {
+		errs.Add("inputFile", err.Error())
 	}
 
 	captchaID := r.FormValue("captcha_id")
 	captchaSol := r.FormValue("captcha_sol")
 	if viper.GetBool("enable_captcha") {
 		if len(captchaID) == 0 {
-			return nil, errors.New("Invalid captcha provided")
-		}
-		if len(captchaSol) == 0 {
-			return nil, errors.New("Please type in the numbers you see in the picture")
-		}
-
-		if !captcha.VerifyString(captchaID, captchaSol) {
-			return nil, errors.New("The numbers you typed in do not match the image")
+			errs.Add("captcha_sol", "Invalid captcha provided")
+		} else if len(captchaSol) == 0 {
+			errs.Add("captcha_sol", "Please type in the numbers you see in the picture")
+		} else if !captcha.VerifyString(captchaID, captchaSol) {
+			errs.Add("captcha_sol", "The numbers you typed in do not match the image")
 		}
 	}
 
 	job := &model.Job{InputData: data, FileType: fileType}
 
-	err := ctx.Decoder.Decode(job, r.PostForm)
+	if len(modelData) > 0 {
+		if len(modelData) > MaxModelSize {
+			errs.Add("modelFile", "Atomic model file must be less than 10MB")
+		} else if modelType, err := validateModel(modelData); err != nil {
+			errs.Add("modelFile", err.Error())
+		} else {
+			job.ModelData = modelData
+			job.ModelType = modelType
+		}
+	}
+
+	err = ctx.Decoder.Decode(job, r.PostForm)
 	if err != nil {
 		switch serr := err.(type) {
 		case schema.ConversionError:
-			return nil, errors.New(fmt.Sprintf("Invalid data for %s", serr.Key))
+			errs.Add(serr.Key, fmt.Sprintf("Invalid data for %s", serr.Key))
 		case schema.MultiError:
-			msg := make([]string, 0)
-			for k, _ := range serr {
-				msg = append(msg, fmt.Sprintf("Invalid data for %s", k))
+			for k := range serr {
+				errs.Add(k, fmt.Sprintf("Invalid data for %s", k))
 			}
-			return nil, errors.New(strings.Join(msg, ";"))
 		default:
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"err": err,
 			}).Error("Failed to decode form input")
-			return nil, errors.New("The input data you provided is invalid")
+			errs.Add("", "The input data you provided is invalid")
 		}
 	}
 
 	if len(job.Name) == 0 {
-		return nil, errors.New("Job name is required")
-	}
-
-	if len(job.Name) > 255 {
-		return nil, errors.New("Job name must be less than 255 characters")
-	}
-
-	if !JobNameRegexp.MatchString(job.Name) {
-		return nil, errors.New("Job name must be alphanumeric")
+		errs.Add("name", "Job name is required")
+	} else if len(job.Name) > 255 {
+		errs.Add("name", "Job name must be less than 255 characters")
+	} else if !JobNameRegexp.MatchString(job.Name) {
+		errs.Add("name", "Job name must be alphanumeric")
 	}
 
 	if len(job.Email) > 0 && !valid.IsEmail(job.Email) {
-		return nil, errors.New("Please provide a valid email address")
+		errs.Add("email", "Please provide a valid email address")
 	}
 
-	// Validate parameters to sane default ranges
-	// *Note* range validator tag is not currently working.
-	// See: https://github.com/asaskevich/govalidator/issues/223
-	if job.Dmax > 0 && !valid.InRangeFloat64(job.Dmax, 1, 100000000) {
-		return nil, errors.New("Dmax should be between 1 and 100000000")
-	}
-	if job.Electrons > 0 && !valid.InRangeInt(job.Electrons, 1, 100000000) {
-		return nil, errors.New("Electrons should be between 1 and 1e8")
-	}
-	if !valid.Matches(job.Mode, "(fast|slow|membrane)") {
-		return nil, errors.New("Job mode should be one of fast, slow, or membrane")
-	}
-	if !valid.Matches(job.Units, "(a|nm)") {
-		return nil, errors.New("Angular units should be a or nm")
-	}
-	if job.Symmetry > 0 && !valid.InRangeInt(job.Symmetry, 0, 500) {
-		return nil, errors.New("Symmetry should less than 500")
+	setParams(job, opts, r.PostForm, errs)
+	model.ParseNotify(job, r.PostForm)
+	if viper.GetBool("job_webhooks") {
+		setWebhook(job, r.PostForm, errs)
 	}
-	if job.SymmetryAxis > 0 && !valid.InRangeInt(job.SymmetryAxis, 0, 4) {
-		return nil, errors.New("Symmetry should be 1, 2 or 3")
+	if len(errs) == 0 {
+		checkEstimate(ctx, opts, job, errs)
 	}
-	if job.SymmetrySteps != "" {
-		parts := strings.Split(job.SymmetrySteps, " ")
-		for _, i := range parts {
-			_, err := strconv.Atoi(i)
-			if err != nil {
-				return nil, fmt.Errorf("Symmetry steps should be a number: %s", i)
-			}
-		}
+
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
-	job.Method = "denss"
+	job.Method = model.MethodDenss
 
 	err = model.QueueJob(ctx.DB, job)
 	if err != nil {
-		log.WithFields(log.Fields{
+		requestLog(r).WithFields(log.Fields{
 			"err": err,
 		}).Error("Failed to queue job")
-		return nil, errors.New("Failed to submit job. Please contact system administrator")
+		errs.Add("", "Failed to submit job. Please contact system administrator")
+		return nil, errs
 	}
 
-	log.WithFields(log.Fields{
+	requestLog(r).WithFields(log.Fields{
 		"ID":           job.ID,
 		"URL":          job.URL(),
 		"FileType":     job.FileType,
@@ -313,48 +462,19 @@ func submitJob(ctx *app.AppContext, data []byte, r *http.Request) (*model.Job, e
 		"MaxRuns":      job.MaxRuns,
 		"Mode":         job.Mode,
 		"Fit":          job.Fit,
+		"ModelType":    job.ModelType,
 	}).Info("Job queued successfully")
 
-	if len(job.Email) > 0 {
-		err = ctx.SendEmail(job.Email, "SUBMITTED", job.URL(), job.ID)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"job_id": job.ID,
-				"email":  job.Email,
-				"url":    job.URL(),
-				"status": "SUBMITTED",
-				"error":  err,
-			}).Error("Failed to send email")
-		}
-	}
-
-	return job, nil
-}
-
-func parseFloat(n, label string) (float64, error) {
-	if n == "" {
-		return float64(0.0), nil
+	for _, msg := range job.Notices {
+		requestLog(r).WithFields(log.Fields{
+			"ID":    job.ID,
+			"email": job.Email,
+		}).Warn(msg)
 	}
 
-	f, err := strconv.ParseFloat(n, 64)
-	if err != nil {
-		return float64(0.0), fmt.Errorf("Please provide a float for %s", label)
-	}
-
-	return f, nil
-}
-
-func parseInt(n, label string) (int64, error) {
-	if n == "" {
-		return int64(0), nil
-	}
+	ctx.Notify(job, model.EventQueued, "")
 
-	i, err := strconv.Atoi(n)
-	if err != nil {
-		return int64(0), fmt.Errorf("Please provide an integer for %s", label)
-	}
-
-	return int64(i), nil
+	return job, nil
 }
 
 func DensityMapHandler(ctx *app.AppContext) http.Handler {
@@ -362,9 +482,9 @@ func DensityMapHandler(ctx *app.AppContext) http.Handler {
 		id := mux.Vars(r)["id"]
 		job, err := model.FetchDensityMap(ctx.DB, id)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"error": err.Error(),
-				"id":    id,
+				"token": id,
 			}).Error("Failed to fetch job from database")
 
 			if err == sql.ErrNoRows {
@@ -386,14 +506,66 @@ func DensityMapHandler(ctx *app.AppContext) http.Handler {
 	})
 }
 
+// Serve the atomic model uploaded with the job, the density map aligned to it
+// or the density computed from the model
+func ModelFileHandler(ctx *app.AppContext, file string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		job, err := model.FetchModelFiles(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+			}).Error("Failed to fetch job from database")
+
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+			} else {
+				ctx.RenderError(w, http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		var data []byte
+		var name string
+		contentType := "application/octet-stream"
+		switch file {
+		case "model":
+			if ext := mux.Vars(r)["ext"]; ext == job.ModelType {
+				data = job.ModelData
+				name = "model." + ext
+				contentType = "text/plain"
+			}
+		case "aligned":
+			data = job.AlignedMap
+			name = "aligned-map.ccp4"
+		case "density":
+			data = job.ModelMap
+			name = "model-map.ccp4"
+		}
+
+		if len(data) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		// The model is uploaded by the user so browsers must not render it
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"denss%d-%s-%s\"", job.ID, job.Name, name))
+		w.Write(data)
+	})
+}
+
 func FSCChartHandler(ctx *app.AppContext) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
 		job, err := model.FetchFSCChart(ctx.DB, id)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"error": err.Error(),
-				"id":    id,
+				"token": id,
 			}).Error("Failed to fetch job from database")
 
 			if err == sql.ErrNoRows {
@@ -420,9 +592,9 @@ func RawDataHandler(ctx *app.AppContext) http.Handler {
 		id := mux.Vars(r)["id"]
 		job, err := model.FetchRawData(ctx.DB, id)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"error": err.Error(),
-				"id":    id,
+				"token": id,
 			}).Error("Failed to fetch job from database")
 
 			if err == sql.ErrNoRows {
@@ -449,9 +621,9 @@ func StatusHandler(ctx *app.AppContext) http.Handler {
 		id := mux.Vars(r)["id"]
 		job, err := model.FetchJob(ctx.DB, id)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"error": err.Error(),
-				"id":    id,
+				"token": id,
 			}).Error("Failed to fetch job from database")
 
 			if err == sql.ErrNoRows {
@@ -469,11 +641,13 @@ func StatusHandler(ctx *app.AppContext) http.Handler {
 			job.Time = job.RunTime()
 		}
 
+		setQueueInfo(ctx, job)
+
 		out, err := json.Marshal(job)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err.Error(),
-				"id":    job.ID,
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
 			}).Error("Error encoding job as json")
 			ctx.RenderError(w, http.StatusInternalServerError)
 			return
@@ -489,9 +663,9 @@ func SummaryChartHandler(ctx *app.AppContext) http.Handler {
 		id := mux.Vars(r)["id"]
 		job, err := model.FetchSummaryChart(ctx.DB, id)
 		if err != nil {
-			log.WithFields(log.Fields{
+			requestLog(r).WithFields(log.Fields{
 				"error": err.Error(),
-				"id":    id,
+				"token": id,
 			}).Error("Failed to fetch job from database")
 
 			if err == sql.ErrNoRows {
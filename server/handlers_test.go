@@ -0,0 +1,332 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+func TestDraftStore(t *testing.T) {
+	drafts := newDraftStore(time.Minute)
+
+	d := &draft{inputData: []byte("data")}
+	drafts.Save(d)
+	if d.id == "" {
+		t.Fatal("Draft should be assigned an ID")
+	}
+
+	saved := drafts.Get(d.id)
+	if saved == nil || string(saved.inputData) != "data" {
+		t.Fatalf("Failed to get saved draft")
+	}
+
+	saved.inputData = []byte("changed")
+	d.inputName = "changed.dat"
+	if again := drafts.Get(d.id); string(again.inputData) != "data" || again.inputName != "" {
+		t.Errorf("Changing a draft should not change the store until saved")
+	}
+
+	drafts.drafts[d.id].created = time.Now().Add(-2 * time.Minute)
+	if drafts.Get(d.id) != nil {
+		t.Errorf("Expired draft should be removed")
+	}
+
+	for i := 0; i < MaxDrafts+5; i++ {
+		drafts.Save(&draft{})
+	}
+	if len(drafts.drafts) != MaxDrafts {
+		t.Errorf("Incorrect number of drafts: got %d should be %d", len(drafts.drafts), MaxDrafts)
+	}
+
+	// Oldest drafts are dropped when the files exceed the size limit
+	drafts = newDraftStore(time.Minute)
+	drafts.maxBytes = 100
+	ids := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		d := &draft{inputData: make([]byte, 30)}
+		drafts.Save(d)
+		ids = append(ids, d.id)
+		drafts.drafts[d.id].created = time.Now().Add(time.Duration(i-5) * time.Second)
+	}
+	if n := drafts.size(); n > drafts.maxBytes {
+		t.Errorf("Drafts exceed the size limit: got %d bytes", n)
+	}
+	if drafts.Get(ids[0]) != nil || drafts.Get(ids[4]) == nil {
+		t.Errorf("Oldest drafts should be dropped first")
+	}
+
+	disabled := newDraftStore(0)
+	disabled.Save(&draft{})
+	if len(disabled.drafts) != 0 {
+		t.Errorf("Drafts should not be saved when disabled")
+	}
+}
+
+// Build multipart submit form with optional input data file
+func submitForm(t *testing.T, fields map[string]string, data []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if data != nil {
+		fw, err := mw.CreateFormFile("inputFile", "6lyz.dat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/submit", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// 3-column DAT file for submitting jobs
+func testDAT() []byte {
+	var data []byte
+	data = append(data, "# q I error\n"...)
+	for i := 1; i <= 20; i++ {
+		data = append(data, fmt.Sprintf("%.4f %.4f %.4f\n", float64(i)*0.01, 100/float64(i), 0.1)...)
+	}
+
+	return data
+}
+
+func TestSubmitDraft(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testDAT()
+
+	opts, err := newSubmitOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := SubmitHandler(ctx, newDraftStore(time.Minute), opts)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, submitForm(t, map[string]string{"name": "bad name!", "dmax": "big", "mode": "fast"}, data))
+
+	body := w.Body.String()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Invalid submission should render the form: got %d", w.Code)
+	}
+	for _, s := range []string{"Job name must be alphanumeric", "Please provide a number for", `value="bad name!"`, `value="big"`, "6lyz.dat"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("Submit form missing %q", s)
+		}
+	}
+	if !regexp.MustCompile(`value="fast" checked`).MatchString(body) {
+		t.Errorf("Submitted mode should be checked")
+	}
+
+	m := regexp.MustCompile(`name="draft" value="([^"]+)"`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("Submit form missing draft ID")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, submitForm(t, map[string]string{"name": "lysozyme", "draft": m[1]}, nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("Submission with draft input file should succeed: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSubmitPreset(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	viper.Set("restrict_params", true)
+	viper.Set("presets", []map[string]interface{}{
+		{"name": "standard", "params": map[string]interface{}{"mode": "fast"}},
+		{"name": "membrane", "params": map[string]interface{}{"mode": "membrane", "ncs": 2, "ncs_steps": []interface{}{3000, 5000}}},
+	})
+	defer viper.Set("restrict_params", false)
+	defer viper.Set("presets", nil)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := newSubmitOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := SubmitHandler(ctx, newDraftStore(time.Minute), opts)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, submitForm(t, map[string]string{"name": "lysozyme", "preset": "missing"}, testDAT()))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Please choose a parameter preset") {
+		t.Errorf("Unknown preset should be rejected: got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, submitForm(t, map[string]string{"name": "lysozyme", "preset": "membrane", "dmax": "50", "mode": "slow", "steps": "9000"}, testDAT()))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Submission with preset should succeed: got %d", w.Code)
+	}
+
+	loc := w.Header().Get("Location")
+	job, err := model.FetchJob(ctx.DB, loc[strings.LastIndex(loc, "/")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Preset != "membrane" || job.Mode != "membrane" || job.Symmetry != 2 || job.SymmetrySteps != "3000 5000" {
+		t.Errorf("Preset not applied: %+v", job.ExtraParams)
+	}
+	if job.Dmax != 50 {
+		t.Errorf("Allowed parameter not set: got dmax %f", job.Dmax)
+	}
+	if job.MaxSteps != 3000 {
+		t.Errorf("Parameter not allowed should be ignored: got steps %d", job.MaxSteps)
+	}
+}
+
+func TestSubmitOptionsInvalid(t *testing.T) {
+	viper.Set("restrict_params", true)
+	defer viper.Set("restrict_params", false)
+	defer viper.Set("presets", nil)
+
+	for _, presets := range [][]map[string]interface{}{
+		{{"name": "bad", "params": map[string]interface{}{"unknown": 1}}},
+		{{"name": "bad", "params": map[string]interface{}{"dmax": -1}}},
+		{{"name": "twice"}, {"name": "twice"}},
+	} {
+		viper.Set("presets", presets)
+		if _, err := newSubmitOptions(); err == nil {
+			t.Errorf("Invalid presets should fail: %v", presets)
+		}
+	}
+}
+
+func TestSubmitLimits(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	viper.Set("limit_overrides", []map[string]interface{}{
+		{"name": "internal", "domains": []string{"example.edu"}, "limits": map[string]interface{}{"nmaps": map[string]interface{}{"max": 50}}},
+		{"name": "busy", "domains": []string{"example.org"}, "limits": map[string]interface{}{"nmaps": map[string]interface{}{"max": 10}}},
+	})
+	defer viper.Set("limit_overrides", nil)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := newSubmitOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := SubmitHandler(ctx, newDraftStore(time.Minute), opts)
+
+	tests := []struct {
+		email string
+		nmaps string
+		runs  int64
+	}{
+		{"user@example.edu", "50", 50},
+		{"user@example.org", "15", 10},
+		{"user@example.com", "15", 15},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, submitForm(t, map[string]string{"name": "lysozyme", "email": test.email, "nmaps": test.nmaps}, testDAT()))
+		if w.Code != http.StatusFound {
+			t.Fatalf("Submission should succeed for %s: got %d", test.email, w.Code)
+		}
+
+		loc := w.Header().Get("Location")
+		job, err := model.FetchJob(ctx.DB, loc[strings.LastIndex(loc, "/")+1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.MaxRuns != test.runs {
+			t.Errorf("Incorrect runs for %s: expected %d got %d", test.email, test.runs, job.MaxRuns)
+		}
+		if (test.email == "user@example.org") != (len(job.Notices) == 1) {
+			t.Errorf("Only runs clamped by an override should be reported for %s: %v", test.email, job.Notices)
+		}
+	}
+
+	for _, fields := range []map[string]string{
+		{"email": "user@example.com", "nmaps": "50"},
+		{"email": "user@example.org", "nmaps": "50"},
+		{"email": "user@example.edu", "dmax": "20000"},
+	} {
+		fields["name"] = "lysozyme"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, submitForm(t, fields, testDAT()))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "should be between") {
+			t.Errorf("Values outside the parameter range should be form errors: %v got %d", fields, w.Code)
+		}
+	}
+}
+
+func TestModelFileHandler(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &model.Job{Name: "model", InputData: []byte("test"), FileType: "dat", ModelData: []byte("<html>"), ModelType: "pdb"}
+	err = model.QueueJob(ctx.DB, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/job/"+job.Token+"/model.pdb", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": job.Token, "ext": "pdb"})
+	w := httptest.NewRecorder()
+	ModelFileHandler(ctx, "model").ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != "<html>" {
+		t.Fatalf("Failed to fetch model file: got %d", w.Code)
+	}
+	if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Errorf("Model file should not be sniffed: got %q", nosniff)
+	}
+	expected := fmt.Sprintf(`attachment; filename="denss%d-model-model.pdb"`, job.ID)
+	if cd := w.Header().Get("Content-Disposition"); cd != expected {
+		t.Errorf("Incorrect content disposition: got %q should be %q", cd, expected)
+	}
+}
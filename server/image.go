@@ -0,0 +1,151 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+const (
+	// Size in pixels of the longest side of slice images
+	SliceImageSize = 256
+)
+
+func JobImageHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		name := mux.Vars(r)["name"]
+		img, err := model.FetchJobImage(ctx.DB, id, name)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+				return
+			}
+
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+				"name":  name,
+			}).Error("Failed to fetch job image from database")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	})
+}
+
+// Render a slice through the map along the axis and index given in the
+// request. Index defaults to the center of the map
+func sliceImage(r *http.Request, m *mrc.Map) ([]byte, error) {
+	axisName := r.FormValue("axis")
+	if axisName == "" {
+		axisName = "z"
+	}
+	axis, err := mrc.ParseAxis(axisName)
+	if err != nil {
+		return nil, err
+	}
+
+	index := m.Dim(axis) / 2
+	if i := r.FormValue("index"); i != "" {
+		index, err = strconv.Atoi(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	img, err := m.Slice(axis, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, mrc.Scale(img, SliceImageSize))
+	return buf.Bytes(), err
+}
+
+func SliceHandler(ctx *app.AppContext, cache *fileCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		key := fmt.Sprintf("%s/slice/%s/%s", id, r.FormValue("axis"), r.FormValue("index"))
+		if data, ok := cache.Get(key); ok {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(data)
+			return
+		}
+
+		job, err := model.FetchDensityMap(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+			}).Error("Failed to fetch job from database")
+
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+			} else {
+				ctx.RenderError(w, http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		if len(job.DensityMap) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		m, err := mrc.Decode(job.DensityMap)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Failed to parse density map")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		data, err := sliceImage(r, m)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Warn("Invalid slice")
+			ctx.RenderError(w, http.StatusBadRequest)
+			return
+		}
+
+		cache.Add(key, data)
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	})
+}
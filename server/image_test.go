@@ -0,0 +1,56 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ubccr/denssweb/mrc"
+)
+
+func TestSliceImage(t *testing.T) {
+	m := mrc.New(8, 8, 4, 1.0)
+
+	for _, query := range []string{"", "?axis=x", "?axis=y&index=7", "?axis=z&index=0"} {
+		r := httptest.NewRequest("GET", "/job/x/slice.png"+query, nil)
+		data, err := sliceImage(r, m)
+		if err != nil {
+			t.Fatalf("Failed to render slice %q: %s", query, err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := img.Bounds()
+		if b.Dx() != SliceImageSize && b.Dy() != SliceImageSize {
+			t.Errorf("Incorrect slice image size for %q: got %v", query, b)
+		}
+	}
+
+	for _, query := range []string{"?axis=w", "?axis=z&index=4", "?index=-1", "?index=abc"} {
+		r := httptest.NewRequest("GET", "/job/x/slice.png"+query, nil)
+		if _, err := sliceImage(r, m); err == nil {
+			t.Errorf("Invalid slice %q should return an error", query)
+		}
+	}
+}
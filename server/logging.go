@@ -0,0 +1,101 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+)
+
+const (
+	// Header carrying the request ID. Set on every response and accepted
+	// from a reverse proxy on the request
+	RequestIDHeader = "X-Request-ID"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+)
+
+var (
+	// Request IDs accepted from the client
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9\-_.]{1,64}$`)
+)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// ID of the request set by the request logging middleware
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// Logger with the ID of the request
+func requestLog(r *http.Request) *log.Entry {
+	return log.WithField("request_id", requestID(r))
+}
+
+// Middleware assigning each request an ID and logging the request once it
+// completes
+func requestLogger() negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		next(w, r)
+
+		status := http.StatusOK
+		size := 0
+		if rw, ok := w.(negroni.ResponseWriter); ok {
+			if rw.Status() != 0 {
+				status = rw.Status()
+			}
+			size = rw.Size()
+		}
+
+		requestLog(r).WithFields(log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   status,
+			"bytes":    size,
+			"duration": time.Since(start).Seconds(),
+			"remote":   r.RemoteAddr,
+		}).Info("HTTP request")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/urfave/negroni"
+)
+
+func TestRequestLogger(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	var handlerID string
+	n := negroni.New()
+	n.Use(requestLogger())
+	n.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = requestID(r)
+		requestLog(r).Warn("Inside handler")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("ok"))
+	}))
+
+	rr := httptest.NewRecorder()
+	n.ServeHTTP(rr, httptest.NewRequest("POST", "/submit", nil))
+
+	id := rr.Header().Get(RequestIDHeader)
+	if len(id) != 16 || id != handlerID {
+		t.Fatalf("Request ID not set: header %q handler %q", id, handlerID)
+	}
+
+	if len(hook.Entries) != 2 {
+		t.Fatalf("Expected 2 log entries got %d", len(hook.Entries))
+	}
+	for _, e := range hook.Entries {
+		if e.Data["request_id"] != id {
+			t.Errorf("Entry %q missing request ID: %v", e.Message, e.Data)
+		}
+	}
+
+	e := hook.LastEntry()
+	if e.Level != logrus.InfoLevel || e.Data["status"] != http.StatusAccepted ||
+		e.Data["method"] != "POST" || e.Data["path"] != "/submit" || e.Data["bytes"] != 2 {
+		t.Errorf("Incorrect request log entry: %v", e.Data)
+	}
+
+	// IDs from a reverse proxy are kept if well formed
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "proxy-123")
+	rr = httptest.NewRecorder()
+	n.ServeHTTP(rr, r)
+	if rr.Header().Get(RequestIDHeader) != "proxy-123" {
+		t.Errorf("Expected proxy request ID got %q", rr.Header().Get(RequestIDHeader))
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	n.ServeHTTP(rr, r)
+	if rr.Header().Get(RequestIDHeader) == "bad id\n" {
+		t.Errorf("Malformed request ID should be replaced")
+	}
+}
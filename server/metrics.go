@@ -0,0 +1,66 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ubccr/denssweb/app"
+	"github.com/urfave/negroni"
+)
+
+const (
+	// Route label of requests not matching any route
+	UnmatchedRoute = "unmatched"
+)
+
+// Path template of the route matching the request. Labeling by template
+// rather than path keeps the number of series independent of the number of
+// jobs
+func routeName(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.Route == nil {
+		return UnmatchedRoute
+	}
+
+	tmpl, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return UnmatchedRoute
+	}
+
+	return tmpl
+}
+
+// Middleware recording the latency of each request by route, method and
+// response code
+func requestMetrics(router *mux.Router) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		route := routeName(router, r)
+
+		next(w, r)
+
+		status := http.StatusOK
+		if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+		app.ObserveRequest(route, r.Method, status, time.Since(start))
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ubccr/denssweb/app"
+	"github.com/urfave/negroni"
+)
+
+func TestRouteName(t *testing.T) {
+	router := mux.NewRouter()
+	router.Path("/job/{id:[a-z]+}/status").Handler(http.NotFoundHandler()).Methods("GET")
+
+	tests := map[string]string{
+		"/job/abc/status": "/job/{id:[a-z]+}/status",
+		"/job/123/status": UnmatchedRoute,
+		"/nowhere":        UnmatchedRoute,
+	}
+	for path, expected := range tests {
+		r := httptest.NewRequest("GET", path, nil)
+		if name := routeName(router, r); name != expected {
+			t.Errorf("%s: expected route %s got %s", path, expected, name)
+		}
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	router := mux.NewRouter()
+	router.Path("/teapot/{id}").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	n := negroni.New()
+	n.Use(requestMetrics(router))
+	n.UseHandler(router)
+
+	rr := httptest.NewRecorder()
+	n.ServeHTTP(rr, httptest.NewRequest("GET", "/teapot/1", nil))
+	n.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/teapot/2", nil))
+
+	if rr.Code != http.StatusTeapot {
+		t.Fatalf("Unexpected status %d", rr.Code)
+	}
+
+	metrics := httptest.NewRecorder()
+	app.RegistryHandler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `denssweb_http_request_duration_seconds_count{code="418",method="GET",route="/teapot/{id}"} 2`
+	if !strings.Contains(metrics.Body.String(), expected+"\n") {
+		t.Errorf("Missing %q in:\n%s", expected, metrics.Body.String())
+	}
+}
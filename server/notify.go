@@ -0,0 +1,163 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+// A notification event checkbox on the submit form
+type notifyField struct {
+	*model.Event
+	Checked bool
+}
+
+// Build the notification event checkboxes of the submit form. Without
+// submitted values the default events are checked. Results only expire with
+// job_retention_days set
+func notifyFields(values url.Values) []*notifyField {
+	job := &model.Job{}
+	if values != nil {
+		model.ParseNotify(job, values)
+	}
+
+	fields := make([]*notifyField, 0, len(model.Events))
+	for _, e := range model.Events {
+		if e.Name == model.EventExpiring && viper.GetInt("job_retention_days") <= 0 {
+			continue
+		}
+		fields = append(fields, &notifyField{Event: e, Checked: job.Notifies(e.Name)})
+	}
+
+	return fields
+}
+
+// Fetch the job in the request path. Renders an error page and returns nil if
+// the job can't be fetched
+func fetchRequestJob(ctx *app.AppContext, w http.ResponseWriter, r *http.Request) *model.Job {
+	id := mux.Vars(r)["id"]
+	job, err := model.FetchJob(ctx.DB, id)
+	if err != nil {
+		requestLog(r).WithFields(log.Fields{
+			"error": err.Error(),
+			"token": id,
+		}).Error("Failed to fetch job from database")
+
+		if err == sql.ErrNoRows {
+			ctx.RenderNotFound(w)
+		} else {
+			ctx.RenderError(w, http.StatusInternalServerError)
+		}
+
+		return nil
+	}
+
+	return job
+}
+
+// Cancel a pending job and redirect to the job page. Jobs that already
+// started running are not cancelled
+func CancelJobHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job := fetchRequestJob(ctx, w, r)
+		if job == nil {
+			return
+		}
+
+		err := model.CancelJob(ctx.DB, job)
+		if err == sql.ErrNoRows {
+			http.Redirect(w, r, job.URL(), http.StatusSeeOther)
+			return
+		} else if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Failed to cancel job")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		requestLog(r).WithFields(log.Fields{
+			"job_id": job.ID,
+			"url":    job.URL(),
+		}).Info("Job cancelled")
+
+		ctx.Notify(job, model.EventCancelled, "")
+
+		http.Redirect(w, r, job.URL(), http.StatusSeeOther)
+	})
+}
+
+// Stop notifications for a job using the signed link in the notification
+// emails. GET asks for confirmation so link scanners don't unsubscribe users.
+// POST unsubscribes, which also handles one-click unsubscribe from mail
+// clients
+func UnsubscribeHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job := fetchRequestJob(ctx, w, r)
+		if job == nil {
+			return
+		}
+
+		sig := r.URL.Query().Get("sig")
+		if !app.ValidUnsubscribe(job, sig) {
+			requestLog(r).WithFields(log.Fields{
+				"job_id": job.ID,
+			}).Warn("Invalid unsubscribe signature")
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		vars := map[string]interface{}{
+			"job": job,
+			"sig": sig,
+		}
+
+		if r.Method == "POST" {
+			job.Notify = []string{}
+			err := model.UpdateParams(ctx.DB, job)
+			if err == nil {
+				err = model.DeleteJobDigests(ctx.DB, job.ID)
+			}
+			if err != nil {
+				requestLog(r).WithFields(log.Fields{
+					"error":  err.Error(),
+					"job_id": job.ID,
+				}).Error("Failed to unsubscribe from job notifications")
+				ctx.RenderError(w, http.StatusInternalServerError)
+				return
+			}
+
+			requestLog(r).WithFields(log.Fields{
+				"job_id": job.ID,
+				"email":  job.Email,
+			}).Info("Unsubscribed from job notifications")
+			vars["unsubscribed"] = true
+		}
+
+		ctx.RenderTemplate(w, "unsubscribe.html", vars)
+	})
+}
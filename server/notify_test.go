@@ -0,0 +1,165 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+func jobRequest(method, path string, job *model.Job) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	return mux.SetURLVars(r, map[string]string{"id": job.Token})
+}
+
+func TestNotifyFields(t *testing.T) {
+	fields := notifyFields(url.Values{})
+	checked := []string{}
+	for _, f := range fields {
+		if f.Name == model.EventExpiring {
+			t.Errorf("Expiring event should only be offered with job_retention_days set")
+		}
+		if f.Checked {
+			checked = append(checked, f.Name)
+		}
+	}
+	if strings.Join(checked, ",") != strings.Join(model.DefaultNotify, ",") {
+		t.Errorf("Default events should be checked: got %v", checked)
+	}
+
+	fields = notifyFields(url.Values{"notify": {"", model.EventStarted}})
+	for _, f := range fields {
+		if f.Checked != (f.Name == model.EventStarted) {
+			t.Errorf("Incorrect checkbox for %s: %t", f.Name, f.Checked)
+		}
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &model.Job{Name: "cancel", InputData: []byte("test"), FileType: "dat"}
+	err = model.QueueJob(ctx.DB, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	CancelJobHandler(ctx).ServeHTTP(w, jobRequest("POST", "/job/"+job.Token+"/cancel", job))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Cancel should redirect to the job: got %d", w.Code)
+	}
+
+	saved, err := model.FetchJob(ctx.DB, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.StatusID != model.StatusCancelled {
+		t.Errorf("Job should be cancelled: %s", saved.Status)
+	}
+
+	w = httptest.NewRecorder()
+	JobHandler(ctx).ServeHTTP(w, jobRequest("GET", "/job/"+job.Token, job))
+	if !strings.Contains(w.Body.String(), "Your job was cancelled") {
+		t.Errorf("Job page should show the job was cancelled")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	viper.Set("secret_key", "secret")
+	viper.Set("enable_notifications", true)
+	viper.Set("email_from", "denss@example.edu")
+	defer viper.Set("secret_key", nil)
+	defer viper.Set("enable_notifications", nil)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &model.Job{Name: "unsubscribe", Email: "x@example.edu", InputData: []byte("test"), FileType: "dat"}
+	err = model.QueueJob(ctx.DB, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.QueueEmail(job, model.EventQueued, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox, err := model.FetchDueEmails(ctx.DB, 1)
+	if err != nil || len(outbox) != 1 {
+		t.Fatalf("Email should be queued: %v", err)
+	}
+	msg := string(outbox[0].Message)
+	start := strings.Index(msg, "List-Unsubscribe: <")
+	if start < 0 {
+		t.Fatal("Email missing unsubscribe link")
+	}
+	link := msg[start+len("List-Unsubscribe: <"):]
+	link = link[:strings.Index(link, ">")]
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := UnsubscribeHandler(ctx)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, jobRequest("GET", u.Path+"?sig=bad", job))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Invalid signature should not be found: got %d", w.Code)
+	}
+
+	// GET only asks for confirmation
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, jobRequest("GET", u.RequestURI(), job))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Stop receiving emails") {
+		t.Errorf("Unsubscribe should ask for confirmation: got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, jobRequest("POST", u.RequestURI(), job))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "You will no longer receive emails") {
+		t.Errorf("Unsubscribe failed: got %d", w.Code)
+	}
+
+	saved, err := model.FetchJob(ctx.DB, job.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Notifies(model.EventCompleted) {
+		t.Errorf("Unsubscribed job should not notify")
+	}
+}
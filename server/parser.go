@@ -236,3 +236,44 @@ func convertGNOM(data []byte, version float64) ([]byte, float64, error) {
 
 	return buf.Bytes(), dmax, nil
 }
+
+// Validate an atomic model file in PDB or mmCIF format. Returns the file type
+// (pdb or cif) or error
+func validateModel(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "text/plain") {
+		log.WithFields(log.Fields{
+			"contentType": contentType,
+		}).Error("Invalid atomic model file uploaded")
+		return "", fmt.Errorf("Invalid atomic model. Please provide a PDB or mmCIF text file")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	cif := false
+	atoms := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data_"):
+			cif = true
+		case strings.HasPrefix(line, "_atom_site."):
+			cif = true
+		case strings.HasPrefix(line, "ATOM") || strings.HasPrefix(line, "HETATM"):
+			atoms++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if atoms == 0 {
+		return "", errors.New("No atoms found in atomic model file")
+	}
+
+	if cif {
+		return "cif", nil
+	}
+
+	return "pdb", nil
+}
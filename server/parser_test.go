@@ -39,7 +39,7 @@ func TestDAT(t *testing.T) {
 0.011 67955744 134552
     `
 
-	err := validateDAT([]byte(good_data))
+	_, err := validateDAT([]byte(good_data))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,7 +50,7 @@ THIS IS NOT ALLOWED
 0.004 68442896 61598.6
     `
 
-	err = validateDAT([]byte(bad_data))
+	_, err = validateDAT([]byte(bad_data))
 	if err == nil {
 		t.Errorf("Invalid DAT provided")
 	}
@@ -246,3 +246,39 @@ func TestConvertGNOM(t *testing.T) {
 		}
 	}
 }
+
+func TestModel(t *testing.T) {
+	pdb := `HEADER    HYDROLASE                               19-MAY-98   6LYZ
+ATOM      1  N   LYS A   1       3.287  10.092  10.329  1.00  5.89           N
+ATOM      2  CA  LYS A   1       2.445  10.457   9.182  1.00  6.82           C
+END
+`
+	fileType, err := validateModel([]byte(pdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileType != "pdb" {
+		t.Errorf("Incorrect model file type: got %s should be pdb", fileType)
+	}
+
+	cif := `data_6LYZ
+loop_
+_atom_site.group_PDB
+_atom_site.id
+_atom_site.type_symbol
+ATOM 1 N
+ATOM 2 C
+`
+	fileType, err = validateModel([]byte(cif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileType != "cif" {
+		t.Errorf("Incorrect model file type: got %s should be cif", fileType)
+	}
+
+	_, err = validateModel([]byte("HEADER    EMPTY\nEND\n"))
+	if err == nil {
+		t.Errorf("Model without atoms should fail")
+	}
+}
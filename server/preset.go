@@ -0,0 +1,155 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"fmt"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/model"
+)
+
+// Parameters offered on the submit form. With restrict_params only the named
+// presets from the config and the parameters listed in preset_params can be
+// chosen
+type submitOptions struct {
+	restrict  bool
+	presets   []*model.Preset
+	params    []*model.Param
+	overrides []*model.LimitOverride
+	estimator *model.Estimator
+}
+
+// Load the submit options from the config. A single preset using the
+// parameter defaults is used if restrict_params is enabled without presets
+func newSubmitOptions() (*submitOptions, error) {
+	var limits map[string]*model.Limit
+	err := viper.UnmarshalKey("param_limits", &limits)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid param_limits: %s", err)
+	}
+
+	err = model.ConfigureParams(limits)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid param_limits: %s", err)
+	}
+
+	opts := &submitOptions{
+		restrict:  viper.GetBool("restrict_params"),
+		params:    model.Params,
+		estimator: model.NewEstimator(),
+	}
+
+	err = viper.UnmarshalKey("limit_overrides", &opts.overrides)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid limit_overrides: %s", err)
+	}
+
+	for _, o := range opts.overrides {
+		if err := o.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !opts.restrict {
+		return opts, nil
+	}
+
+	err = viper.UnmarshalKey("presets", &opts.presets)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid presets: %s", err)
+	}
+
+	if len(opts.presets) == 0 {
+		opts.presets = []*model.Preset{{Name: "default", Description: "Default parameters"}}
+	}
+
+	names := make(map[string]bool)
+	for _, p := range opts.presets {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("Duplicate preset %s", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	opts.params = make([]*model.Param, 0)
+	for _, name := range viper.GetStringSlice("preset_params") {
+		p := model.LookupParam(name)
+		if p == nil {
+			return nil, fmt.Errorf("Unknown parameter in preset_params: %s", name)
+		}
+		opts.params = append(opts.params, p)
+	}
+
+	return opts, nil
+}
+
+// Returns true if users may set the parameter
+func (o *submitOptions) allowed(p *model.Param) bool {
+	for _, a := range o.params {
+		if a.Name == p.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Set the parameters of the chosen preset on the job followed by the
+// parameters users are allowed to set. Allowed parameters left blank keep the
+// value of the preset and values of any other parameters are ignored
+func applyPreset(job *model.Job, opts *submitOptions, form url.Values, errs model.FieldErrors) {
+	preset := model.FindPreset(opts.presets, form.Get("preset"))
+	if preset == nil {
+		errs.Add("preset", "Please choose a parameter preset")
+		return
+	}
+
+	err := preset.Apply(job)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"preset": preset.Name,
+		}).Error("Failed to apply preset")
+		errs.Add("preset", "Invalid parameter preset")
+		return
+	}
+	job.Preset = preset.Name
+
+	params := make([]*model.Param, 0)
+	for _, p := range model.Params {
+		if form.Get(p.Name) == "" {
+			continue
+		}
+		if !opts.allowed(p) {
+			log.WithFields(log.Fields{
+				"param":  p.Name,
+				"preset": preset.Name,
+			}).Warn("Ignoring parameter not allowed with restrict_params")
+			continue
+		}
+		params = append(params, p)
+	}
+
+	model.ParseParams(job, form, params, errs)
+}
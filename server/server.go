@@ -20,6 +20,7 @@ package server
 import (
 	"crypto/tls"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"time"
 
@@ -42,16 +43,38 @@ func init() {
 	viper.SetDefault("show_job_list", true)
 	viper.SetDefault("enable_captcha", false)
 	viper.SetDefault("restrict_params", false)
+	viper.SetDefault("preset_params", []string{"dmax", "units"})
+	viper.SetDefault("surface_cache_size", 32)
+	viper.SetDefault("slice_cache_size", 256)
+	viper.SetDefault("draft_ttl", 1800)
+	viper.SetDefault("max_job_memory", 0)
+	viper.SetDefault("max_job_seconds", 0)
+	viper.SetDefault("estimate_calibration", 3600)
+	viper.SetDefault("queue_workers", 1)
+	viper.SetDefault("admin_user", "admin")
+	viper.SetDefault("enable_metrics", false)
 }
 
 func middleware(ctx *app.AppContext) *negroni.Negroni {
 	router := mux.NewRouter()
+	surfaces := newFileCache(viper.GetInt("surface_cache_size"))
+	slices := newFileCache(viper.GetInt("slice_cache_size"))
+	drafts := newDraftStore(time.Duration(viper.GetInt("draft_ttl")) * time.Second)
+	opts, err := newSubmitOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx.RenderNotFound(w)
 	})
 
-	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(fmt.Sprintf("%s/static", ctx.Tmpldir)))))
+	static, err := fs.Sub(ctx.Assets(), "static")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(static))))
 	router.Path("/about").Handler(AboutHandler(ctx)).Methods("GET")
 	router.Path("/tutorial").Handler(TutorialHandler(ctx)).Methods("GET")
 
@@ -62,16 +85,54 @@ func middleware(ctx *app.AppContext) *negroni.Negroni {
 		router.Path(fmt.Sprintf("/captcha/{cid:%s}.png", TokenPattern)).Handler(captcha.Server(captcha.StdWidth, captcha.StdHeight))
 	}
 
-	router.Path("/submit").Handler(SubmitHandler(ctx)).Methods("GET", "POST")
+	router.Path("/healthz").Handler(app.HealthHandler(func() []app.Check { return nil })).Methods("GET")
+	router.Path("/readyz").Handler(app.HealthHandler(ctx.ReadyChecks)).Methods("GET")
+
+	if viper.GetBool("enable_metrics") {
+		router.Path("/metrics").Handler(ctx.MetricsHandler()).Methods("GET")
+	}
+
+	if viper.GetString("admin_password") != "" {
+		router.Path("/admin").Handler(adminAuth(AdminHandler(ctx))).Methods("GET")
+		router.Path("/admin/outbox/{id:[0-9]+}/retry").Handler(adminAuth(AdminRetryEmailHandler(ctx))).Methods("POST")
+	}
+
+	router.Path("/submit").Handler(SubmitHandler(ctx, drafts, opts)).Methods("GET", "POST")
+	router.Path("/params.json").Handler(ParamsHandler(ctx, opts)).Methods("GET")
+	router.Path("/estimate").Handler(EstimateHandler(ctx, opts)).Methods("GET")
 	router.Path(fmt.Sprintf("/job/{id:%s}", TokenPattern)).Handler(JobHandler(ctx)).Methods("GET")
 	router.Path(fmt.Sprintf("/job/{id:%s}/status", TokenPattern)).Handler(StatusHandler(ctx)).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/cancel", TokenPattern)).Handler(CancelJobHandler(ctx)).Methods("POST")
+	router.Path(fmt.Sprintf("/job/{id:%s}/unsubscribe", TokenPattern)).Handler(UnsubscribeHandler(ctx)).Methods("GET", "POST")
+	router.Path(fmt.Sprintf("/job/{id:%s}/webhooks.json", TokenPattern)).Handler(WebhookLogHandler(ctx)).Methods("GET")
 	router.Path(fmt.Sprintf("/job/{id:%s}/density-map.ccp4", TokenPattern)).Handler(DensityMapHandler(ctx)).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/model.{ext:pdb|cif}", TokenPattern)).Handler(ModelFileHandler(ctx, "model")).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/aligned-map.ccp4", TokenPattern)).Handler(ModelFileHandler(ctx, "aligned")).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/model-map.ccp4", TokenPattern)).Handler(ModelFileHandler(ctx, "density")).Methods("GET")
 	router.Path(fmt.Sprintf("/job/{id:%s}/fsc.png", TokenPattern)).Handler(FSCChartHandler(ctx)).Methods("GET")
 	router.Path(fmt.Sprintf("/job/{id:%s}/summary.png", TokenPattern)).Handler(SummaryChartHandler(ctx)).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/fsc.json", TokenPattern)).Handler(FSCDataHandler(ctx, "json")).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/fsc.csv", TokenPattern)).Handler(FSCDataHandler(ctx, "csv")).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/fit.png", TokenPattern)).Handler(FitChartHandler(ctx)).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/stats.json", TokenPattern)).Handler(StatsDataHandler(ctx, "json")).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/stats.csv", TokenPattern)).Handler(StatsDataHandler(ctx, "csv")).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/slice.png", TokenPattern)).Handler(SliceHandler(ctx, slices)).Methods("GET")
+	router.Path(fmt.Sprintf("/job/{id:%s}/{name:(?:projection|slice)-[xyz]}.png", TokenPattern)).Handler(JobImageHandler(ctx)).Methods("GET")
+	for _, format := range []string{"obj", "stl", "glb"} {
+		router.Path(fmt.Sprintf("/job/{id:%s}/surface.%s", TokenPattern, format)).Handler(SurfaceHandler(ctx, format, surfaces)).Methods("GET")
+	}
 	router.Path(fmt.Sprintf("/job/{id:%s}/denss{jid:[0-9]+}-{name:%s}.zip", TokenPattern, TokenPattern)).Handler(RawDataHandler(ctx)).Methods("GET")
+	router.Path("/compare").Handler(CompareHandler(ctx)).Methods("GET", "POST")
+	router.Path(fmt.Sprintf("/comparison/{id:%s}", TokenPattern)).Handler(ComparisonHandler(ctx)).Methods("GET")
+	router.Path(fmt.Sprintf("/comparison/{id:%s}/status", TokenPattern)).Handler(ComparisonStatusHandler(ctx)).Methods("GET")
+	router.Path(fmt.Sprintf("/comparison/{id:%s}/aligned-map.ccp4", TokenPattern)).Handler(ComparisonFileHandler(ctx, "aligned")).Methods("GET")
+	router.Path(fmt.Sprintf("/comparison/{id:%s}/difference-map.ccp4", TokenPattern)).Handler(ComparisonFileHandler(ctx, "difference")).Methods("GET")
+	router.Path(fmt.Sprintf("/comparison/{id:%s}/overlay.png", TokenPattern)).Handler(ComparisonFileHandler(ctx, "overlay")).Methods("GET")
 	router.Path("/").Handler(IndexHandler(ctx)).Methods("GET")
 
 	n := negroni.New(negroni.NewRecovery())
+	n.Use(requestLogger())
+	n.Use(requestMetrics(router))
 	n.UseHandler(router)
 
 	return n
@@ -80,6 +141,18 @@ func middleware(ctx *app.AppContext) *negroni.Negroni {
 func RunServer(ctx *app.AppContext) {
 	mw := middleware(ctx)
 
+	if viper.GetBool("enable_notifications") {
+		go ctx.RunOutbox()
+	}
+
+	if viper.GetBool("job_webhooks") || viper.IsSet("webhooks") {
+		go ctx.RunWebhooks()
+	}
+
+	if viper.GetInt("job_retention_days") > 0 {
+		go ctx.RunRetention()
+	}
+
 	srv := &http.Server{
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 30 * time.Second,
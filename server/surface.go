@@ -0,0 +1,142 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/mesh"
+	"github.com/ubccr/denssweb/model"
+	"github.com/ubccr/denssweb/mrc"
+)
+
+var surfaceContentTypes = map[string]string{
+	"obj": "text/plain",
+	"stl": "model/stl",
+	"glb": "model/gltf-binary",
+}
+
+// Density level for the surface from the request. An absolute level is given
+// by the level parameter, or in standard deviations above the mean by the
+// sigma parameter. Defaults to map_threshold_sigma
+func surfaceLevel(r *http.Request, m *mrc.Map) (float64, error) {
+	if l := r.FormValue("level"); l != "" {
+		return strconv.ParseFloat(l, 64)
+	}
+
+	sigma := viper.GetFloat64("map_threshold_sigma")
+	if s := r.FormValue("sigma"); s != "" {
+		var err error
+		sigma, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return m.Summary().Sigma(sigma), nil
+}
+
+func writeSurface(s *mesh.Mesh, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "obj":
+		err = s.WriteOBJ(&buf)
+	case "stl":
+		err = s.WriteSTL(&buf)
+	case "glb":
+		err = s.WriteGLB(&buf)
+	default:
+		err = fmt.Errorf("Unsupported surface format: %s", format)
+	}
+
+	return buf.Bytes(), err
+}
+
+func SurfaceHandler(ctx *app.AppContext, format string, cache *fileCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		job, err := model.FetchDensityMap(ctx.DB, id)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error": err.Error(),
+				"token": id,
+			}).Error("Failed to fetch job from database")
+
+			if err == sql.ErrNoRows {
+				ctx.RenderNotFound(w)
+			} else {
+				ctx.RenderError(w, http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		if len(job.DensityMap) == 0 {
+			ctx.RenderNotFound(w)
+			return
+		}
+
+		m, err := mrc.Decode(job.DensityMap)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Failed to parse density map")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		level, err := surfaceLevel(r, m)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Warn("Invalid surface contour level")
+			ctx.RenderError(w, http.StatusBadRequest)
+			return
+		}
+
+		key := fmt.Sprintf("%s/%s/%g", id, format, level)
+		data, ok := cache.Get(key)
+		if !ok {
+			data, err = writeSurface(mesh.Contour(m, level), format)
+			if err != nil {
+				requestLog(r).WithFields(log.Fields{
+					"error":  err.Error(),
+					"job_id": job.ID,
+				}).Error("Failed to generate surface")
+				ctx.RenderError(w, http.StatusInternalServerError)
+				return
+			}
+			cache.Add(key, data)
+		}
+
+		w.Header().Set("Content-Type", surfaceContentTypes[format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"denss%d-%s-surface.%s\"", job.ID, job.Name, format))
+		w.Write(data)
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ubccr/denssweb/mrc"
+)
+
+func TestFileCache(t *testing.T) {
+	c := newFileCache(2)
+	c.Add("a", []byte("a"))
+	c.Add("b", []byte("b"))
+	c.Get("a")
+	c.Add("c", []byte("c"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Least recently used entry was not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if data, ok := c.Get(k); !ok || string(data) != k {
+			t.Errorf("Missing cache entry %s", k)
+		}
+	}
+}
+
+func TestSurfaceLevel(t *testing.T) {
+	m := mrc.New(2, 1, 1, 1.0)
+	m.Data = []float32{0, 2}
+
+	tests := []struct {
+		query string
+		level float64
+	}{
+		{"", 2},
+		{"?sigma=2", 3},
+		{"?level=0.5", 0.5},
+		{"?level=0.5&sigma=2", 0.5},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/job/x/surface.obj"+test.query, nil)
+		level, err := surfaceLevel(r, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level != test.level {
+			t.Errorf("Incorrect level for %q: got %g should be %g", test.query, level, test.level)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/job/x/surface.obj?sigma=abc", nil)
+	if _, err := surfaceLevel(r, m); err == nil {
+		t.Errorf("Invalid sigma should return an error")
+	}
+}
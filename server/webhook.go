@@ -0,0 +1,106 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+const (
+	// Maximum length of a job webhook URL
+	MaxWebhookLength = 2048
+)
+
+// Set the webhook URL and signing secret of the job from the form. Both are
+// required to use a webhook
+func setWebhook(job *model.Job, form url.Values, errs model.FieldErrors) {
+	job.Webhook = strings.TrimSpace(form.Get("webhook"))
+	if job.Webhook == "" {
+		return
+	}
+
+	if len(job.Webhook) > MaxWebhookLength {
+		errs.Add("webhook", "Webhook URL must be less than 2048 characters")
+	} else if err := model.ValidateWebhookURL(job.Webhook); err != nil {
+		errs.Add("webhook", err.Error())
+	} else if err := model.ValidatePublicWebhookURL(job.Webhook); err != nil && !viper.GetBool("job_webhooks_allow_private") {
+		errs.Add("webhook", err.Error())
+	}
+
+	job.WebhookSecret = form.Get("webhook_secret")
+	if job.WebhookSecret == "" {
+		errs.Add("webhook_secret", "Please provide a secret to sign webhook requests")
+	} else if len(job.WebhookSecret) > 255 {
+		errs.Add("webhook_secret", "Webhook secret must be less than 255 characters")
+	}
+}
+
+// Fetch the deliveries to the webhook of the job. Errors are logged and no
+// deliveries are returned
+func jobWebhooks(ctx *app.AppContext, job *model.Job) []*model.WebhookDelivery {
+	if job.Webhook == "" {
+		return nil
+	}
+
+	deliveries, err := model.FetchJobWebhooks(ctx.DB, job.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"job_id": job.ID,
+		}).Error("Failed to fetch webhook deliveries")
+		return nil
+	}
+
+	return deliveries
+}
+
+// Delivery log of the webhook of the job in JSON
+func WebhookLogHandler(ctx *app.AppContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job := fetchRequestJob(ctx, w, r)
+		if job == nil {
+			return
+		}
+
+		deliveries := jobWebhooks(ctx, job)
+		if deliveries == nil {
+			deliveries = []*model.WebhookDelivery{}
+		}
+
+		out, err := json.Marshal(deliveries)
+		if err != nil {
+			requestLog(r).WithFields(log.Fields{
+				"error":  err.Error(),
+				"job_id": job.ID,
+			}).Error("Error encoding webhook deliveries as json")
+			ctx.RenderError(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	})
+}
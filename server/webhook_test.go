@@ -0,0 +1,111 @@
+// Copyright 2017 DENSSWeb Authors. All rights reserved.
+//
+// This file is part of DENSSWeb.
+//
+// DENSSWeb is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// DENSSWeb is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with DENSSWeb.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ubccr/denssweb/app"
+	"github.com/ubccr/denssweb/model"
+)
+
+func TestSetWebhook(t *testing.T) {
+	tests := []struct {
+		form url.Values
+		errs []string
+	}{
+		{url.Values{}, nil},
+		{url.Values{"webhook": {"https://lims.example.edu"}, "webhook_secret": {"secret"}}, nil},
+		{url.Values{"webhook": {"https://lims.example.edu"}}, []string{"webhook_secret"}},
+		{url.Values{"webhook": {"file:///etc/passwd"}, "webhook_secret": {"secret"}}, []string{"webhook"}},
+		{url.Values{"webhook": {"http://169.254.169.254/latest/meta-data"}, "webhook_secret": {"secret"}}, []string{"webhook"}},
+		{url.Values{"webhook": {"http://localhost:8080/"}, "webhook_secret": {"secret"}}, []string{"webhook"}},
+	}
+
+	for _, test := range tests {
+		job := &model.Job{}
+		errs := make(model.FieldErrors)
+		setWebhook(job, test.form, errs)
+		if len(errs) != len(test.errs) {
+			t.Errorf("Incorrect errors for %v: %v", test.form, errs)
+		}
+		for _, k := range test.errs {
+			if _, ok := errs[k]; !ok {
+				t.Errorf("Missing %s error for %v", k, test.form)
+			}
+		}
+	}
+}
+
+func TestSubmitWebhook(t *testing.T) {
+	viper.Set("templates", filepath.Join("..", "templates"))
+	viper.Set("dsn", ":memory:")
+	viper.Set("job_webhooks", true)
+	defer viper.Set("job_webhooks", nil)
+
+	ctx, err := app.NewAppContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := newSubmitOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	SubmitHandler(ctx, newDraftStore(time.Minute), opts).ServeHTTP(w, submitForm(t, map[string]string{
+		"name":           "lysozyme",
+		"webhook":        "https://lims.example.edu/hook",
+		"webhook_secret": "secret",
+	}, testDAT()))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Submission with webhook should succeed: got %d", w.Code)
+	}
+
+	token := w.Header().Get("Location")[strings.LastIndex(w.Header().Get("Location"), "/")+1:]
+	job, err := model.FetchJob(ctx.DB, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Webhook != "https://lims.example.edu/hook" || job.WebhookSecret != "secret" {
+		t.Errorf("Webhook not saved: %s", job.Webhook)
+	}
+
+	w = httptest.NewRecorder()
+	WebhookLogHandler(ctx).ServeHTTP(w, jobRequest("GET", "/job/"+token+"/webhooks.json", job))
+
+	var deliveries []map[string]interface{}
+	err = json.Unmarshal(w.Body.Bytes(), &deliveries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0]["event"] != model.EventQueued || deliveries[0]["status"] != model.WebhookPending {
+		t.Errorf("Queued event should be logged: %v", deliveries)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Delivery log should not include the signature or secret")
+	}
+}